@@ -0,0 +1,400 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"server/internal/models"
+	"server/internal/services"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxTorrentFileSize bounds uploaded .torrent files.
+const maxTorrentFileSize = 10 << 20
+
+type AdminController struct {
+	torrentService *services.TorrentService
+	movieService   *services.MovieService
+	libraryService *services.LibraryService
+	storageService *services.StorageService
+	reconciler     *services.Reconciler
+	commentService *services.CommentService
+}
+
+func NewAdminController(ts *services.TorrentService, ms *services.MovieService, ls *services.LibraryService, ss *services.StorageService, r *services.Reconciler, cs *services.CommentService) *AdminController {
+	return &AdminController{
+		torrentService: ts,
+		movieService:   ms,
+		libraryService: ls,
+		storageService: ss,
+		reconciler:     r,
+		commentService: cs,
+	}
+}
+
+// GetTorrentRateLimits godoc
+//
+//	@Summary		Torrent rate limits
+//	@Description	Get the global torrent upload and download rate limits in bytes per second (0 means unlimited)
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{object}	services.RateLimits
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/torrents/rate-limits [get]
+func (c *AdminController) GetTorrentRateLimits(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.torrentService.RateLimits())
+}
+
+// UpdateTorrentRateLimits godoc
+//
+//	@Summary		Update torrent rate limits
+//	@Description	Change the global torrent upload and download rate limits at runtime, in bytes per second (0 means unlimited)
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			body	body		services.RateLimits	true	"New rate limits"
+//	@Success		200		{object}	services.RateLimits
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403		{object}	utils.HTTPError
+//	@Router			/admin/torrents/rate-limits [put]
+func (c *AdminController) UpdateTorrentRateLimits(ctx echo.Context) error {
+	var limits services.RateLimits
+	if err := ctx.Bind(&limits); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if limits.UploadRateLimit < 0 || limits.DownloadRateLimit < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Rate limits must not be negative")
+	}
+
+	c.torrentService.SetRateLimits(limits)
+
+	return ctx.JSON(http.StatusOK, c.torrentService.RateLimits())
+}
+
+// ListDownloads godoc
+//
+//	@Summary		Active downloads
+//	@Description	List the download progress and swarm statistics of every active torrent
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{array}		services.DownloadStatus
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/downloads [get]
+func (c *AdminController) ListDownloads(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.torrentService.ListDownloadStatuses())
+}
+
+type ImportTorrentReq struct {
+	Magnet   string                `form:"magnet"`
+	Torrent  *multipart.FileHeader `form:"torrent"`
+	FilePath string                `form:"file_path"`
+}
+
+// ImportMovieTorrent godoc
+//
+//	@Summary		Attach a torrent to a movie
+//	@Description	Attach a magnet link or an uploaded .torrent file to a TMDB movie, replacing the automatic torrent selection. The optional file_path chooses the file to stream inside the torrent.
+//	@Tags			admin
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id			path		int		true	"TMDB movie ID"
+//	@Param			magnet		formData	string	false	"Magnet link"
+//	@Param			torrent		formData	file	false	".torrent file"
+//	@Param			file_path	formData	string	false	"File to stream inside the torrent"
+//	@Success		201			{object}	services.TorrentOverrideInfo
+//	@Failure		400			{object}	utils.HTTPError
+//	@Failure		401			{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403			{object}	utils.HTTPError
+//	@Router			/admin/movies/{id}/torrent [post]
+func (c *AdminController) ImportMovieTorrent(ctx echo.Context) error {
+	movieID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+
+	var form ImportTorrentReq
+	if err := ctx.Bind(&form); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	var torrentFile []byte
+	if form.Torrent != nil {
+		torrentFile, err = readTorrentFile(form.Torrent)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	user := ctx.Get("model").(models.User)
+
+	info, err := c.torrentService.ImportTorrent(services.TorrentImport{
+		MovieID:     movieID,
+		MagnetLink:  form.Magnet,
+		TorrentFile: torrentFile,
+		FilePath:    form.FilePath,
+		CreatedBy:   user.ID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.movieService.ResetMovieStream(movieID); err != nil {
+		services.Logger.Error(fmt.Sprintf("Failed to reset stream of movie %d: %v", movieID, err))
+	}
+
+	return ctx.JSON(http.StatusCreated, info)
+}
+
+// GetMovieTorrent godoc
+//
+//	@Summary		Torrent attached to a movie
+//	@Description	Get the torrent an administrator attached to a movie and the files it contains, when known
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id	path		int	true	"TMDB movie ID"
+//	@Success		200	{object}	services.TorrentOverrideInfo
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Failure		404	{object}	utils.HTTPError
+//	@Router			/admin/movies/{id}/torrent [get]
+func (c *AdminController) GetMovieTorrent(ctx echo.Context) error {
+	movieID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+
+	info, ok := c.torrentService.TorrentOverrideInfo(movieID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "No torrent attached to this movie")
+	}
+
+	return ctx.JSON(http.StatusOK, info)
+}
+
+// DeleteMovieTorrent godoc
+//
+//	@Summary		Detach a torrent from a movie
+//	@Description	Remove the torrent an administrator attached to a movie. Files already downloaded are kept; later downloads use the automatic torrent selection again.
+//	@Tags			admin
+//	@Security		JWT
+//	@Param			id	path	int	true	"TMDB movie ID"
+//	@Success		204
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/movies/{id}/torrent [delete]
+func (c *AdminController) DeleteMovieTorrent(ctx echo.Context) error {
+	movieID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+
+	if err := c.torrentService.DeleteTorrentOverride(movieID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove torrent")
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// GetLibraryScan godoc
+//
+//	@Summary		Library scan result
+//	@Description	Get the result of the latest scan of the local media library directories
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{object}	services.LibraryScanResult
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/library [get]
+func (c *AdminController) GetLibraryScan(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.libraryService.LastScan())
+}
+
+// GetLibraryScanStatus godoc
+//
+//	@Summary		Library scan status
+//	@Description	Get whether a scan of the local media library directories is running, with the result of the latest one
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{object}	services.LibraryScanStatus
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/library/scan [get]
+func (c *AdminController) GetLibraryScanStatus(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.libraryService.Status())
+}
+
+// ScanLibrary godoc
+//
+//	@Summary		Scan the library
+//	@Description	Start a scan of the local media library directories that registers new, moved and deleted movie files, unless one is already running. Its progress is reported by GET /admin/library/scan.
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		202	{object}	services.LibraryScanStatus
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/library/scan [post]
+func (c *AdminController) ScanLibrary(ctx echo.Context) error {
+	return ctx.JSON(http.StatusAccepted, c.libraryService.StartScan())
+}
+
+// GetStorage godoc
+//
+//	@Summary		Storage usage
+//	@Description	Get the disk usage and budgets of the downloads and the HLS output, with their items least recently watched first
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{object}	services.StorageReport
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/storage [get]
+func (c *AdminController) GetStorage(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.storageService.Report())
+}
+
+// EnforceStorage godoc
+//
+//	@Summary		Enforce storage budgets
+//	@Description	Evict idle movies and the least recently watched ones until the downloads and the HLS output fit their budgets
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{array}		services.StorageItem
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/storage/enforce [post]
+func (c *AdminController) EnforceStorage(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.storageService.Enforce())
+}
+
+// GetReconciliation godoc
+//
+//	@Summary		Reconciliation result
+//	@Description	Get the changes made by the latest check of the database against the downloads, subtitles and HLS output on disk
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{object}	services.ReconcileResult
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/reconcile [get]
+func (c *AdminController) GetReconciliation(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.reconciler.LastRun())
+}
+
+// Reconcile godoc
+//
+//	@Summary		Reconcile storage
+//	@Description	Check the database against the files on disk now: delete rows whose file is missing and quarantine orphaned downloads and unfinished HLS output
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{object}	services.ReconcileResult
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Router			/admin/reconcile [post]
+func (c *AdminController) Reconcile(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.reconciler.Reconcile())
+}
+
+// GetCommentReports godoc
+//
+//	@Summary		Comment moderation queue
+//	@Description	List the reported comments with their reports, the most reported first, 20 per page. Hidden and removed comments are included
+//	@Tags			admin
+//	@Produce		json
+//	@Security		JWT
+//	@Param			status	query		string	false	"Status of the reports: open (default), resolved or dismissed"
+//	@Param			page	query		int		false	"Page number (default: 1)"
+//	@Success		200		{array}		controllers.ReportedCommentResponse
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/admin/comments/reports [get]
+func (c *AdminController) GetCommentReports(ctx echo.Context) error {
+	page := 1
+	if p, err := strconv.Atoi(ctx.QueryParam("page")); err == nil && p > 0 {
+		page = p
+	}
+
+	queue, err := c.commentService.ReportQueue(ctx.QueryParam("status"), page)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp := make([]ReportedCommentResponse, 0, len(queue))
+	for _, item := range queue {
+		resp = append(resp, ReportedCommentResponse{
+			Comment: item.Comment,
+			Reports: item.Reports,
+		})
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// ModerateComment godoc
+//
+//	@Summary		Moderate a comment
+//	@Description	Hide a comment from everyone, remove its content while keeping its replies, restore it, or dismiss its reports. Its open reports are closed
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int						true	"Comment ID"
+//	@Param			body	body		ModerateCommentRequest	true	"Moderation action"
+//	@Success		200		{object}	models.Comment
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403		{object}	utils.HTTPError
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/admin/comments/{id}/moderate [post]
+func (c *AdminController) ModerateComment(ctx echo.Context) error {
+	id, err := commentID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req ModerateCommentRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	admin := ctx.Get("model").(models.User)
+	comment, err := c.commentService.Moderate(id, admin.ID, req.Action)
+	if err != nil {
+		return commentError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, comment)
+}
+
+func readTorrentFile(fh *multipart.FileHeader) ([]byte, error) {
+	if fh.Size > maxTorrentFileSize {
+		return nil, fmt.Errorf("torrent file is too large")
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read torrent file")
+	}
+	defer f.Close()
+
+	return io.ReadAll(io.LimitReader(f, maxTorrentFileSize))
+}
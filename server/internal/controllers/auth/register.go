@@ -49,13 +49,6 @@ func Register(c echo.Context) error {
 	user.LastName = newUser.LastName
 	user.Password = newUser.Password
 	user.Username = newUser.Username
-	if newUser.Picture != nil {
-		avatarUrl, err := services.UploadPicture(newUser.Picture, user.Username)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, err)
-		}
-		user.Avatar = avatarUrl
-	}
 
 	err = services.ValidateStruct(newUser)
 	if err != nil {
@@ -75,8 +68,18 @@ func Register(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	if newUser.Picture != nil {
+		avatar, err := services.UploadPicture(newUser.Picture)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		user.Avatar = avatar.URL
+	}
+
 	_, err = users.CreateUser(user)
 	if err != nil {
+		// The picture was stored for a user that does not exist.
+		services.ReleaseAvatar(user.Avatar)
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	return c.String(http.StatusOK, "success")
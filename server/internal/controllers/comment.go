@@ -1,36 +1,117 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"server/internal/models"
+	"server/internal/services"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
 type CommentController struct {
-	db *gorm.DB
+	db               *gorm.DB
+	commentService   *services.CommentService
+	websocketService *services.WebSocketService
 }
 
-func NewCommentController(db *gorm.DB) *CommentController {
+func NewCommentController(db *gorm.DB, cs *services.CommentService, ws *services.WebSocketService) *CommentController {
 	return &CommentController{
-		db: db,
+		db:               db,
+		commentService:   cs,
+		websocketService: ws,
 	}
 }
 
+// ReactionRequest represents the payload to react to a comment
+type ReactionRequest struct {
+	Type string `json:"type" example:"like" enums:"like,dislike,love,laugh,wow,sad"`
+}
+
+func commentResponse(view services.CommentView) CommentResponse {
+	resp := CommentResponse{
+		ID:         view.ID,
+		MovieID:    view.MovieID,
+		ParentID:   view.ParentID,
+		Depth:      view.Depth,
+		Username:   view.Username,
+		Avatar:     view.Avatar,
+		Date:       view.CreatedAt.Format(time.RFC3339),
+		Timestamp:  view.Timestamp,
+		Content:    view.Content,
+		Status:     view.Status,
+		ReplyCount: view.ReplyCount,
+		Reactions:  view.Reactions,
+		MyReaction: view.MyReaction,
+	}
+	if view.EditedAt != nil {
+		resp.EditedAt = view.EditedAt.Format(time.RFC3339)
+	}
+	return resp
+}
+
+func commentPageResponse(page services.CommentPage) CommentPageResponse {
+	resp := CommentPageResponse{
+		Comments:   make([]CommentResponse, 0, len(page.Comments)),
+		NextCursor: page.NextCursor,
+	}
+	for _, view := range page.Comments {
+		resp.Comments = append(resp.Comments, commentResponse(view))
+	}
+	return resp
+}
+
+func commentError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrCommentNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Comment not found")
+	case errors.Is(err, services.ErrInvalidComment), errors.Is(err, services.ErrInvalidCursor),
+		errors.Is(err, services.ErrInvalidReaction), errors.Is(err, services.ErrReplyTooDeep),
+		errors.Is(err, services.ErrInvalidReply), errors.Is(err, services.ErrCommentRejected),
+		errors.Is(err, services.ErrInvalidReport), errors.Is(err, services.ErrInvalidModeration),
+		errors.Is(err, services.ErrCannotReportOwnPost):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrCommentModerated):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrCommentRateLimited):
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+func commentID(ctx echo.Context) (uint, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
+	}
+	return uint(id), nil
+}
+
+func commentQuery(ctx echo.Context) services.CommentQuery {
+	q := services.CommentQuery{Cursor: ctx.QueryParam("cursor")}
+	if l, err := strconv.Atoi(ctx.QueryParam("limit")); err == nil && l > 0 {
+		q.Limit = l
+	}
+	return q
+}
+
 // AddComment godoc
 //
 //	@Summary		Add comment
-//	@Description	Add a new comment for a movie
+//	@Description	Add a new comment for a movie as the current user, or a reply to a comment when parent_id is set. Replies can be nested 3 levels deep. A comment with a timestamp is anchored to that moment of the movie and pushed to its viewers over the movie WebSocket. Banned words are masked or rejected, and users posting too fast are rate limited
 //	@Tags			comments
 //	@Accept			json
 //	@Produce		json
 //	@Security		JWT
 //	@Param			body	body		AddCommentRequest	true	"Comment body"
-//	@Success		200		{object}	controllers.CommentResponse
+//	@Success		200		{object}	CommentResponse
 //	@Failure		400		{object}	utils.HTTPError
 //	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404		{object}	utils.HTTPError
+//	@Failure		429		{object}	utils.HTTPError
 //	@Failure		500		{object}	utils.HTTPError
 //	@Router			/comments/add [post]
 func (c *CommentController) AddComment(ctx echo.Context) error {
@@ -46,49 +127,153 @@ func (c *CommentController) AddComment(ctx echo.Context) error {
 	}
 
 	comment := models.Comment{
-		MovieID:  requestComment.MovieID,
-		UserID:   int(user.ID),
-		Username: requestComment.Username,
-		Avatar:   user.Avatar,
-		Content:  requestComment.Content,
+		MovieID:   requestComment.MovieID,
+		UserID:    int(user.ID),
+		Username:  user.Username,
+		Avatar:    user.Avatar,
+		Content:   requestComment.Content,
+		ParentID:  requestComment.ParentID,
+		Timestamp: requestComment.Timestamp,
 	}
 
-	if err := c.db.Create(&comment).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add comment")
+	if err := c.commentService.Create(&comment); err != nil {
+		return commentError(err)
+	}
+
+	response := commentResponse(services.CommentView{Comment: comment, Reactions: map[string]int64{}})
+	if comment.Timestamp != nil {
+		c.websocketService.Broadcast(comment.MovieID, services.TimedCommentEvent{
+			Type:    "timed_comment",
+			Comment: response,
+		})
 	}
 
-	return ctx.JSON(http.StatusOK, comment)
+	return ctx.JSON(http.StatusOK, response)
 }
 
+// GetComments godoc
+//
+//	@Summary		List comments
+//	@Description	Get the comments of every movie, latest first, paginated with the next_cursor of the previous page
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			cursor	query		string	false	"Cursor of the page"
+//	@Param			limit	query		int		false	"Comments per page (default: 20, max: 100)"
+//	@Success		200		{object}	CommentPageResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/comments [get]
 func (c *CommentController) GetComments(ctx echo.Context) error {
-	var comments []models.Comment
-	if err := c.db.Order("created_at DESC").Find(&comments).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve comments")
-	}
-
-	// GetComments godoc
-	//
-	//	@Summary		List comments
-	//	@Description	Get all comments (latest first)
-	//	@Tags			comments
-	//	@Accept			json
-	//	@Produce		json
-	//	@Security		JWT
-	//	@Success		200	{array}		CommentResponse
-	//	@Failure		500	{object}	utils.HTTPError
-	//	@Router			/comments [get]
-	var response []CommentResponse
-	for _, comment := range comments {
-		response = append(response, CommentResponse{
-			ID:       comment.ID,
-			Username: comment.Username,
-			Avatar:   comment.Avatar,
-			Date:     comment.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-			Content:  comment.Content,
-		})
+	user := ctx.Get("model").(models.User)
+	page, err := c.commentService.List(commentQuery(ctx), &user.ID)
+	if err != nil {
+		return commentError(err)
 	}
 
-	return ctx.JSON(http.StatusOK, response)
+	return ctx.JSON(http.StatusOK, commentPageResponse(page))
+}
+
+// GetMovieComments godoc
+//
+//	@Summary		List movie comments
+//	@Description	Get the comments of a movie, without their replies, latest first, paginated with the next_cursor of the previous page
+//	@Tags			comments
+//	@Produce		json
+//	@Param			id		path		int		true	"Movie ID"
+//	@Param			cursor	query		string	false	"Cursor of the page"
+//	@Param			limit	query		int		false	"Comments per page (default: 20, max: 100)"
+//	@Success		200		{object}	CommentPageResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/movies/{id}/comments [get]
+func (c *CommentController) GetMovieComments(ctx echo.Context) error {
+	movieID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || movieID <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+
+	q := commentQuery(ctx)
+	q.MovieID = movieID
+	page, err := c.commentService.List(q, optionalUserID(ctx))
+	if err != nil {
+		return commentError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, commentPageResponse(page))
+}
+
+// GetTimedComments godoc
+//
+//	@Summary		List timed comments
+//	@Description	Get the comments anchored to a window of a movie, in seconds, in the order they appear, to render them over the player. At most 500 are returned
+//	@Tags			comments
+//	@Produce		json
+//	@Param			id		path		int	true	"Movie ID"
+//	@Param			from	query		int	false	"Start of the window (default: 0)"
+//	@Param			to		query		int	false	"End of the window (default: 5 minutes after the start)"
+//	@Success		200		{array}		CommentResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/movies/{id}/comments/timed [get]
+func (c *CommentController) GetTimedComments(ctx echo.Context) error {
+	movieID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || movieID <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+
+	from, to := 0, -1
+	if v := ctx.QueryParam("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid window start")
+		}
+	}
+	if v := ctx.QueryParam("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil || to < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid window end")
+		}
+	}
+
+	views, err := c.commentService.Timed(movieID, from, to, optionalUserID(ctx))
+	if err != nil {
+		return commentError(err)
+	}
+
+	resp := make([]CommentResponse, 0, len(views))
+	for _, view := range views {
+		resp = append(resp, commentResponse(view))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// GetCommentReplies godoc
+//
+//	@Summary		List replies
+//	@Description	Get the replies to a comment, oldest first, paginated with the next_cursor of the previous page
+//	@Tags			comments
+//	@Produce		json
+//	@Param			id		path		int		true	"Comment ID"
+//	@Param			cursor	query		string	false	"Cursor of the page"
+//	@Param			limit	query		int		false	"Replies per page (default: 20, max: 100)"
+//	@Success		200		{object}	CommentPageResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/comments/{id}/replies [get]
+func (c *CommentController) GetCommentReplies(ctx echo.Context) error {
+	id, err := commentID(ctx)
+	if err != nil {
+		return err
+	}
+
+	q := commentQuery(ctx)
+	q.ParentID = &id
+	page, err := c.commentService.List(q, optionalUserID(ctx))
+	if err != nil {
+		return commentError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, commentPageResponse(page))
 }
 
 // GetCommentByID godoc
@@ -107,35 +292,23 @@ func (c *CommentController) GetComments(ctx echo.Context) error {
 //	@Failure		500	{object}	utils.HTTPError
 //	@Router			/comments/{id} [get]
 func (c *CommentController) GetCommentByID(ctx echo.Context) error {
-	id := ctx.Param("id")
-	commentID, err := strconv.ParseUint(id, 10, 32)
+	id, err := commentID(ctx)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
-	}
-
-	var comment models.Comment
-	if err := c.db.First(&comment, commentID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "Comment not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve comment")
+		return err
 	}
 
-	response := CommentResponse{
-		ID:       comment.ID,
-		Username: comment.Username,
-		Avatar:   comment.Avatar,
-		Date:     comment.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-		Content:  comment.Content,
+	user := ctx.Get("model").(models.User)
+	view, err := c.commentService.Get(id, &user.ID)
+	if err != nil {
+		return commentError(err)
 	}
 
-	return ctx.JSON(http.StatusOK, response)
+	return ctx.JSON(http.StatusOK, commentResponse(view))
 }
 
 // UpdateCommentRequest represents the payload to update a comment
 type UpdateCommentRequest struct {
-	Content  string `json:"content"`
-	Username string `json:"username"`
+	Content string `json:"content"`
 }
 
 // UpdateComment godoc
@@ -156,10 +329,9 @@ type UpdateCommentRequest struct {
 //	@Failure		500		{object}	utils.HTTPError
 //	@Router			/comments/{id} [patch]
 func (c *CommentController) UpdateComment(ctx echo.Context) error {
-	id := ctx.Param("id")
-	commentID, err := strconv.ParseUint(id, 10, 32)
+	id, err := commentID(ctx)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
+		return err
 	}
 
 	var requestData UpdateCommentRequest
@@ -168,20 +340,25 @@ func (c *CommentController) UpdateComment(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request data")
 	}
 
+	user, exists := ctx.Get("model").(models.User)
+	if !exists {
+		return echo.NewHTTPError(http.StatusUnauthorized, "User not authenticated")
+	}
+
 	var comment models.Comment
-	if err := c.db.First(&comment, commentID).Error; err != nil {
+	if err := c.db.First(&comment, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "Comment not found")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve comment")
 	}
 
-	if comment.Username != requestData.Username {
+	if comment.UserID != int(user.ID) {
 		return echo.NewHTTPError(http.StatusForbidden, "You can only update your own comments")
 	}
 
-	if err := c.db.Model(&comment).Update("content", requestData.Content).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update comment")
+	if err := c.commentService.Edit(&comment, requestData.Content); err != nil {
+		return commentError(err)
 	}
 
 	return ctx.JSON(http.StatusOK, map[string]string{"message": "Comment updated successfully"})
@@ -190,7 +367,7 @@ func (c *CommentController) UpdateComment(ctx echo.Context) error {
 // DeleteComment godoc
 //
 //	@Summary		Delete comment
-//	@Description	Delete an existing comment (owner only)
+//	@Description	Delete an existing comment (owner only). A comment with replies is kept for them, marked removed with its content cleared
 //	@Tags			comments
 //	@Accept			json
 //	@Produce		json
@@ -204,10 +381,9 @@ func (c *CommentController) UpdateComment(ctx echo.Context) error {
 //	@Failure		500	{object}	utils.HTTPError
 //	@Router			/comments/{id} [delete]
 func (c *CommentController) DeleteComment(ctx echo.Context) error {
-	id := ctx.Param("id")
-	commentID, err := strconv.ParseUint(id, 10, 32)
+	id, err := commentID(ctx)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
+		return err
 	}
 
 	user, exists := ctx.Get("model").(models.User)
@@ -216,7 +392,7 @@ func (c *CommentController) DeleteComment(ctx echo.Context) error {
 	}
 
 	var comment models.Comment
-	if err := c.db.First(&comment, commentID).Error; err != nil {
+	if err := c.db.First(&comment, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "Comment not found")
 		}
@@ -227,9 +403,109 @@ func (c *CommentController) DeleteComment(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusForbidden, "You can only delete your own comments")
 	}
 
-	if err := c.db.Delete(&comment).Error; err != nil {
+	if err := c.commentService.Delete(comment); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete comment")
 	}
 
 	return ctx.JSON(http.StatusOK, map[string]string{"message": "Comment deleted successfully"})
 }
+
+// ReactToComment godoc
+//
+//	@Summary		React to comment
+//	@Description	Set the reaction of the current user to a comment, replacing their previous one
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int				true	"Comment ID"
+//	@Param			body	body		ReactionRequest	true	"Reaction"
+//	@Success		200		{object}	CommentResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/comments/{id}/reaction [put]
+func (c *CommentController) ReactToComment(ctx echo.Context) error {
+	id, err := commentID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req ReactionRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	view, err := c.commentService.React(id, user.ID, req.Type)
+	if err != nil {
+		return commentError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, commentResponse(view))
+}
+
+// RemoveCommentReaction godoc
+//
+//	@Summary		Remove reaction
+//	@Description	Remove the reaction of the current user to a comment
+//	@Tags			comments
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id	path		int	true	"Comment ID"
+//	@Success		200	{object}	CommentResponse
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404	{object}	utils.HTTPError
+//	@Failure		500	{object}	utils.HTTPError
+//	@Router			/comments/{id}/reaction [delete]
+func (c *CommentController) RemoveCommentReaction(ctx echo.Context) error {
+	id, err := commentID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	view, err := c.commentService.Unreact(id, user.ID)
+	if err != nil {
+		return commentError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, commentResponse(view))
+}
+
+// ReportComment godoc
+//
+//	@Summary		Report comment
+//	@Description	Report a comment to the administrators. Reporting a comment again updates the report
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int						true	"Comment ID"
+//	@Param			body	body		services.ReportRequest	true	"Report"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/comments/{id}/report [post]
+func (c *CommentController) ReportComment(ctx echo.Context) error {
+	id, err := commentID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req services.ReportRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.commentService.Report(id, user.ID, req); err != nil {
+		return commentError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, map[string]string{"message": "Comment reported"})
+}
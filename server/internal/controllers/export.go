@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"server/internal/models"
+	"server/internal/services"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ExportController struct {
+	exportService *services.ExportService
+}
+
+func NewExportController(es *services.ExportService) *ExportController {
+	return &ExportController{
+		exportService: es,
+	}
+}
+
+func exportResponse(export models.MovieExport) ExportResponse {
+	resp := ExportResponse{
+		ID:         export.ID,
+		MovieID:    export.MovieID,
+		SourceID:   export.SourceID,
+		Quality:    export.Quality,
+		AudioTrack: export.AudioTrack,
+		Subtitles:  []string{},
+		Status:     export.Status,
+		Error:      export.Error,
+		FileSize:   export.FileSize,
+		ExpiresAt:  export.ExpiresAt,
+		CreatedAt:  export.CreatedAt,
+	}
+	if export.Subtitles != "" {
+		resp.Subtitles = strings.Split(export.Subtitles, ",")
+	}
+	if export.Status == services.ExportReady {
+		resp.DownloadURL = fmt.Sprintf("/api/exports/%d/file", export.ID)
+	}
+	return resp
+}
+
+func exportError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrExportNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrExportNotReady), errors.Is(err, services.ErrStreamNotReady), errors.Is(err, services.ErrTooManyExports):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrInvalidExport):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrExportQueueFull):
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+func exportID(ctx echo.Context) (uint, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid export ID")
+	}
+	return uint(id), nil
+}
+
+// CreateExport godoc
+//
+//	@Summary		Export a movie
+//	@Description	Queue an MP4 export of a fully transcoded movie in the chosen quality, with the selected audio track and subtitles, to download for offline viewing
+//	@Tags			exports
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			body	body		services.ExportRequest	true	"Export options"
+//	@Success		202		{object}	controllers.ExportResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		409		{object}	utils.HTTPError
+//	@Failure		503		{object}	utils.HTTPError
+//	@Router			/exports [post]
+func (c *ExportController) CreateExport(ctx echo.Context) error {
+	var req services.ExportRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+	if req.MovieID <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+
+	user := ctx.Get("model").(models.User)
+	export, err := c.exportService.Create(user.ID, req)
+	if err != nil {
+		return exportError(err)
+	}
+
+	return ctx.JSON(http.StatusAccepted, exportResponse(export))
+}
+
+// ListExports godoc
+//
+//	@Summary		List exports
+//	@Description	List the offline exports of the current user, newest first
+//	@Tags			exports
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{array}		controllers.ExportResponse
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500	{object}	utils.HTTPError
+//	@Router			/exports [get]
+func (c *ExportController) ListExports(ctx echo.Context) error {
+	user := ctx.Get("model").(models.User)
+	exports, err := c.exportService.List(user.ID)
+	if err != nil {
+		return exportError(err)
+	}
+
+	resp := make([]ExportResponse, 0, len(exports))
+	for _, export := range exports {
+		resp = append(resp, exportResponse(export))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// GetExport godoc
+//
+//	@Summary		Get an export
+//	@Description	Get the status of an offline export of the current user
+//	@Tags			exports
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id	path		int	true	"Export ID"
+//	@Success		200	{object}	controllers.ExportResponse
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404	{object}	utils.HTTPError
+//	@Router			/exports/{id} [get]
+func (c *ExportController) GetExport(ctx echo.Context) error {
+	id, err := exportID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	export, err := c.exportService.Get(user.ID, id)
+	if err != nil {
+		return exportError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, exportResponse(export))
+}
+
+// DownloadExport godoc
+//
+//	@Summary		Download an export
+//	@Description	Download the MP4 file of a ready offline export of the current user. Range requests are supported, so downloads can be resumed
+//	@Tags			exports
+//	@Produce		video/mp4
+//	@Security		JWT
+//	@Param			id	path		int		true	"Export ID"
+//	@Success		200	{file}		binary	"MP4 file"
+//	@Success		206	{file}		binary	"Part of the MP4 file"
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404	{object}	utils.HTTPError
+//	@Failure		409	{object}	utils.HTTPError
+//	@Router			/exports/{id}/file [get]
+func (c *ExportController) DownloadExport(ctx echo.Context) error {
+	id, err := exportID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	export, err := c.exportService.File(user.ID, id)
+	if err != nil {
+		return exportError(err)
+	}
+
+	// Attachment serves the file with http.ServeContent, which answers Range
+	// requests.
+	return ctx.Attachment(export.FilePath, services.ExportFileName(export))
+}
+
+// DeleteExport godoc
+//
+//	@Summary		Delete an export
+//	@Description	Delete an offline export of the current user and its file
+//	@Tags			exports
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id	path		int	true	"Export ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404	{object}	utils.HTTPError
+//	@Router			/exports/{id} [delete]
+func (c *ExportController) DeleteExport(ctx echo.Context) error {
+	id, err := exportID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.exportService.Delete(user.ID, id); err != nil {
+		return exportError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "Export deleted"})
+}
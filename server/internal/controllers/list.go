@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"server/internal/models"
+	"server/internal/services"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ListController struct {
+	listService *services.ListService
+}
+
+func NewListController(ls *services.ListService) *ListController {
+	return &ListController{
+		listService: ls,
+	}
+}
+
+type AddCollaboratorRequest struct {
+	Username string `json:"username" example:"fturing"`
+}
+
+type ReorderListRequest struct {
+	MovieIDs []int `json:"movie_ids" example:"603,27205,155"`
+}
+
+func listResponse(list models.MovieList, userID *uint) ListResponse {
+	resp := ListResponse{
+		ID:            list.ID,
+		Slug:          list.Slug,
+		Title:         list.Title,
+		Description:   list.Description,
+		Privacy:       list.Privacy,
+		Owner:         list.Owner.Username,
+		Collaborators: make([]string, 0, len(list.Collaborators)),
+		CanEdit:       userID != nil && services.CanEdit(list, *userID),
+		ItemCount:     len(list.Items),
+		Items:         list.Items,
+		CreatedAt:     list.CreatedAt,
+		UpdatedAt:     list.UpdatedAt,
+	}
+	for _, collaborator := range list.Collaborators {
+		resp.Collaborators = append(resp.Collaborators, collaborator.User.Username)
+	}
+	if resp.Items == nil {
+		resp.Items = []models.MovieListItem{}
+	}
+	if list.Privacy != models.ListPrivate {
+		resp.ShareURL = fmt.Sprintf("/api/lists/public/%s", list.Slug)
+	}
+	return resp
+}
+
+func listsResponse(lists []models.MovieList, userID *uint) []ListResponse {
+	resp := make([]ListResponse, 0, len(lists))
+	for _, list := range lists {
+		resp = append(resp, listResponse(list, userID))
+	}
+	return resp
+}
+
+func listError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrListNotFound), errors.Is(err, services.ErrMovieNotFound), errors.Is(err, services.ErrUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrListForbidden):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrInvalidList), errors.Is(err, services.ErrInvalidListOrder), errors.Is(err, services.ErrInvalidCollaborator):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrListFull):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+func listID(ctx echo.Context) (uint, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid list ID")
+	}
+	return uint(id), nil
+}
+
+// optionalUserID returns the ID of the user attached by AttachUserOptional,
+// nil for anonymous requests.
+func optionalUserID(ctx echo.Context) *uint {
+	if user, ok := ctx.Get("model").(models.User); ok {
+		return &user.ID
+	}
+	return nil
+}
+
+// CreateList godoc
+//
+//	@Summary		Create a list
+//	@Description	Create a named movie list owned by the current user. Lists are private unless their privacy is "unlisted", readable by anyone with the link, or "public"
+//	@Tags			lists
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			body	body		services.ListRequest	true	"List"
+//	@Success		201		{object}	controllers.ListResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Router			/lists [post]
+func (c *ListController) CreateList(ctx echo.Context) error {
+	var req services.ListRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	list, err := c.listService.Create(user.ID, req)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusCreated, listResponse(list, &user.ID))
+}
+
+// GetLists godoc
+//
+//	@Summary		List my lists
+//	@Description	List the movie lists the current user owns or collaborates on, most recently updated first
+//	@Tags			lists
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{array}		controllers.ListResponse
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500	{object}	utils.HTTPError
+//	@Router			/lists [get]
+func (c *ListController) GetLists(ctx echo.Context) error {
+	user := ctx.Get("model").(models.User)
+	lists, err := c.listService.Lists(user.ID)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listsResponse(lists, &user.ID))
+}
+
+// GetPublicLists godoc
+//
+//	@Summary		Browse public lists
+//	@Description	List the public movie lists of every user, most recently updated first
+//	@Tags			lists
+//	@Produce		json
+//	@Param			page	query		int	false	"Page number (default: 1)"
+//	@Success		200		{array}		controllers.ListResponse
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/lists/public [get]
+func (c *ListController) GetPublicLists(ctx echo.Context) error {
+	page := 1
+	if pageStr := ctx.QueryParam("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	const perPage = 20
+	lists, err := c.listService.PublicLists(perPage, (page-1)*perPage)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listsResponse(lists, optionalUserID(ctx)))
+}
+
+// GetListBySlug godoc
+//
+//	@Summary		Read a shared list
+//	@Description	Get a public or unlisted movie list from its slug, without being logged in. Private lists are only returned to their owner and collaborators
+//	@Tags			lists
+//	@Produce		json
+//	@Param			slug	path		string	true	"List slug"
+//	@Success		200		{object}	controllers.ListResponse
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/lists/public/{slug} [get]
+func (c *ListController) GetListBySlug(ctx echo.Context) error {
+	userID := optionalUserID(ctx)
+	list, err := c.listService.BySlug(userID, ctx.Param("slug"))
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listResponse(list, userID))
+}
+
+// GetList godoc
+//
+//	@Summary		Get a list
+//	@Description	Get a public movie list, or one the current user owns or collaborates on. Unlisted lists are only returned from their slug
+//	@Tags			lists
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id	path		int	true	"List ID"
+//	@Success		200	{object}	controllers.ListResponse
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404	{object}	utils.HTTPError
+//	@Router			/lists/{id} [get]
+func (c *ListController) GetList(ctx echo.Context) error {
+	id, err := listID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	list, err := c.listService.Get(&user.ID, id)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listResponse(list, &user.ID))
+}
+
+// UpdateList godoc
+//
+//	@Summary		Update a list
+//	@Description	Change the title, description or privacy of a list of the current user. Empty fields are left unchanged
+//	@Tags			lists
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int						true	"List ID"
+//	@Param			body	body		services.ListRequest	true	"List"
+//	@Success		200		{object}	controllers.ListResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403		{object}	utils.HTTPError
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/lists/{id} [patch]
+func (c *ListController) UpdateList(ctx echo.Context) error {
+	id, err := listID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req services.ListRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	list, err := c.listService.Update(user.ID, id, req)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listResponse(list, &user.ID))
+}
+
+// DeleteList godoc
+//
+//	@Summary		Delete a list
+//	@Description	Delete a list of the current user with its movies
+//	@Tags			lists
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id	path		int	true	"List ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403	{object}	utils.HTTPError
+//	@Failure		404	{object}	utils.HTTPError
+//	@Router			/lists/{id} [delete]
+func (c *ListController) DeleteList(ctx echo.Context) error {
+	id, err := listID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.listService.Delete(user.ID, id); err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "List deleted"})
+}
+
+// AddListItem godoc
+//
+//	@Summary		Add a movie to a list
+//	@Description	Add a movie at the end of a list the current user owns or collaborates on. A movie already in the list keeps its place
+//	@Tags			lists
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int	true	"List ID"
+//	@Param			movieId	path		int	true	"Movie ID"
+//	@Success		200		{object}	controllers.ListResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403		{object}	utils.HTTPError
+//	@Failure		404		{object}	utils.HTTPError
+//	@Failure		409		{object}	utils.HTTPError
+//	@Router			/lists/{id}/items/{movieId} [put]
+func (c *ListController) AddListItem(ctx echo.Context) error {
+	id, err := listID(ctx)
+	if err != nil {
+		return err
+	}
+	movieID, err := historyMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	list, err := c.listService.AddItem(user.ID, id, movieID)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listResponse(list, &user.ID))
+}
+
+// RemoveListItem godoc
+//
+//	@Summary		Remove a movie from a list
+//	@Description	Remove a movie from a list the current user owns or collaborates on
+//	@Tags			lists
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int	true	"List ID"
+//	@Param			movieId	path		int	true	"Movie ID"
+//	@Success		200		{object}	controllers.ListResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403		{object}	utils.HTTPError
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/lists/{id}/items/{movieId} [delete]
+func (c *ListController) RemoveListItem(ctx echo.Context) error {
+	id, err := listID(ctx)
+	if err != nil {
+		return err
+	}
+	movieID, err := historyMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	list, err := c.listService.RemoveItem(user.ID, id, movieID)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listResponse(list, &user.ID))
+}
+
+// ReorderListItems godoc
+//
+//	@Summary		Reorder a list
+//	@Description	Order a list the current user owns or collaborates on as the given movies, which must be every movie of the list
+//	@Tags			lists
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int					true	"List ID"
+//	@Param			body	body		ReorderListRequest	true	"New order of the list"
+//	@Success		200		{object}	controllers.ListResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403		{object}	utils.HTTPError
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/lists/{id}/items [put]
+func (c *ListController) ReorderListItems(ctx echo.Context) error {
+	id, err := listID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req ReorderListRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	list, err := c.listService.ReorderItems(user.ID, id, req.MovieIDs)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listResponse(list, &user.ID))
+}
+
+// AddListCollaborator godoc
+//
+//	@Summary		Invite a collaborator
+//	@Description	Invite a user to edit the movies of a list of the current user
+//	@Tags			lists
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int						true	"List ID"
+//	@Param			body	body		AddCollaboratorRequest	true	"Collaborator"
+//	@Success		200		{object}	controllers.ListResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403		{object}	utils.HTTPError
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/lists/{id}/collaborators [post]
+func (c *ListController) AddListCollaborator(ctx echo.Context) error {
+	id, err := listID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req AddCollaboratorRequest
+	if err := ctx.Bind(&req); err != nil || req.Username == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	list, err := c.listService.AddCollaborator(user.ID, id, req.Username)
+	if err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, listResponse(list, &user.ID))
+}
+
+// RemoveListCollaborator godoc
+//
+//	@Summary		Remove a collaborator
+//	@Description	Remove a collaborator from a list. The owner may remove anyone, a collaborator only themselves
+//	@Tags			lists
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id			path		int		true	"List ID"
+//	@Param			username	path		string	true	"Username of the collaborator"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	utils.HTTPError
+//	@Failure		401			{object}	utils.HTTPErrorUnauthorized
+//	@Failure		403			{object}	utils.HTTPError
+//	@Failure		404			{object}	utils.HTTPError
+//	@Router			/lists/{id}/collaborators/{username} [delete]
+func (c *ListController) RemoveListCollaborator(ctx echo.Context) error {
+	id, err := listID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.listService.RemoveCollaborator(user.ID, id, ctx.Param("username")); err != nil {
+		return listError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "Collaborator removed"})
+}
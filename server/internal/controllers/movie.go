@@ -62,27 +62,40 @@ func (c *MovieController) GetMovieDetails(ctx echo.Context) error {
 
 	if ctx.Get("model") != nil {
 		user := ctx.Get("model").(models.User)
-		var watchHistory models.WatchHistory
-		err = c.db.Model(&models.WatchHistory{}).Where("user_id = ? AND movie_id = ?", user.ID, movieID).First(&watchHistory).Error
-		details.IsWatched = err == nil
+		if position, ok := c.movieService.WatchPosition(user.ID, details.ID); ok {
+			details.IsWatched = position.WatchCount > 0
+			details.Resume = position.ResumePosition()
+			details.Progress = position.Progress
+		}
+		details.InWatchlist = c.movieService.InWatchlist(user.ID, details.ID)
+		if rating, ok := c.movieService.UserRating(user.ID, details.ID); ok {
+			details.UserRating = rating.Score
+		}
 	}
 
+	community := c.movieService.MovieRating(details.ID)
+	details.Community = community.Average
+	details.RatingCount = community.Count
+
 	c.loadComments(details)
 	c.loadSubtitles(details)
 
-	var downloadedMovie models.DownloadedMovie
-	err = c.db.Where("movie_id = ?", details.ID).First(&downloadedMovie).Error
-	if err == nil {
+	details.Sources = c.torrentService.MovieSources(details.ID)
+	if len(details.Sources) > 0 {
 		details.IsAvailable = true
 		details.StreamURL = fmt.Sprintf("/api/stream/%d", details.ID)
 	}
+	if _, ok := c.torrentService.DirectPlay(services.StreamKey{MovieID: details.ID}); ok {
+		details.DirectPlay = true
+		details.DirectURL = fmt.Sprintf("/api/stream/%d/direct", details.ID)
+	}
 
 	return ctx.JSON(http.StatusOK, details)
 }
 
 func (c *MovieController) loadComments(details *models.MovieDetails) {
 	var comments []models.Comment
-	err := c.db.Where("movie_id = ?", details.ID).Order("created_at DESC").Find(&comments).Error
+	err := c.db.Where("movie_id = ? AND status = ?", details.ID, services.CommentVisible).Order("created_at DESC").Find(&comments).Error
 	if err != nil {
 		return
 	}
@@ -146,8 +159,8 @@ func (c *MovieController) SearchMovies(ctx echo.Context) error {
 //	@Param			genres			query		string	false	"Comma-separated genre names or IDs (e.g., Action,Drama or 28,18)"
 //	@Param			yearFrom		query		int		false	"Release year from (inclusive)"
 //	@Param			yearTo			query		int		false	"Release year to (inclusive)"
-//	@Param			minRating		query		number	false	"Minimum TMDB rating (0-10)"
-//	@Param			sort			query		string	false	"Sort by: year, year_asc, year_desc, rating (default popularity)"
+//	@Param			minRating		query		number	false	"Minimum TMDB rating (0-10), or community rating when sorting by community_rating"
+//	@Param			sort			query		string	false	"Sort by: year, year_asc, year_desc, rating, community_rating (default popularity). community_rating only lists movies rated by users"
 //	@Success		200				{array}		services.DiscoverMoviesResp
 //	@Failure		500				{object}	utils.HTTPError
 //	@Router			/movies/popular [get]
@@ -219,28 +232,64 @@ func (c *MovieController) GetMovies(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, movies)
 }
 
-func validateAndExtractMovieFilePath(path string) (int, error) {
-	var movieId int
-	var err error
+// GetDownloadStatus godoc
+//
+//	@Summary		Movie download status
+//	@Description	Get the download progress and swarm statistics of a movie
+//	@Tags			movies
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id	path		int	true	"Movie ID"
+//	@Success		200	{object}	services.DownloadStatus
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404	{object}	utils.HTTPError
+//	@Router			/movies/{id}/download [get]
+func (c *MovieController) GetDownloadStatus(ctx echo.Context) error {
+	movieID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+
+	status, err := c.torrentService.DownloadStatus(movieID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No download found for this movie")
+	}
+
+	return ctx.JSON(http.StatusOK, status)
+}
+
+// validateAndExtractStreamKey reads the stream a /stream path belongs to:
+// /stream/{movieID}/... or /stream/{movieID}/sources/{sourceID}/...
+func validateAndExtractStreamKey(path string) (services.StreamKey, error) {
+	var key services.StreamKey
 
 	parts := strings.Split(strings.Trim(path, "/"), "/")
 	if len(parts) < 2 {
-		return movieId, echo.NewHTTPError(http.StatusBadRequest, "Invalid path")
+		return key, echo.NewHTTPError(http.StatusBadRequest, "Invalid path")
 	}
 
-	movieIDStr := parts[1]
-	movieId, err = strconv.Atoi(movieIDStr)
+	movieID, err := strconv.Atoi(parts[1])
 	if err != nil {
-		return movieId, echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+		return key, echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
 	}
+	key.MovieID = movieID
 
-	return movieId, nil
+	if len(parts) >= 4 && parts[2] == "sources" {
+		sourceID, err := strconv.ParseUint(parts[3], 10, 0)
+		if err != nil || sourceID == 0 {
+			return key, echo.NewHTTPError(http.StatusBadRequest, "Invalid source ID")
+		}
+		key.SourceID = uint(sourceID)
+	}
+
+	return key, nil
 }
 
 // serveHLSFile godoc
 //
 //	@Summary		Serve HLS video or playlist file
-//	@Description	Streams HLS (.m3u8 playlist or .ts segment) files for a given movie. Triggers transcoding if not already done.
+//	@Description	Streams HLS (.m3u8 playlist or .ts segment) files for a given movie. Triggers transcoding if not already done. Files under sources/{sourceID}/ stream a specific stored source of the movie instead of the default one.
 //	@Tags			stream
 //	@Produce		application/vnd.apple.mpegurl,video/MP2T
 //	@Param			movieID	path		int		true	"Movie ID"
@@ -254,16 +303,29 @@ func validateAndExtractMovieFilePath(path string) (int, error) {
 func (c *MovieController) ServeHLSFile(ctx echo.Context) error {
 	path := ctx.Request().URL.Path
 
-	movieID, err := validateAndExtractMovieFilePath(path)
+	key, err := validateAndExtractStreamKey(path)
 	if err != nil {
 		return err
 	}
+	movieID := key.MovieID
 
 	outputDir := services.VideoTranscoderConf.Output.Directory
 	filePath := filepath.Join(outputDir, strings.TrimPrefix(path, "/stream/"))
 
 	if utils.CheckFileExits(filePath) != nil {
-		err = c.movieService.EnsureMovieIsPartiallyDownloadedAndStartedTranscoding(movieID, outputDir)
+		name := strings.TrimPrefix(strings.TrimPrefix(path, "/stream/"), filepath.ToSlash(key.Dir())+"/")
+		if objectKey, ok := c.movieService.PublishedFile(key, name); ok {
+			c.movieService.TouchStream(key)
+			return servePublishedFile(ctx, objectKey)
+		}
+
+		if key.SourceID != 0 {
+			if _, ok := c.torrentService.Source(movieID, key.SourceID); !ok {
+				return echo.NewHTTPError(http.StatusNotFound, "Source not found")
+			}
+		}
+
+		err = c.movieService.EnsureMovieIsPartiallyDownloadedAndStartedTranscoding(key, outputDir)
 		if err != nil {
 			return err
 		}
@@ -276,9 +338,127 @@ func (c *MovieController) ServeHLSFile(ctx echo.Context) error {
 	if strings.HasSuffix(filePath, ".ts") {
 		if userModel := ctx.Get("model"); userModel != nil {
 			user := userModel.(models.User)
-			c.movieService.TrackUserSegment(user.ID, movieID)
+			c.movieService.TrackUserSegment(user.ID, key, filePath)
 		}
 	}
+	c.movieService.TouchStream(key)
 
 	return ctx.File(filePath)
 }
+
+// ReportProgressRequest is the playback position reported by the player
+type ReportProgressRequest struct {
+	Position int `json:"position" example:"1260"` // seconds
+	Duration int `json:"duration" example:"8160"` // seconds, 0 when unknown
+}
+
+// ReportProgress godoc
+//
+//	@Summary		Report playback progress
+//	@Description	Record the playback position of the current user in a movie. It takes precedence over the position guessed from the requested stream segments and is saved within a few seconds
+//	@Tags			movies
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int						true	"Movie ID"
+//	@Param			body	body		ReportProgressRequest	true	"Playback position"
+//	@Success		200		{object}	services.WatchPosition
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Router			/movies/{id}/progress [post]
+func (c *MovieController) ReportProgress(ctx echo.Context) error {
+	movieID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || movieID <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+
+	var req ReportProgressRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.movieService.ReportProgress(user.ID, movieID, req.Position, req.Duration); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	position, _ := c.movieService.WatchPosition(user.ID, movieID)
+	return ctx.JSON(http.StatusOK, position)
+}
+
+// ServeDirectPlay godoc
+//
+//	@Summary		Direct play
+//	@Description	Serve the original file of a movie, or of one of its sources, when it is an H.264/AAC MP4 browsers can play without transcoding. Range requests are supported; a file still being downloaded is read from its torrent, waiting for the pieces a request needs
+//	@Tags			stream
+//	@Produce		video/mp4
+//	@Param			movieID		path		int		true	"Movie ID"
+//	@Param			sourceID	path		int		false	"Source ID"
+//	@Success		200			{file}		binary	"Video file"
+//	@Success		206			{file}		binary	"Part of the video file"
+//	@Failure		400			{object}	utils.HTTPError
+//	@Failure		404			{object}	utils.HTTPError
+//	@Security		ApiKeyAuth
+//	@Router			/stream/{movieID}/direct [get]
+//	@Router			/stream/{movieID}/sources/{sourceID}/direct [get]
+func (c *MovieController) ServeDirectPlay(ctx echo.Context) error {
+	movieID, err := strconv.Atoi(ctx.Param("movieId"))
+	if err != nil || movieID <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+	key := services.StreamKey{MovieID: movieID}
+	if param := ctx.Param("sourceId"); param != "" {
+		sourceID, err := strconv.ParseUint(param, 10, 64)
+		if err != nil || sourceID == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid source ID")
+		}
+		key.SourceID = uint(sourceID)
+	}
+
+	file, ok := c.torrentService.DirectPlay(key)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Direct play is not available for this movie")
+	}
+
+	reader, err := file.Open(ctx.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to open video file")
+	}
+	defer reader.Close()
+
+	if userModel := ctx.Get("model"); userModel != nil {
+		user := userModel.(models.User)
+		c.movieService.TrackUserSegment(user.ID, key, "")
+	}
+	c.movieService.TouchStream(key)
+
+	ctx.Response().Header().Set(echo.HeaderContentType, "video/mp4")
+	http.ServeContent(ctx.Response(), ctx.Request(), file.Name, file.ModTime, reader)
+	return nil
+}
+
+// servePublishedFile serves a file of a stream published to the media
+// storage. Playlists are proxied so that their relative URIs keep pointing to
+// the API; other files are redirected to the storage when it can serve them.
+func servePublishedFile(ctx echo.Context, objectKey string) error {
+	store := services.MediaStore()
+	reqCtx := ctx.Request().Context()
+
+	if !strings.HasSuffix(objectKey, ".m3u8") {
+		url, err := store.URL(reqCtx, objectKey)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sign media URL")
+		}
+		if url != "" {
+			return ctx.Redirect(http.StatusFound, url)
+		}
+	}
+
+	body, err := store.Get(reqCtx, objectKey)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "File not found")
+	}
+	defer body.Close()
+
+	return ctx.Stream(http.StatusOK, services.MediaContentType(objectKey), body)
+}
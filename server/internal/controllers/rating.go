@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"server/internal/models"
+	"server/internal/services"
+	"server/internal/services/users"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type RatingController struct {
+	movieService *services.MovieService
+}
+
+func NewRatingController(ms *services.MovieService) *RatingController {
+	return &RatingController{
+		movieService: ms,
+	}
+}
+
+func ratingResponse(rating models.Rating) RatingResponse {
+	return RatingResponse{
+		MovieID:    rating.MovieID,
+		MovieTitle: rating.MovieTitle,
+		PosterPath: rating.PosterPath,
+		Username:   rating.User.Username,
+		Avatar:     rating.User.Avatar,
+		Score:      rating.Score,
+		Review:     rating.Review,
+		CreatedAt:  rating.CreatedAt,
+		UpdatedAt:  rating.UpdatedAt,
+	}
+}
+
+func ratingsResponse(ratings []models.Rating) []RatingResponse {
+	resp := make([]RatingResponse, 0, len(ratings))
+	for _, rating := range ratings {
+		resp = append(resp, ratingResponse(rating))
+	}
+	return resp
+}
+
+func ratingMovieID(ctx echo.Context) (int, error) {
+	movieID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || movieID <= 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+	return movieID, nil
+}
+
+func ratingsPage(ctx echo.Context) int {
+	if p, err := strconv.Atoi(ctx.QueryParam("page")); err == nil && p > 0 {
+		return p
+	}
+	return 1
+}
+
+// RateMovie godoc
+//
+//	@Summary		Rate a movie
+//	@Description	Rate a movie from 1 to 10, with an optional review, replacing the previous rating of the current user
+//	@Tags			ratings
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id		path		int						true	"Movie ID"
+//	@Param			body	body		services.RatingRequest	true	"Rating"
+//	@Success		200		{object}	controllers.RatingResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/movies/{id}/rating [put]
+func (c *RatingController) RateMovie(ctx echo.Context) error {
+	movieID, err := ratingMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req services.RatingRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	rating, err := c.movieService.RateMovie(user.ID, movieID, req)
+	switch {
+	case errors.Is(err, services.ErrInvalidRating):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrMovieNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, "Movie not found")
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	rating.User = user
+	return ctx.JSON(http.StatusOK, ratingResponse(rating))
+}
+
+// DeleteRating godoc
+//
+//	@Summary		Delete a rating
+//	@Description	Remove the rating and review the current user gave a movie
+//	@Tags			ratings
+//	@Produce		json
+//	@Security		JWT
+//	@Param			id	path		int	true	"Movie ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	utils.HTTPError
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500	{object}	utils.HTTPError
+//	@Router			/movies/{id}/rating [delete]
+func (c *RatingController) DeleteRating(ctx echo.Context) error {
+	movieID, err := ratingMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.movieService.DeleteRating(user.ID, movieID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "Rating deleted"})
+}
+
+// GetMovieReviews godoc
+//
+//	@Summary		Movie reviews
+//	@Description	List the reviews users wrote about a movie, newest first, 20 per page
+//	@Tags			ratings
+//	@Produce		json
+//	@Param			id		path		int	true	"Movie ID"
+//	@Param			page	query		int	false	"Page number (default: 1)"
+//	@Success		200		{array}		controllers.RatingResponse
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/movies/{id}/reviews [get]
+func (c *RatingController) GetMovieReviews(ctx echo.Context) error {
+	movieID, err := ratingMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	reviews, err := c.movieService.MovieReviews(movieID, ratingsPage(ctx))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, ratingsResponse(reviews))
+}
+
+// GetUserRatings godoc
+//
+//	@Summary		User ratings
+//	@Description	List the movies a user rated, newest first, 20 per page
+//	@Tags			ratings
+//	@Produce		json
+//	@Security		JWT
+//	@Param			username	path		string	true	"Username"
+//	@Param			page		query		int		false	"Page number (default: 1)"
+//	@Success		200			{array}		controllers.RatingResponse
+//	@Failure		401			{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404			{object}	utils.HTTPError
+//	@Failure		500			{object}	utils.HTTPError
+//	@Router			/users/{username}/ratings [get]
+func (c *RatingController) GetUserRatings(ctx echo.Context) error {
+	user, err := users.GetUserByUsername(ctx.Param("username"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+
+	ratings, err := c.movieService.UserRatings(user.ID, ratingsPage(ctx))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp := ratingsResponse(ratings)
+	for i := range resp {
+		resp[i].Username = user.Username
+		resp[i].Avatar = user.Avatar
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
@@ -1,40 +1,127 @@
 package controllers
 
-import "server/internal/models"
+import (
+	"server/internal/models"
+	"time"
+)
 
 // AddCommentRequest represents the payload to add a comment
 type AddCommentRequest struct {
 	MovieID  int    `json:"movie_id"`
-	Username string `json:"username"`
 	Content  string `json:"content"`
+	ParentID *uint  `json:"parent_id,omitempty"` // comment replied to
+	// Timestamp anchors the comment to a moment of the movie, in seconds.
+	// Replies cannot have one.
+	Timestamp *int `json:"timestamp,omitempty" example:"1260"`
 }
 
 type MovieDetailsDoc struct {
-	ID           int               `json:"id"`
-	Title        string            `json:"title"`
-	Overview     string            `json:"overview"`
-	ReleaseDate  string            `json:"release_date"`
-	Runtime      int               `json:"runtime"`
-	PosterPath   string            `json:"poster_path"`
-	BackdropPath string            `json:"backdrop_path"`
-	VoteAverage  float64           `json:"vote_average"`
-	IMDbID       string            `json:"imdb_id"`
-	Language     string            `json:"original_language,omitempty"`
-	IsAvailable  bool              `json:"is_available"`
-	StreamURL    string            `json:"stream_url"`
-	Cast         []models.Cast     `json:"cast"`
-	Director     []models.Person   `json:"director"`
-	Producer     []models.Person   `json:"producer"`
-	Genres       []models.Genre    `json:"genres"`
-	Comments     []CommentResponse `json:"comments"`
-	IsWatched    bool              `json:"isWatched"`
+	ID           int                  `json:"id"`
+	Title        string               `json:"title"`
+	Overview     string               `json:"overview"`
+	ReleaseDate  string               `json:"release_date"`
+	Runtime      int                  `json:"runtime"`
+	PosterPath   string               `json:"poster_path"`
+	BackdropPath string               `json:"backdrop_path"`
+	VoteAverage  float64              `json:"vote_average"`
+	IMDbID       string               `json:"imdb_id"`
+	Language     string               `json:"original_language,omitempty"`
+	IsAvailable  bool                 `json:"is_available"`
+	StreamURL    string               `json:"stream_url"`
+	DirectPlay   bool                 `json:"direct_play"`
+	DirectURL    string               `json:"direct_play_url,omitempty"`
+	Cast         []models.Cast        `json:"cast"`
+	Director     []models.Person      `json:"director"`
+	Producer     []models.Person      `json:"producer"`
+	Genres       []models.Genre       `json:"genres"`
+	Comments     []CommentResponse    `json:"comments"`
+	IsWatched    bool                 `json:"isWatched"`
+	InWatchlist  bool                 `json:"in_watchlist"`
+	Community    float64              `json:"community_rating" example:"7.8"`
+	RatingCount  int64                `json:"community_rating_count" example:"12"`
+	UserRating   int                  `json:"user_rating,omitempty" example:"8"`
+	Resume       int                  `json:"resume_position" example:"1260"`
+	Progress     float64              `json:"watch_progress" example:"15.44"`
+	Sources      []models.MovieSource `json:"sources"`
 }
 
 // CommentResponse represents a comment in responses
 type CommentResponse struct {
-	ID       uint   `json:"id"`
-	Username string `json:"username"`
-	Avatar   string `json:"avatar"`
-	Date     string `json:"date"`
-	Content  string `json:"content"`
+	ID         uint             `json:"id"`
+	MovieID    int              `json:"movie_id"`
+	ParentID   *uint            `json:"parent_id,omitempty"`
+	Depth      int              `json:"depth"`
+	Username   string           `json:"username"`
+	Avatar     string           `json:"avatar"`
+	Date       string           `json:"date"`
+	EditedAt   string           `json:"edited_at,omitempty"`
+	Timestamp  *int             `json:"timestamp,omitempty" example:"1260"`
+	Content    string           `json:"content"`
+	Status     string           `json:"status" example:"visible"`
+	ReplyCount int64            `json:"reply_count"`
+	Reactions  map[string]int64 `json:"reactions"`
+	MyReaction string           `json:"my_reaction,omitempty"`
+}
+
+// CommentPageResponse represents a page of comments in responses
+type CommentPageResponse struct {
+	Comments   []CommentResponse `json:"comments"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// ExportResponse represents an offline export in responses
+type ExportResponse struct {
+	ID          uint       `json:"id" example:"4"`
+	MovieID     int        `json:"movie_id" example:"603"`
+	SourceID    uint       `json:"source_id,omitempty" example:"12"`
+	Quality     string     `json:"quality" example:"720p"`
+	AudioTrack  int        `json:"audio_track" example:"0"`
+	Subtitles   []string   `json:"subtitles"`
+	Status      string     `json:"status" example:"ready"`
+	Error       string     `json:"error,omitempty"`
+	FileSize    int64      `json:"file_size" example:"1073741824"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DownloadURL string     `json:"download_url,omitempty" example:"/api/exports/4/file"`
+}
+
+// ListResponse represents a movie list in responses
+type ListResponse struct {
+	ID            uint                   `json:"id" example:"7"`
+	Slug          string                 `json:"slug" example:"halloween-marathon-3f9a1c2b7d4e"`
+	Title         string                 `json:"title" example:"Halloween marathon"`
+	Description   string                 `json:"description"`
+	Privacy       string                 `json:"privacy" example:"unlisted"`
+	Owner         string                 `json:"owner" example:"fturing"`
+	Collaborators []string               `json:"collaborators"`
+	CanEdit       bool                   `json:"can_edit"`
+	ShareURL      string                 `json:"share_url,omitempty" example:"/api/lists/public/halloween-marathon-3f9a1c2b7d4e"`
+	ItemCount     int                    `json:"item_count" example:"3"`
+	Items         []models.MovieListItem `json:"items"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+}
+
+// RatingResponse represents a user rating in responses
+type RatingResponse struct {
+	MovieID    int       `json:"movie_id" example:"603"`
+	MovieTitle string    `json:"movie_title" example:"The Matrix"`
+	PosterPath string    `json:"poster_path"`
+	Username   string    `json:"username,omitempty" example:"fturing"`
+	Avatar     string    `json:"avatar,omitempty"`
+	Score      int       `json:"score" example:"8"`
+	Review     string    `json:"review,omitempty" example:"Still holds up."`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ModerateCommentRequest represents the payload to moderate a comment
+type ModerateCommentRequest struct {
+	Action string `json:"action" example:"hide" enums:"hide,remove,restore,dismiss"`
+}
+
+// ReportedCommentResponse represents a comment of the moderation queue
+type ReportedCommentResponse struct {
+	Comment models.Comment         `json:"comment"`
+	Reports []models.CommentReport `json:"reports"`
 }
@@ -3,6 +3,7 @@ package controllers
 import (
 	"mime/multipart"
 	"net/http"
+	"os"
 	"server/internal/controllers/auth"
 	"server/internal/models"
 	"server/internal/services"
@@ -149,7 +150,9 @@ type UploadPictureReq struct {
 }
 
 type UploadPictureRes struct {
-	Message string `json:"message" example:"success"`
+	Message  string            `json:"message" example:"success"`
+	Avatar   string            `json:"avatar" example:"/api/avatars/9f86d081884c7d65/256.jpg"`
+	Variants map[string]string `json:"variants"`
 }
 
 func UploadPicture(c echo.Context) error {
@@ -167,22 +170,58 @@ func UploadPicture(c echo.Context) error {
 
 	user := c.Get("model").(models.User)
 
-	avatarUrl, err := services.UploadPicture(form.Picture, user.Username)
+	avatar, err := services.UploadPicture(form.Picture)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
-	user.Avatar = avatarUrl
+	previous := user.Avatar
+	user.Avatar = avatar.URL
 
 	err = users.UpdateUser(user)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	if previous != avatar.URL {
+		services.ReleaseAvatar(previous)
+	}
 
 	return c.JSON(http.StatusOK, UploadPictureRes{
-		Message: "success",
+		Message:  "success",
+		Avatar:   avatar.URL,
+		Variants: avatar.Variants,
 	})
 }
 
+// Serve avatar godoc
+//
+//	@Summary		Serve avatar
+//	@Description	serve a profile picture stored by the local avatar storage
+//	@Tags			users
+//	@Produce		image/jpeg,image/png
+//	@Param			hash	path	string	true	"Picture hash"
+//	@Param			file	path	string	true	"Variant file"
+//	@Success		200
+//	@Failure		404	{object}	utils.HTTPError
+//	@Router			/avatars/{hash}/{file} [get]
+func ServeAvatar(c echo.Context) error {
+	store, ok := services.AvatarStore().(*services.LocalAvatarStorage)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "avatar not found")
+	}
+
+	p, err := store.Path(c.Param("hash") + "/" + c.Param("file"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "avatar not found")
+	}
+	if _, err := os.Stat(p); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "avatar not found")
+	}
+
+	// Avatars are stored under the hash of their content and never change.
+	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	return c.File(p)
+}
+
 // Get user stats godoc
 //
 //	@Summary		User statistics
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"net/http"
+	"server/internal/models"
+	"server/internal/services"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+type WatchHistoryController struct {
+	movieService *services.MovieService
+}
+
+func NewWatchHistoryController(ms *services.MovieService) *WatchHistoryController {
+	return &WatchHistoryController{
+		movieService: ms,
+	}
+}
+
+func historyMovieID(ctx echo.Context) (int, error) {
+	movieID, err := strconv.Atoi(ctx.Param("movieId"))
+	if err != nil || movieID <= 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
+	}
+	return movieID, nil
+}
+
+// GetContinueWatching godoc
+//
+//	@Summary		Continue watching
+//	@Description	List the movies the current user started without finishing them, most recently watched first
+//	@Tags			users
+//	@Produce		json
+//	@Security		JWT
+//	@Param			limit	query		int	false	"Maximum number of movies (default: 20, max: 100)"
+//	@Success		200		{array}		models.WatchHistory
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/users/continue-watching [get]
+func (c *WatchHistoryController) GetContinueWatching(ctx echo.Context) error {
+	limit := 0
+	if limitStr := ctx.QueryParam("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	user := ctx.Get("model").(models.User)
+	history, err := c.movieService.ContinueWatching(user.ID, limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, history)
+}
+
+// MarkWatched godoc
+//
+//	@Summary		Mark as watched
+//	@Description	Mark a movie as watched by the current user, as if they had watched it to the end
+//	@Tags			users
+//	@Produce		json
+//	@Security		JWT
+//	@Param			movieId	path		int	true	"Movie ID"
+//	@Success		200		{object}	models.WatchHistory
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/users/watched/{movieId} [put]
+func (c *WatchHistoryController) MarkWatched(ctx echo.Context) error {
+	movieID, err := historyMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	wh, err := c.movieService.MarkWatched(user.ID, movieID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, wh)
+}
+
+// MarkUnwatched godoc
+//
+//	@Summary		Mark as unwatched
+//	@Description	Mark a movie as not watched by the current user and forget their position, keeping it in their history
+//	@Tags			users
+//	@Produce		json
+//	@Security		JWT
+//	@Param			movieId	path		int	true	"Movie ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/users/watched/{movieId} [delete]
+func (c *WatchHistoryController) MarkUnwatched(ctx echo.Context) error {
+	movieID, err := historyMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.movieService.MarkUnwatched(user.ID, movieID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "Movie marked as unwatched"})
+}
+
+// RemoveFromHistory godoc
+//
+//	@Summary		Remove from watch history
+//	@Description	Remove a movie from the watch history of the current user
+//	@Tags			users
+//	@Produce		json
+//	@Security		JWT
+//	@Param			movieId	path		int	true	"Movie ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/users/watch-history/{movieId} [delete]
+func (c *WatchHistoryController) RemoveFromHistory(ctx echo.Context) error {
+	movieID, err := historyMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.movieService.RemoveFromHistory(user.ID, movieID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "Movie removed from watch history"})
+}
+
+// ClearHistory godoc
+//
+//	@Summary		Clear watch history
+//	@Description	Remove every movie from the watch history of the current user
+//	@Tags			users
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{object}	map[string]string
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500	{object}	utils.HTTPError
+//	@Router			/users/watch-history [delete]
+func (c *WatchHistoryController) ClearHistory(ctx echo.Context) error {
+	user := ctx.Get("model").(models.User)
+	if err := c.movieService.ClearHistory(user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "Watch history cleared"})
+}
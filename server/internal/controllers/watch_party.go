@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"server/internal/models"
+	"server/internal/services"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+type WatchPartyController struct {
+	upgrader     websocket.Upgrader
+	partyService *services.WatchPartyService
+}
+
+func NewWatchPartyController(ps *services.WatchPartyService) *WatchPartyController {
+	return &WatchPartyController{
+		upgrader:     websocket.Upgrader{CheckOrigin: checkOrigin},
+		partyService: ps,
+	}
+}
+
+// CreatePartyRequest is the movie a watch party is for, and the source to
+// play when the host picked one.
+type CreatePartyRequest struct {
+	MovieID  int  `json:"movie_id" validate:"required" example:"603"`
+	SourceID uint `json:"source_id,omitempty" example:"12"`
+}
+
+func partyError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrPartyNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidParty):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrTooManyParties):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// CreateParty godoc
+//
+//	@Summary		Create a watch party
+//	@Description	Open a watch party room for a movie, hosted by the current user. Others join it with its invite code over the WebSocket at socket_url. The room expires once it stayed empty for a few minutes
+//	@Tags			parties
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			body	body		controllers.CreatePartyRequest	true	"Movie to watch"
+//	@Success		201		{object}	services.PartyInfo
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		409		{object}	utils.HTTPError
+//	@Router			/parties [post]
+func (c *WatchPartyController) CreateParty(ctx echo.Context) error {
+	var req CreatePartyRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	user := ctx.Get("model").(models.User)
+	party, err := c.partyService.Create(user, req.MovieID, req.SourceID)
+	if err != nil {
+		return partyError(err)
+	}
+
+	return ctx.JSON(http.StatusCreated, party)
+}
+
+// GetParty godoc
+//
+//	@Summary		Get a watch party
+//	@Description	Get the movie, members and playback state of a watch party from its invite code
+//	@Tags			parties
+//	@Produce		json
+//	@Security		JWT
+//	@Param			code	path		string	true	"Invite code"
+//	@Success		200		{object}	services.PartyInfo
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/parties/{code} [get]
+func (c *WatchPartyController) GetParty(ctx echo.Context) error {
+	party, err := c.partyService.Info(ctx.Param("code"))
+	if err != nil {
+		return partyError(err)
+	}
+
+	return ctx.JSON(http.StatusOK, party)
+}
+
+// HandlePartySocket godoc
+//
+//	@Summary		Join a watch party
+//	@Description	Join a watch party over a WebSocket, authenticated with the token query parameter. Members send services.PartyMessage frames: the host plays, pauses and seeks, everyone chats ("chat"), asks for the state ("sync") and measures its clock offset ("time"). The room sends its playback state (services.PartyState) on every change and every few seconds while playing, for members to correct their drift from server_time, its members (services.PartyPresence) and chat messages (services.PartyChat)
+//	@Tags			parties
+//	@Param			code	path		string	true	"Invite code"
+//	@Param			token	query		string	true	"Access token"
+//	@Success		101		{string}	string	"Switching Protocols"
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404		{object}	utils.HTTPError
+//	@Router			/ws/party/{code} [get]
+func (c *WatchPartyController) HandlePartySocket(ctx echo.Context) error {
+	code := ctx.Param("code")
+	if _, ok := c.partyService.Room(code); !ok {
+		return partyError(services.ErrPartyNotFound)
+	}
+
+	ws, err := c.upgrader.Upgrade(ctx.Response(), ctx.Request(), nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	user := ctx.Get("model").(models.User)
+	room, member, err := c.partyService.Join(code, user)
+	if err != nil {
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, err.Error()), time.Now().Add(socketWriteWait))
+		return nil
+	}
+	defer c.partyService.Leave(room, member)
+
+	go writeSocket(ws, member.Outgoing())
+	readSocket(ws, func(message []byte) {
+		room.Handle(member, message)
+	})
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"server/internal/models"
+	"server/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+type WatchlistController struct {
+	movieService *services.MovieService
+}
+
+func NewWatchlistController(ms *services.MovieService) *WatchlistController {
+	return &WatchlistController{
+		movieService: ms,
+	}
+}
+
+type ReorderWatchlistRequest struct {
+	MovieIDs []int `json:"movie_ids" validate:"required" example:"603,27205,155"`
+}
+
+// GetWatchlist godoc
+//
+//	@Summary		Get watchlist
+//	@Description	List the movies the current user saved to watch later, in their order
+//	@Tags			users
+//	@Produce		json
+//	@Security		JWT
+//	@Success		200	{array}		models.WatchlistItem
+//	@Failure		401	{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500	{object}	utils.HTTPError
+//	@Router			/users/watchlist [get]
+func (c *WatchlistController) GetWatchlist(ctx echo.Context) error {
+	user := ctx.Get("model").(models.User)
+	items, err := c.movieService.Watchlist(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, items)
+}
+
+// AddToWatchlist godoc
+//
+//	@Summary		Add to watchlist
+//	@Description	Save a movie at the end of the watchlist of the current user. A movie already in the watchlist keeps its place
+//	@Tags			users
+//	@Produce		json
+//	@Security		JWT
+//	@Param			movieId	path		int	true	"Movie ID"
+//	@Success		200		{object}	models.WatchlistItem
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		404		{object}	utils.HTTPError
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/users/watchlist/{movieId} [put]
+func (c *WatchlistController) AddToWatchlist(ctx echo.Context) error {
+	movieID, err := historyMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	item, err := c.movieService.AddToWatchlist(user.ID, movieID)
+	if errors.Is(err, services.ErrMovieNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Movie not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, item)
+}
+
+// RemoveFromWatchlist godoc
+//
+//	@Summary		Remove from watchlist
+//	@Description	Remove a movie from the watchlist of the current user
+//	@Tags			users
+//	@Produce		json
+//	@Security		JWT
+//	@Param			movieId	path		int	true	"Movie ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500		{object}	utils.HTTPError
+//	@Router			/users/watchlist/{movieId} [delete]
+func (c *WatchlistController) RemoveFromWatchlist(ctx echo.Context) error {
+	movieID, err := historyMovieID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user := ctx.Get("model").(models.User)
+	if err := c.movieService.RemoveFromWatchlist(user.ID, movieID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "Movie removed from watchlist"})
+}
+
+// ReorderWatchlist godoc
+//
+//	@Summary		Reorder watchlist
+//	@Description	Order the watchlist of the current user as the given movies, which must be every movie of the watchlist
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Security		JWT
+//	@Param			ReorderWatchlistRequest	body		ReorderWatchlistRequest	true	"New order of the watchlist"
+//	@Success		200						{array}		models.WatchlistItem
+//	@Failure		400						{object}	utils.HTTPError
+//	@Failure		401						{object}	utils.HTTPErrorUnauthorized
+//	@Failure		500						{object}	utils.HTTPError
+//	@Router			/users/watchlist [put]
+func (c *WatchlistController) ReorderWatchlist(ctx echo.Context) error {
+	var req ReorderWatchlistRequest
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	user := ctx.Get("model").(models.User)
+	items, err := c.movieService.ReorderWatchlist(user.ID, req.MovieIDs)
+	if errors.Is(err, services.ErrInvalidWatchlistOrder) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, items)
+}
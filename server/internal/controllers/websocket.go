@@ -1,15 +1,25 @@
 package controllers
 
 import (
-	"encoding/json"
 	"net/http"
+	"net/url"
+	"server/internal/models"
 	"server/internal/services"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	socketWriteWait  = 10 * time.Second
+	socketPongWait   = 60 * time.Second
+	socketPingPeriod = socketPongWait * 9 / 10
+	socketMaxMessage = 4096
+)
+
 type WebSocketController struct {
 	upgrader         websocket.Upgrader
 	websocketService *services.WebSocketService
@@ -17,30 +27,70 @@ type WebSocketController struct {
 
 func NewWebSocketController(ws *services.WebSocketService) *WebSocketController {
 	return &WebSocketController{
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				// Allow all origins for testing (restrict this in production)
-				return true
-			},
-		},
+		upgrader:         websocket.Upgrader{CheckOrigin: checkOrigin},
 		websocketService: ws,
 	}
 }
 
+// checkOrigin accepts WebSocket connections from the server itself and the
+// origins allowed by CORS. Clients that are not browsers send no origin.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if slices.Contains(services.Conf.CORS.Origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// HandleSocket handles the WebSocket connections of the current user
+//
+//	@Summary		WebSocket endpoint for real-time events
+//	@Description	Establishes an authenticated WebSocket connection, the access token passed in the token query parameter. Clients send services.SocketMessage frames to subscribe to and unsubscribe from topics, "movie:<id>" for the streaming updates and timed comments of a movie, "user" for the events of the current user such as export status changes (services.ExportEvent), and to ping. The server answers with services.SocketFrame frames and sends the events of the subscribed topics as {"type": "event", "topic": ..., "data": ...}. Connections that do not read their messages fast enough are closed
+//	@Tags			WebSocket
+//	@Param			token	query		string	true	"Access token"
+//	@Success		101		{string}	string	"Switching Protocols"
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
+//	@Router			/ws [get]
+func (wc *WebSocketController) HandleSocket(c echo.Context) error {
+	ws, err := wc.upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	user := c.Get("model").(models.User)
+	client := wc.websocketService.Connect(user.ID)
+	defer wc.websocketService.Disconnect(client)
+
+	go writeSocket(ws, client.Outgoing())
+	readSocket(ws, func(message []byte) {
+		wc.websocketService.Handle(client, message)
+	})
+
+	return nil
+}
+
 // HandleWebSocket handles WebSocket connections for a specific movie
 //
 //	@Summary		WebSocket endpoint for movie streaming updates
-//	@Description	Establishes a WebSocket connection to receive real-time streaming updates for a specific movie
+//	@Description	Establishes an authenticated WebSocket connection, the access token passed in the token query parameter, to receive real-time streaming updates for a specific movie, and the comments anchored to a moment of it as they are posted ({"type": "timed_comment", "comment": CommentResponse}). Events are sent as they are, without frames; /ws follows several movies on one connection
 //	@Tags			WebSocket
 //	@Accept			json
 //	@Produce		json
 //	@Param			movieId	path		int		true	"Movie ID"
+//	@Param			token	query		string	true	"Access token"
 //	@Success		101		{string}	string	"Switching Protocols"
+//	@Failure		400		{object}	utils.HTTPError
+//	@Failure		401		{object}	utils.HTTPErrorUnauthorized
 //	@Router			/ws/{movieId} [get]
 func (wc *WebSocketController) HandleWebSocket(c echo.Context) error {
 	movieIDStr := c.Param("movieId")
 	movieID, err := strconv.Atoi(movieIDStr)
-	if err != nil {
+	if err != nil || movieID <= 0 {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid movie ID")
 	}
 
@@ -49,22 +99,62 @@ func (wc *WebSocketController) HandleWebSocket(c echo.Context) error {
 		return err
 	}
 	defer ws.Close()
-	defer wc.websocketService.RemoveSubscriber(movieID, ws)
 
-	wc.websocketService.AddSubscriber(movieID, ws)
+	user := c.Get("model").(models.User)
+	client := wc.websocketService.ConnectMovie(user.ID, movieID)
+	defer wc.websocketService.Disconnect(client)
+
+	go writeSocket(ws, client.Outgoing())
+	readSocket(ws, func(message []byte) {
+		wc.websocketService.Handle(client, message)
+	})
 
-	if lastState, exists := wc.websocketService.StreamStates.Load(movieID); exists {
-		if stateJSON, err := json.Marshal(lastState); err == nil {
-			ws.WriteMessage(websocket.TextMessage, stateJSON)
+	return nil
+}
+
+// writeSocket writes the messages of a connection, the only goroutine to do
+// so, and pings it until the messages are closed.
+func writeSocket(ws *websocket.Conn, outgoing <-chan []byte) {
+	ticker := time.NewTicker(socketPingPeriod)
+	defer func() {
+		ticker.Stop()
+		ws.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-outgoing:
+			ws.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if !ok {
+				ws.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := ws.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			ws.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
+}
+
+// readSocket hands the messages of a connection to handle until it is
+// closed, or stops answering pings.
+func readSocket(ws *websocket.Conn, handle func([]byte)) {
+	ws.SetReadLimit(socketMaxMessage)
+	ws.SetReadDeadline(time.Now().Add(socketPongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
 
 	for {
-		_, _, err := ws.ReadMessage()
+		_, message, err := ws.ReadMessage()
 		if err != nil {
-			break
+			return
 		}
+		handle(message)
 	}
-
-	return nil
 }
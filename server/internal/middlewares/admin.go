@@ -0,0 +1,19 @@
+package middlewares
+
+import (
+	"net/http"
+	"server/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Admin only lets users flagged as administrators through. It must run after AttachUser.
+func Admin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := c.Get("model").(models.User)
+		if !ok || !user.IsAdmin {
+			return echo.NewHTTPError(http.StatusForbidden, "admin access required")
+		}
+		return next(c)
+	}
+}
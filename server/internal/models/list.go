@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+const (
+	ListPrivate  = "private"
+	ListUnlisted = "unlisted" // readable by anyone with the link
+	ListPublic   = "public"
+)
+
+// MovieList is a named list of movies a user curates, alone or with the
+// collaborators they invited.
+type MovieList struct {
+	ID            uint                    `gorm:"primaryKey" json:"id"`
+	UserID        uint                    `gorm:"not null;index" json:"user_id"`
+	Owner         User                    `gorm:"foreignKey:UserID" json:"-"`
+	Slug          string                  `gorm:"size:120;not null;uniqueIndex" json:"slug"`
+	Title         string                  `gorm:"size:200;not null" json:"title"`
+	Description   string                  `gorm:"type:text" json:"description"`
+	Privacy       string                  `gorm:"size:10;not null;default:private;index" json:"privacy"`
+	Items         []MovieListItem         `gorm:"foreignKey:ListID" json:"items"`
+	Collaborators []MovieListCollaborator `gorm:"foreignKey:ListID" json:"-"`
+	CreatedAt     time.Time               `json:"created_at"`
+	UpdatedAt     time.Time               `json:"updated_at"`
+}
+
+// MovieListItem is a movie in a list. The title and poster are cached so that
+// the list is shown without calling TMDB.
+type MovieListItem struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	ListID     uint      `gorm:"not null;uniqueIndex:idx_list_movie" json:"-"`
+	MovieID    int       `gorm:"not null;uniqueIndex:idx_list_movie" json:"movie_id"`
+	MovieTitle string    `gorm:"size:500" json:"movie_title"`
+	PosterPath string    `gorm:"size:500" json:"poster_path"`
+	Position   int       `gorm:"not null;default:0" json:"position"`
+	AddedBy    uint      `json:"-"`
+	CreatedAt  time.Time `json:"added_at"`
+}
+
+// MovieListCollaborator is a user invited to edit the movies of a list.
+type MovieListCollaborator struct {
+	ID        uint `gorm:"primaryKey"`
+	ListID    uint `gorm:"not null;uniqueIndex:idx_list_collaborator"`
+	UserID    uint `gorm:"not null;uniqueIndex:idx_list_collaborator;index"`
+	User      User `gorm:"foreignKey:UserID"`
+	CreatedAt time.Time
+}
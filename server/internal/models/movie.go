@@ -5,22 +5,97 @@ import (
 	"time"
 
 	"github.com/anacrolix/torrent"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
 )
 
+// DownloadedMovie is a stored source of a movie: a video file on disk that
+// came from a torrent or from the library. A movie may have several.
 type DownloadedMovie struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
-	MovieID      int       `gorm:"not null;uniqueIndex:idx_movie_quality" json:"movie_id"`
-	Quality      string    `gorm:"size:10;not null;uniqueIndex:idx_movie_quality" json:"quality"`
-	FilePath     string    `gorm:"size:500;not null" json:"file_path"`
+	MovieID      int       `gorm:"not null;uniqueIndex:idx_movie_file" json:"movie_id"`
+	Quality      string    `gorm:"size:10;not null" json:"quality"`
+	FilePath     string    `gorm:"size:500;not null;uniqueIndex:idx_movie_file" json:"file_path"`
+	ReleaseName  string    `gorm:"size:500" json:"release_name"`
+	InfoHash     string    `gorm:"size:40;index" json:"info_hash,omitempty"`
 	MagnetLink   string    `gorm:"type:text" json:"magnet_link"`
 	DownloadedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"downloaded_at"`
 	LastWatched  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"last_watched"`
 	FileSize     int64     `gorm:"default:0" json:"file_size"`
 	Transcoded   bool      `gorm:"default:false" json:"transcoded"`
 	LastSegment  string    `gorm:"size:50" json:"last_segment"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	Source       string    `gorm:"size:20;not null;default:torrent" json:"source"` // "torrent" or "library"
+	StorageKey   string    `gorm:"size:500" json:"storage_key,omitempty"`          // archived copy in the media storage
+	// DirectPlay tells whether browsers can play the file as it is, nil
+	// until it was probed.
+	DirectPlay *bool `json:"direct_play,omitempty"`
+
+	BytesUploaded    int64      `gorm:"default:0" json:"bytes_uploaded"`
+	BytesDownloaded  int64      `gorm:"default:0" json:"bytes_downloaded"`
+	SeedRatio        float64    `gorm:"default:0" json:"seed_ratio"`
+	SeedingStartedAt *time.Time `json:"seeding_started_at,omitempty"`
+	SeedingStoppedAt *time.Time `json:"seeding_stopped_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ActiveTorrent records a torrent that is still downloading so it can be
+// re-added when the server restarts.
+type ActiveTorrent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MovieID   int       `gorm:"not null;uniqueIndex" json:"movie_id"`
+	InfoHash  string    `gorm:"size:40;not null" json:"info_hash"`
+	FilePath  string    `gorm:"size:500" json:"file_path"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TorrentOverride is a torrent chosen by an administrator for a movie. It
+// replaces the automatic torrent search for that movie.
+type TorrentOverride struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	MovieID     int       `gorm:"not null;uniqueIndex" json:"movie_id"`
+	InfoHash    string    `gorm:"size:40;not null" json:"info_hash"`
+	Name        string    `gorm:"size:500" json:"name"`
+	MagnetLink  string    `gorm:"type:text" json:"magnet_link,omitempty"`
+	TorrentFile []byte    `gorm:"type:bytea" json:"-"`
+	FilePath    string    `gorm:"size:500" json:"file_path"`
+	CreatedBy   uint      `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// PublishedStream is the finished HLS output of a stream copied to the media
+// storage, where it is served from once the local copy is gone.
+type PublishedStream struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MovieID   int       `gorm:"not null;uniqueIndex:idx_published_stream" json:"movie_id"`
+	SourceID  uint      `gorm:"not null;default:0;uniqueIndex:idx_published_stream" json:"source_id"` // 0 for the default stream
+	Backend   string    `gorm:"size:20;not null" json:"backend"`
+	Prefix    string    `gorm:"size:500;not null" json:"prefix"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MovieExport is an MP4 file remuxed from the HLS output of a stream, which a
+// user downloads to watch offline.
+type MovieExport struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	MovieID    int        `gorm:"not null;index" json:"movie_id"`
+	SourceID   uint       `gorm:"not null;default:0" json:"source_id"` // 0 for the default stream
+	Quality    string     `gorm:"size:10;not null" json:"quality"`
+	AudioTrack int        `gorm:"not null;default:0" json:"audio_track"`
+	Subtitles  string     `gorm:"size:200" json:"subtitles"`      // comma-separated languages
+	Status     string     `gorm:"size:20;not null" json:"status"` // "pending", "processing", "ready" or "failed"
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	FilePath   string     `gorm:"size:500" json:"-"`
+	FileSize   int64      `gorm:"default:0" json:"file_size"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 }
 
 type Subtitle struct {
@@ -44,25 +119,55 @@ type Movie struct {
 }
 
 type MovieDetails struct {
-	ID           int       `json:"id"`
-	Title        string    `json:"title"`
-	Overview     string    `json:"overview"`
-	ReleaseDate  string    `json:"release_date"`
-	Runtime      int       `json:"runtime"`
-	PosterPath   string    `json:"poster_path"`
-	BackdropPath string    `json:"backdrop_path"`
-	VoteAverage  float64   `json:"vote_average"`
-	IMDbID       string    `json:"imdb_id"`
-	Language     string    `json:"original_language,omitempty"`
-	IsAvailable  bool      `json:"is_available"`
-	IsWatched    bool      `json:"is_watched"`
-	StreamURL    string    `json:"stream_url"`
-	Cast         []Cast    `json:"cast"`
-	Director     []Person  `json:"director"`
-	Producer     []Person  `json:"producer"`
-	Genres       []Genre   `json:"genres"`
-	Comments     []Comment `json:"comments"`
-	Subtitles    []string  `json:"subtitles"`
+	ID           int           `json:"id"`
+	Title        string        `json:"title"`
+	Overview     string        `json:"overview"`
+	ReleaseDate  string        `json:"release_date"`
+	Runtime      int           `json:"runtime"`
+	PosterPath   string        `json:"poster_path"`
+	BackdropPath string        `json:"backdrop_path"`
+	VoteAverage  float64       `json:"vote_average"`
+	IMDbID       string        `json:"imdb_id"`
+	Language     string        `json:"original_language,omitempty"`
+	IsAvailable  bool          `json:"is_available"`
+	IsWatched    bool          `json:"is_watched"`
+	InWatchlist  bool          `json:"in_watchlist"`
+	Community    float64       `json:"community_rating"`
+	RatingCount  int64         `json:"community_rating_count"`
+	UserRating   int           `json:"user_rating,omitempty"`
+	Resume       int           `json:"resume_position"` // seconds
+	Progress     float64       `json:"watch_progress"`
+	StreamURL    string        `json:"stream_url"`
+	DirectPlay   bool          `json:"direct_play"`
+	DirectURL    string        `json:"direct_play_url,omitempty"`
+	Cast         []Cast        `json:"cast"`
+	Director     []Person      `json:"director"`
+	Producer     []Person      `json:"producer"`
+	Genres       []Genre       `json:"genres"`
+	Comments     []Comment     `json:"comments"`
+	Subtitles    []string      `json:"subtitles"`
+	Sources      []MovieSource `json:"sources"`
+}
+
+// MovieSource describes a stored source of a movie that users can pick to
+// stream.
+type MovieSource struct {
+	ID          uint      `json:"id" example:"12"`
+	Quality     string    `json:"quality" example:"1080p"`
+	Source      string    `json:"source,omitempty" example:"BluRay"`
+	Edition     string    `json:"edition,omitempty" example:"Extended"`
+	VideoCodec  string    `json:"video_codec,omitempty" example:"H.264"`
+	AudioCodec  string    `json:"audio_codec,omitempty" example:"AAC"`
+	HDR         []string  `json:"hdr,omitempty"`
+	Languages   []string  `json:"languages,omitempty"`
+	ReleaseName string    `json:"release_name" example:"The.Matrix.1999.1080p.BluRay.x264-GROUP"`
+	FileSize    int64     `json:"file_size" example:"2147483648"`
+	Origin      string    `json:"origin" example:"torrent"`
+	AddedAt     time.Time `json:"added_at"`
+	Default     bool      `json:"default" example:"true"`
+	StreamURL   string    `json:"stream_url" example:"/api/stream/603/sources/12"`
+	DirectPlay  bool      `json:"direct_play" example:"false"`
+	DirectURL   string    `json:"direct_play_url,omitempty" example:"/api/stream/603/sources/12/direct"`
 }
 
 type Cast struct {
@@ -84,11 +189,47 @@ type Genre struct {
 
 type Comment struct {
 	gorm.Model
-	MovieID  int    `json:"movie_id"`
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	Avatar   string `json:"avatar"`
-	Content  string `json:"content"`
+	MovieID   int        `gorm:"index;index:idx_comment_timestamp" json:"movie_id"`
+	UserID    int        `json:"user_id"`
+	Username  string     `json:"username"`
+	Avatar    string     `json:"avatar"`
+	Content   string     `json:"content"`
+	ParentID  *uint      `gorm:"index" json:"parent_id,omitempty"`
+	Depth     int        `gorm:"not null;default:0" json:"depth"` // 0 for comments, 1 for their replies, and so on
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Timestamp *int       `gorm:"index:idx_comment_timestamp" json:"timestamp,omitempty"` // seconds into the movie the comment is anchored to
+
+	// Status is "visible", "hidden" from everyone but administrators, or
+	// "removed", shown without its content so that its replies keep their
+	// place.
+	Status      string     `gorm:"size:10;not null;default:visible;index" json:"status"`
+	ModeratedBy *uint      `json:"-"`
+	ModeratedAt *time.Time `json:"-"`
+}
+
+// CommentReaction is the reaction of a user to a comment. A user has at most
+// one reaction per comment.
+type CommentReaction struct {
+	ID        uint   `gorm:"primaryKey"`
+	CommentID uint   `gorm:"not null;uniqueIndex:idx_comment_reaction"`
+	UserID    uint   `gorm:"not null;uniqueIndex:idx_comment_reaction"`
+	Type      string `gorm:"size:20;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CommentReport is a report of a comment by a user, waiting in the moderation
+// queue until an administrator handles it.
+type CommentReport struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	CommentID  uint       `gorm:"not null;uniqueIndex:idx_comment_reporter" json:"comment_id"`
+	ReporterID uint       `gorm:"not null;uniqueIndex:idx_comment_reporter" json:"reporter_id"`
+	Reason     string     `gorm:"size:20;not null" json:"reason"`
+	Details    string     `gorm:"type:text" json:"details,omitempty"`
+	Status     string     `gorm:"size:10;not null;default:open;index" json:"status"` // "open", "resolved" or "dismissed"
+	ResolvedBy *uint      `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
 type WatchHistory struct {
@@ -105,6 +246,37 @@ type WatchHistory struct {
 	WatchCount    int       `gorm:"default:0" json:"watch_count"`
 }
 
+// WatchlistItem is a movie a user saved to watch later. The title and poster
+// are cached so that the list is shown without calling TMDB.
+type WatchlistItem struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	UserID     uint      `gorm:"not null;uniqueIndex:idx_user_watchlist" json:"-"`
+	MovieID    int       `gorm:"not null;uniqueIndex:idx_user_watchlist" json:"movie_id"`
+	MovieTitle string    `gorm:"size:500" json:"movie_title"`
+	PosterPath string    `gorm:"size:500" json:"poster_path"`
+	Position   int       `gorm:"not null;default:0" json:"position"`
+	CreatedAt  time.Time `json:"added_at"`
+	UpdatedAt  time.Time `json:"-"`
+}
+
+// Rating is the score, from 1 to 10, a user gave a movie, with an optional
+// review. The movie fields are cached so that locally rated movies are listed
+// without calling TMDB.
+type Rating struct {
+	ID          uint          `gorm:"primaryKey" json:"-"`
+	UserID      uint          `gorm:"not null;uniqueIndex:idx_user_rating" json:"-"`
+	User        User          `gorm:"foreignKey:UserID" json:"-"`
+	MovieID     int           `gorm:"not null;uniqueIndex:idx_user_rating;index" json:"movie_id"`
+	MovieTitle  string        `gorm:"size:500" json:"movie_title"`
+	PosterPath  string        `gorm:"size:500" json:"poster_path"`
+	ReleaseDate string        `gorm:"size:10" json:"release_date"`
+	GenreIDs    pq.Int64Array `gorm:"type:integer[]" json:"-"`
+	Score       int           `gorm:"not null" json:"score"`
+	Review      string        `gorm:"type:text" json:"review,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
 type TorrentResult struct {
 	Name     string `json:"name"`
 	Magnet   string `json:"magnet"`
@@ -121,6 +293,8 @@ type TorrentDownload struct {
 	MovieID        int          `json:"movie_id"`
 	Quality        string       `json:"quality"`
 	Progress       float64      `json:"progress"`
+	DownloadRate   int64        `json:"download_rate"` // bytes per second
+	UploadRate     int64        `json:"upload_rate"`   // bytes per second
 	Status         string       `json:"status"`
 	StreamReady    bool         `json:"stream_ready"`
 	StreamingReady bool         `json:"streaming_ready"`
@@ -128,7 +302,16 @@ type TorrentDownload struct {
 	SubtitlePath   string       `json:"subtitle_path"`
 	StartedAt      time.Time    `json:"started_at"`
 	CompletedAt    *time.Time   `json:"completed_at,omitempty"`
+	Transcoded     bool         `json:"transcoded"` // HLS output no longer needs the torrent
+	SeedingSince   *time.Time   `json:"seeding_since,omitempty"`
 	Mu             sync.RWMutex `json:"-"`
+
+	// Started is closed once the video file of a registered download is
+	// selected, or the download failed.
+	Started chan struct{} `json:"-"`
+	// Resumed downloads were interrupted by a restart; they stay tracked
+	// when they fail, to be tried again on the next one.
+	Resumed bool `json:"-"`
 }
 
 type TranscodeJob struct {
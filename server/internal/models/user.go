@@ -23,4 +23,5 @@ type User struct {
 	Password       string `json:"-" example:"aK62p1HYiC1f"`
 	Provider       string `json:"-"`
 	ProviderId     string `json:"-"`
+	IsAdmin        bool   `gorm:"default:false" json:"is_admin"`
 }
@@ -0,0 +1,484 @@
+// Package release parses scene-style release names such as
+// "The.Matrix.1999.1080p.BluRay.x264-GROUP" into their parts.
+package release
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Info is what a release name says about a movie file.
+type Info struct {
+	Title         string   `json:"title"`
+	Year          int      `json:"year,omitempty"`
+	Resolution    string   `json:"resolution,omitempty"`
+	Source        string   `json:"source,omitempty"`
+	VideoCodec    string   `json:"video_codec,omitempty"`
+	AudioCodec    string   `json:"audio_codec,omitempty"`
+	AudioChannels string   `json:"audio_channels,omitempty"`
+	Atmos         bool     `json:"atmos,omitempty"`
+	HDR           []string `json:"hdr,omitempty"`
+	Languages     []string `json:"languages,omitempty"`
+	Edition       string   `json:"edition,omitempty"`
+	Group         string   `json:"group,omitempty"`
+}
+
+// Quality is the short quality label stored with downloaded movies.
+func (i Info) Quality() string {
+	return i.Resolution
+}
+
+// token is a normalized value and the pattern that recognizes it. Patterns
+// are tried in order and the first one that matches wins.
+type token struct {
+	value   string
+	pattern *regexp.Regexp
+}
+
+func tokens(pairs ...string) []token {
+	list := make([]token, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		list = append(list, token{
+			value:   pairs[i],
+			pattern: regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(` + pairs[i+1] + `)(?:$|[^a-z0-9])`),
+		})
+	}
+	return list
+}
+
+var (
+	resolutions = tokens(
+		"2160p", `2160[pi]|4k|uhd|3840x2160`,
+		"1440p", `1440p|2560x1440`,
+		"1080p", `1080[pi]|1920x1080|fhd`,
+		"720p", `720p|1280x720`,
+		"576p", `576[pi]`,
+		"480p", `480[pi]|sd`,
+		"360p", `360p`,
+	)
+
+	sources = tokens(
+		"Remux", `(?:bd|uhd|bluray|blu-ray)?\s?remux`,
+		"BluRay", `blu-?ray|bdrip|brrip|bd(?:25|50)?|uhd\s?bluray`,
+		"WEBRip", `web-?rip`,
+		"WEB-DL", `web-?dl|web|amzn|nf|dsnp|hmax|atvp`,
+		"HDTV", `hdtv|pdtv`,
+		"DVD", `dvd-?rip|dvd(?:5|9)?|dvd-?r`,
+		"HDRip", `hdrip`,
+		"Screener", `dvd-?scr|screener|scr`,
+		"Telecine", `telecine|hdtc|tc`,
+		"Telesync", `telesync|hdts|ts`,
+		"CAM", `hdcam|camrip|cam`,
+	)
+
+	videoCodecs = tokens(
+		"H.265", `[xh]\s?265|hevc`,
+		"H.264", `[xh]\s?264|avc`,
+		"AV1", `av1`,
+		"VP9", `vp9`,
+		"XviD", `xvid|divx`,
+	)
+
+	audioCodecs = tokens(
+		"DTS-HD MA", `dts-?hd\s?ma|dts-?hd\s?master\s?audio`,
+		"DTS:X", `dts-?x`,
+		"DTS-HD", `dts-?hd`,
+		"TrueHD", `true-?hd`,
+		"DTS", `dts`,
+		"EAC3", `ddp(?:\s?[257]\.[01])?|dd\+|e-?ac-?3`,
+		"AC3", `dd(?:\s?[257]\.[01])?|ac-?3|dolby\s?digital`,
+		"AAC", `aac(?:\s?[257]\.[01])?`,
+		"FLAC", `flac`,
+		"Opus", `opus`,
+		"MP3", `mp3`,
+		"LPCM", `l?pcm`,
+	)
+
+	hdrFormats = tokens(
+		"HDR10+", `hdr10(?:\+|plus)`,
+		"HDR10", `hdr10`,
+		"HDR", `hdr`,
+		"DV", `dv|dovi|dolby\s?vision`,
+		"HLG", `hlg`,
+	)
+
+	languages = tokens(
+		"multi", `multi|dual\s?audio`,
+		"fr", `french|truefrench|vff|vfq|vf2|vostfr`,
+		"en", `english|eng`,
+		"de", `german|deutsch`,
+		"es", `spanish|castellano|latino|esp`,
+		"it", `italian|ita`,
+		"pt", `portuguese|por`,
+		"ru", `russian|rus`,
+		"ja", `japanese|jpn`,
+		"ko", `korean|kor`,
+		"zh", `chinese|chs|cht`,
+		"hi", `hindi`,
+	)
+
+	editions = tokens(
+		"Director's Cut", `director'?s\s?cut|dc`,
+		"Extended", `extended(?:\s?(?:cut|edition))?`,
+		"Theatrical", `theatrical(?:\s?cut)?`,
+		"Unrated", `unrated`,
+		"Uncut", `uncut`,
+		"Final Cut", `final\s?cut`,
+		"Ultimate", `ultimate\s?(?:cut|edition)`,
+		"Special Edition", `special\s?edition`,
+		"Anniversary", `\d+(?:th)?\s?anniversary(?:\s?edition)?`,
+		"Remastered", `remastered`,
+		"Criterion", `criterion`,
+		"IMAX", `imax`,
+	)
+
+	// Tokens that never start a title, used to find where the title ends
+	// when a name has no year.
+	markers = tokens(
+		"", `proper|repack|internal|limited|complete|3d|hsbs|10bit|8bit|hi10p|\d{3,4}x\d{3,4}`,
+	)
+
+	channelsPattern = regexp.MustCompile(`(?:^|[^\d.])([1-9]\.[0-2])(?:$|[^\d])`)
+	atmosPattern    = regexp.MustCompile(`(?i)(?:^|[^a-z])atmos(?:$|[^a-z])`)
+	numberPattern   = regexp.MustCompile(`\d+`)
+
+	leadingGroup  = regexp.MustCompile(`^\[([^\]]+)\]\s*`)
+	trailingGroup = regexp.MustCompile(`-\s?([A-Za-z0-9]+)(?:\s?\[[^\]]*\])?$`)
+	trailingTag   = regexp.MustCompile(`\s?\[([^\]]+)\]$`)
+	sitePrefix    = regexp.MustCompile(`(?i)^(?:www\.)?[a-z0-9-]+\.(?:com|org|net|to|mx|se)\s*-\s*`)
+)
+
+var videoExts = map[string]bool{
+	".mkv": true, ".mp4": true, ".avi": true, ".mov": true, ".wmv": true,
+	".webm": true, ".m4v": true, ".ts": true, ".m2ts": true, ".mpg": true,
+}
+
+// Parse extracts everything it recognizes from a release name. The name may
+// be a torrent name, a file name with its extension or a folder name.
+func Parse(name string) Info {
+	var info Info
+
+	name = strings.TrimSpace(name)
+	if i := strings.IndexAny(name, "\r\n"); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	if videoExts[strings.ToLower(filepath.Ext(name))] {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	name = sitePrefix.ReplaceAllString(name, "")
+
+	name, info.Group = extractGroup(name)
+
+	s := normalize(name)
+
+	titleEnd, yearPos := titleBoundary(s)
+	if yearPos >= 0 {
+		info.Year, _ = strconv.Atoi(s[yearPos : yearPos+4])
+	}
+
+	// Everything but the title comes after it, so that words of a title
+	// such as "Cam" or "French" are not mistaken for tags.
+	rest := s[titleEnd:]
+	info.Resolution, _ = match(rest, resolutions)
+	info.Source, _ = match(rest, sources)
+	info.VideoCodec, _ = match(rest, videoCodecs)
+	info.AudioCodec, _ = match(rest, audioCodecs)
+	info.Atmos = atmosPattern.MatchString(rest)
+	info.HDR = matchAll(rest, hdrFormats)
+	info.Languages = matchAll(rest, languages)
+	info.Edition, _ = match(rest, editions)
+	if m := channelsPattern.FindStringSubmatch(rest); m != nil {
+		info.AudioChannels = m[1]
+	}
+
+	// An edition may also sit between the title and the year.
+	title := s[:titleEnd]
+	if edition, pos := firstMatch(title, editions); pos > 0 {
+		title = title[:pos]
+		if info.Edition == "" {
+			info.Edition = edition
+		}
+	}
+	info.Title = cleanTitle(title)
+
+	return info
+}
+
+// extractGroup removes the release group from a name and returns both.
+func extractGroup(name string) (string, string) {
+	if m := leadingGroup.FindStringSubmatch(name); m != nil && !isKnownToken(m[1]) {
+		return strings.TrimSpace(name[len(m[0]):]), strings.TrimSpace(m[1])
+	}
+
+	// A dash only introduces a group after the technical part, unlike in
+	// titles such as "Spider-Man".
+	if m := trailingGroup.FindStringSubmatchIndex(name); m != nil {
+		group := name[m[2]:m[3]]
+		if !isKnownToken(group) && hasTechnicalToken(normalize(name[:m[0]])) {
+			return strings.TrimSpace(name[:m[0]]), group
+		}
+	}
+
+	if m := trailingTag.FindStringSubmatchIndex(name); m != nil {
+		group := name[m[2]:m[3]]
+		if !isKnownToken(group) && len(yearPositions(group)) == 0 {
+			return strings.TrimSpace(name[:m[0]]), group
+		}
+	}
+
+	return name, ""
+}
+
+// isKnownToken reports whether a candidate group is really a technical
+// token, as in "WEB-DL" or "DTS-HD".
+func isKnownToken(s string) bool {
+	switch strings.ToLower(s) {
+	case "dl", "rip", "hd", "ma", "x", "ray", "dvdrip", "webrip", "bdrip", "brrip":
+		return true
+	}
+	return hasTechnicalToken(s)
+}
+
+func hasTechnicalToken(s string) bool {
+	for _, list := range [][]token{resolutions, sources, videoCodecs, audioCodecs, hdrFormats} {
+		if v, _ := match(s, list); v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize turns separators into spaces, keeping the dots of numbers such as
+// "5.1" or "H.264" readable by the patterns.
+func normalize(name string) string {
+	r := []rune(name)
+	out := make([]rune, 0, len(r))
+	for i, c := range r {
+		switch c {
+		case '_', '(', ')', '[', ']', '{', '}':
+			out = append(out, ' ')
+		case '.':
+			// Only single digits, so that "1917.2019" still splits.
+			if i > 0 && i+1 < len(r) && isDigit(r[i-1]) && isDigit(r[i+1]) && (i < 2 || !isDigit(r[i-2])) {
+				out = append(out, c)
+			} else {
+				out = append(out, ' ')
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return strings.Join(strings.Fields(string(out)), " ")
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// titleBoundary returns where the title ends and where the year starts, -1
+// when there is none. The year is the last one before the first technical
+// token, so that titles such as "2001 A Space Odyssey" keep their number.
+func titleBoundary(s string) (int, int) {
+	techStart := len(s)
+	for _, list := range [][]token{resolutions, sources, videoCodecs, audioCodecs, hdrFormats, markers} {
+		if _, pos := firstMatch(s, list); pos > 0 && pos < techStart {
+			techStart = pos
+		}
+	}
+
+	yearPos := -1
+	for _, pos := range yearPositions(s) {
+		if pos > 0 && pos < techStart {
+			yearPos = pos
+		}
+	}
+	if yearPos > 0 {
+		return yearPos, yearPos
+	}
+
+	// Without a year, languages and editions may also end the title.
+	for _, list := range [][]token{languages, editions} {
+		if _, pos := firstMatch(s, list); pos > 0 && pos < techStart {
+			techStart = pos
+		}
+	}
+
+	// A name made only of a year, like "1917", is a title.
+	return techStart, -1
+}
+
+// yearPositions returns where the standalone years of s start.
+func yearPositions(s string) []int {
+	var positions []int
+	for _, loc := range numberPattern.FindAllStringIndex(s, -1) {
+		if loc[1]-loc[0] != 4 || (s[loc[0]:loc[0]+2] != "19" && s[loc[0]:loc[0]+2] != "20") {
+			continue
+		}
+		if loc[0] > 0 && isLetter(s[loc[0]-1]) || loc[1] < len(s) && (isLetter(s[loc[1]]) || s[loc[1]] == '.') {
+			continue
+		}
+		positions = append(positions, loc[0])
+	}
+	return positions
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func cleanTitle(s string) string {
+	s = strings.Trim(s, " -+~")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// match returns the value of the first token found in s and its position.
+func match(s string, list []token) (string, int) {
+	for _, t := range list {
+		if loc := t.pattern.FindStringSubmatchIndex(s); loc != nil {
+			return t.value, loc[2]
+		}
+	}
+	return "", -1
+}
+
+// firstMatch returns the token found first in s, ignoring matches at its very
+// start, and its position, -1 when there is none.
+func firstMatch(s string, list []token) (string, int) {
+	value, first := "", -1
+	for _, t := range list {
+		for _, loc := range t.pattern.FindAllStringSubmatchIndex(s, -1) {
+			if loc[2] > 0 && (first < 0 || loc[2] < first) {
+				value, first = t.value, loc[2]
+			}
+		}
+	}
+	return value, first
+}
+
+// matchAll returns the values of every token found in s, in list order.
+func matchAll(s string, list []token) []string {
+	var values []string
+	for _, t := range list {
+		if t.pattern.MatchString(s) {
+			values = append(values, t.value)
+		}
+	}
+	// HDR10+ and HDR10 imply HDR; keep only the most specific flag.
+	if contains(values, "HDR10+") {
+		values = removeValue(values, "HDR10")
+	}
+	if contains(values, "HDR10+") || contains(values, "HDR10") {
+		values = removeValue(values, "HDR")
+	}
+	return values
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func removeValue(values []string, v string) []string {
+	out := values[:0]
+	for _, value := range values {
+		if value != v {
+			out = append(out, value)
+		}
+	}
+	return out
+}
+
+var resolutionScores = map[string]int{
+	"1080p": 40,
+	"720p":  30,
+	"2160p": 25,
+	"1440p": 25,
+	"576p":  15,
+	"480p":  10,
+	"360p":  5,
+}
+
+var sourceScores = map[string]int{
+	"BluRay":   20,
+	"WEB-DL":   18,
+	"Remux":    12,
+	"WEBRip":   15,
+	"HDTV":     8,
+	"DVD":      8,
+	"HDRip":    6,
+	"Screener": -40,
+	"Telecine": -50,
+	"Telesync": -50,
+	"CAM":      -60,
+}
+
+var videoCodecScores = map[string]int{
+	"H.264": 6,
+	"H.265": 3,
+	"AV1":   1,
+	"XviD":  -5,
+}
+
+// Score ranks a release for streaming: a resolution the transcoder handles
+// well, a clean source and a widely supported codec score higher. Camera
+// and telesync recordings score below zero.
+func (i Info) Score() int {
+	return resolutionScores[i.Resolution] + sourceScores[i.Source] + videoCodecScores[i.VideoCodec]
+}
+
+// Similarity tells how alike two releases are, e.g. to pick the subtitle
+// whose timing was made for a given file. Releases of different years never
+// match.
+func Similarity(a, b Info) int {
+	if a.Year != 0 && b.Year != 0 && a.Year != b.Year {
+		return 0
+	}
+
+	score := 0
+	if a.Group != "" && strings.EqualFold(a.Group, b.Group) {
+		score += 8
+	}
+	if a.Source != "" && a.Source == b.Source {
+		score += 4
+	}
+	if a.Edition == b.Edition {
+		score += 3
+	}
+	if a.Resolution != "" && a.Resolution == b.Resolution {
+		score += 1
+	}
+	if a.VideoCodec != "" && a.VideoCodec == b.VideoCodec {
+		score += 1
+	}
+	return score
+}
+
+// SortByScore orders names from the best release to the worst, keeping the
+// original order between releases of the same score.
+func SortByScore[T any](items []T, name func(T) string) {
+	scores := make(map[int]int, len(items))
+	for idx, item := range items {
+		scores[idx] = Parse(name(item)).Score()
+	}
+
+	indexes := make([]int, len(items))
+	for idx := range indexes {
+		indexes[idx] = idx
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return scores[indexes[a]] > scores[indexes[b]]
+	})
+
+	sorted := make([]T, len(items))
+	for to, from := range indexes {
+		sorted[to] = items[from]
+	}
+	copy(items, sorted)
+}
@@ -0,0 +1,345 @@
+package release
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Info
+	}{
+		{
+			name: "The.Matrix.1999.1080p.BluRay.x264-GROUP",
+			want: Info{Title: "The Matrix", Year: 1999, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Group: "GROUP"},
+		},
+		{
+			name: "The.Matrix.1999.1080p.BluRay.x264-GROUP.mkv",
+			want: Info{Title: "The Matrix", Year: 1999, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Group: "GROUP"},
+		},
+		{
+			name: "The Matrix (1999) [1080p] [BluRay] [5.1] [YTS.MX]",
+			want: Info{Title: "The Matrix", Year: 1999, Resolution: "1080p", Source: "BluRay", AudioChannels: "5.1", Group: "YTS.MX"},
+		},
+		{
+			name: "The Matrix (1999)",
+			want: Info{Title: "The Matrix", Year: 1999},
+		},
+		{
+			name: "The Matrix",
+			want: Info{Title: "The Matrix"},
+		},
+		{
+			name: "Inception.2010.2160p.UHD.BluRay.REMUX.HDR10.HEVC.TrueHD.Atmos.7.1-FGT",
+			want: Info{Title: "Inception", Year: 2010, Resolution: "2160p", Source: "Remux", VideoCodec: "H.265", AudioCodec: "TrueHD", AudioChannels: "7.1", Atmos: true, HDR: []string{"HDR10"}, Group: "FGT"},
+		},
+		{
+			name: "Dune.Part.Two.2024.2160p.WEB-DL.DDP5.1.Atmos.DV.HDR.H.265-FLUX",
+			want: Info{Title: "Dune Part Two", Year: 2024, Resolution: "2160p", Source: "WEB-DL", VideoCodec: "H.265", AudioCodec: "EAC3", AudioChannels: "5.1", Atmos: true, HDR: []string{"HDR", "DV"}, Group: "FLUX"},
+		},
+		{
+			name: "Oppenheimer.2023.1080p.AMZN.WEB-DL.DDP5.1.H.264-GP",
+			want: Info{Title: "Oppenheimer", Year: 2023, Resolution: "1080p", Source: "WEB-DL", VideoCodec: "H.264", AudioCodec: "EAC3", AudioChannels: "5.1", Group: "GP"},
+		},
+		{
+			name: "Parasite.2019.KOREAN.1080p.BluRay.x264.DTS-FGT",
+			want: Info{Title: "Parasite", Year: 2019, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", AudioCodec: "DTS", Languages: []string{"ko"}, Group: "FGT"},
+		},
+		{
+			name: "Amelie.2001.FRENCH.720p.BRRip.x264.AAC-ETRG",
+			want: Info{Title: "Amelie", Year: 2001, Resolution: "720p", Source: "BluRay", VideoCodec: "H.264", AudioCodec: "AAC", Languages: []string{"fr"}, Group: "ETRG"},
+		},
+		{
+			name: "Le.Fabuleux.Destin.d.Amelie.Poulain.2001.MULTi.TRUEFRENCH.1080p.BluRay.x264-LOST",
+			want: Info{Title: "Le Fabuleux Destin d Amelie Poulain", Year: 2001, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Languages: []string{"multi", "fr"}, Group: "LOST"},
+		},
+		{
+			name: "The.French.Connection.1971.1080p.BluRay.x264-AMIABLE",
+			want: Info{Title: "The French Connection", Year: 1971, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Group: "AMIABLE"},
+		},
+		{
+			name: "Blade.Runner.1982.The.Final.Cut.1080p.BluRay.x264-SPARKS",
+			want: Info{Title: "Blade Runner", Year: 1982, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Edition: "Final Cut", Group: "SPARKS"},
+		},
+		{
+			name: "Blade.Runner.2049.2017.2160p.UHD.BluRay.x265.10bit.HDR.DTS-HD.MA.7.1-SWTYBLZ",
+			want: Info{Title: "Blade Runner 2049", Year: 2017, Resolution: "2160p", Source: "BluRay", VideoCodec: "H.265", AudioCodec: "DTS-HD MA", AudioChannels: "7.1", HDR: []string{"HDR"}, Group: "SWTYBLZ"},
+		},
+		{
+			name: "2001.A.Space.Odyssey.1968.1080p.BluRay.x264-AMIABLE",
+			want: Info{Title: "2001 A Space Odyssey", Year: 1968, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Group: "AMIABLE"},
+		},
+		{
+			name: "1917.2019.1080p.WEBRip.x264.AAC5.1-YIFY",
+			want: Info{Title: "1917", Year: 2019, Resolution: "1080p", Source: "WEBRip", VideoCodec: "H.264", AudioCodec: "AAC", AudioChannels: "5.1", Group: "YIFY"},
+		},
+		{
+			name: "1917 (2019)",
+			want: Info{Title: "1917", Year: 2019},
+		},
+		{
+			name: "1917",
+			want: Info{Title: "1917"},
+		},
+		{
+			name: "Spider-Man",
+			want: Info{Title: "Spider-Man"},
+		},
+		{
+			name: "Spider-Man.2002.720p.BluRay.x264-REFiNED",
+			want: Info{Title: "Spider-Man", Year: 2002, Resolution: "720p", Source: "BluRay", VideoCodec: "H.264", Group: "REFiNED"},
+		},
+		{
+			name: "Spider-Man.Into.the.Spider-Verse.2018.1080p.WEB-DL",
+			want: Info{Title: "Spider-Man Into the Spider-Verse", Year: 2018, Resolution: "1080p", Source: "WEB-DL"},
+		},
+		{
+			name: "Aliens.1986.Special.Edition.1080p.BluRay.x264-CiNEFiLE",
+			want: Info{Title: "Aliens", Year: 1986, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Edition: "Special Edition", Group: "CiNEFiLE"},
+		},
+		{
+			name: "The.Lord.of.the.Rings.The.Fellowship.of.the.Ring.2001.EXTENDED.1080p.BluRay.x264-FSiHD",
+			want: Info{Title: "The Lord of the Rings The Fellowship of the Ring", Year: 2001, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Edition: "Extended", Group: "FSiHD"},
+		},
+		{
+			name: "Kingdom.of.Heaven.2005.Directors.Cut.720p.BluRay.DTS.x264-ESiR",
+			want: Info{Title: "Kingdom of Heaven", Year: 2005, Resolution: "720p", Source: "BluRay", VideoCodec: "H.264", AudioCodec: "DTS", Edition: "Director's Cut", Group: "ESiR"},
+		},
+		{
+			name: "Apocalypse.Now.1979.REMASTERED.1080p.BluRay.x265-RARBG",
+			want: Info{Title: "Apocalypse Now", Year: 1979, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.265", Edition: "Remastered", Group: "RARBG"},
+		},
+		{
+			name: "Interstellar.2014.IMAX.2160p.WEB-DL.HDR10+.HEVC-GROUP",
+			want: Info{Title: "Interstellar", Year: 2014, Resolution: "2160p", Source: "WEB-DL", VideoCodec: "H.265", HDR: []string{"HDR10+"}, Edition: "IMAX", Group: "GROUP"},
+		},
+		{
+			name: "Alien.1979.40th.Anniversary.Edition.1080p.BluRay.x264-GROUP",
+			want: Info{Title: "Alien", Year: 1979, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Edition: "Anniversary", Group: "GROUP"},
+		},
+		{
+			name: "Gladiator.Extended.Cut.2000.1080p.BluRay.x264",
+			want: Info{Title: "Gladiator", Year: 2000, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Edition: "Extended"},
+		},
+		{
+			name: "Movie.Title.2023.HDCAM.x264-NoGroup",
+			want: Info{Title: "Movie Title", Year: 2023, Source: "CAM", VideoCodec: "H.264", Group: "NoGroup"},
+		},
+		{
+			name: "Movie.Title.2023.HDTS.x264-GRP",
+			want: Info{Title: "Movie Title", Year: 2023, Source: "Telesync", VideoCodec: "H.264", Group: "GRP"},
+		},
+		{
+			name: "Movie.Title.2023.DVDSCR.XviD-GRP",
+			want: Info{Title: "Movie Title", Year: 2023, Source: "Screener", VideoCodec: "XviD", Group: "GRP"},
+		},
+		{
+			name: "Cam.2018.720p.NF.WEB-DL.DDP5.1.x264-NTG",
+			want: Info{Title: "Cam", Year: 2018, Resolution: "720p", Source: "WEB-DL", VideoCodec: "H.264", AudioCodec: "EAC3", AudioChannels: "5.1", Group: "NTG"},
+		},
+		{
+			name: "Old.Movie.1975.DVDRip.XviD.MP3-RARE",
+			want: Info{Title: "Old Movie", Year: 1975, Source: "DVD", VideoCodec: "XviD", AudioCodec: "MP3", Group: "RARE"},
+		},
+		{
+			name: "Some.Movie.2012.HDTV.720p.x264.AC3-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "720p", Source: "HDTV", VideoCodec: "H.264", AudioCodec: "AC3", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.WEB-Rip.1080p.AV1.Opus-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "WEBRip", VideoCodec: "AV1", AudioCodec: "Opus", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.1080p.BluRay.FLAC.2.0.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", AudioCodec: "FLAC", AudioChannels: "2.0", Group: "GRP"},
+		},
+		{
+			name: "Some Movie 2012 1080p BluRay DD5.1 x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", AudioCodec: "AC3", AudioChannels: "5.1", Group: "GRP"},
+		},
+		{
+			name: "Some_Movie_2012_720p_BluRay_x264",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "720p", Source: "BluRay", VideoCodec: "H.264"},
+		},
+		{
+			name: "Some.Movie.2012.1080p.BluRay.DTS-X.7.1.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", AudioCodec: "DTS:X", AudioChannels: "7.1", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.1080p.WEB-DL",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "WEB-DL"},
+		},
+		{
+			name: "Some.Movie.2012.1080p.BluRay.DTS-HD",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", AudioCodec: "DTS-HD"},
+		},
+		{
+			name: "Some.Movie.2012.2160p.WEB-DL.DoVi.HEVC-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "2160p", Source: "WEB-DL", VideoCodec: "H.265", HDR: []string{"DV"}, Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.4K.HLG.HEVC",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "2160p", VideoCodec: "H.265", HDR: []string{"HLG"}},
+		},
+		{
+			name: "Some.Movie.2012.PROPER.1080p.BluRay.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.1080p.BluRay.x264-GRP",
+			want: Info{Title: "Some Movie", Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.GERMAN.DL.1080p.BluRay.x264-GRP",
+			want: Info{Title: "Some Movie", Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Languages: []string{"de"}, Group: "GRP"},
+		},
+		{
+			name: "Some Movie 2012 1080p Dual Audio Hindi English x264",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", VideoCodec: "H.264", Languages: []string{"multi", "en", "hi"}},
+		},
+		{
+			name: "Some.Movie.2012.ITA.ENG.1080p.BluRay.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Languages: []string{"en", "it"}, Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.SPANISH.1080p.WEBRip.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "WEBRip", VideoCodec: "H.264", Languages: []string{"es"}, Group: "GRP"},
+		},
+		{
+			name: "[YTS] Some Movie (2012) 720p",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "720p", Group: "YTS"},
+		},
+		{
+			name: "www.Torrenting.com - Some.Movie.2012.1080p.WEBRip.x264",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "WEBRip", VideoCodec: "H.264"},
+		},
+		{
+			name: "Some.Movie.2012.1080p.BluRay.x264-GRP[rarbg]",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.1080p.BluRay.x264-GRP\n👤 123 💾 2.1 GB ⚙️ ThePirateBay",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Group: "GRP"},
+		},
+		{
+			name: "Some Movie (2012) 1920x1080 AVC",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", VideoCodec: "H.264"},
+		},
+		{
+			name: "Some.Movie.2012.480p.DVDRip.XviD-GRP.avi",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "480p", Source: "DVD", VideoCodec: "XviD", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.576p.BDRip.x264",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "576p", Source: "BluRay", VideoCodec: "H.264"},
+		},
+		{
+			name: "Some.Movie.2012.UNRATED.1080p.BluRay.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Edition: "Unrated", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.Theatrical.Cut.1080p.BluRay.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Edition: "Theatrical", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.Criterion.1080p.BluRay.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", Edition: "Criterion", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.1080p.BluRay.LPCM.2.0.AVC",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", AudioCodec: "LPCM", AudioChannels: "2.0"},
+		},
+		{
+			name: "Some.Movie.2012.HDRip.XviD.AC3-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Source: "HDRip", VideoCodec: "XviD", AudioCodec: "AC3", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.720p.HDTC.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "720p", Source: "Telecine", VideoCodec: "H.264", Group: "GRP"},
+		},
+		{
+			name: "Some.Movie.2012.1080p.BluRay.E-AC-3.x264-GRP",
+			want: Info{Title: "Some Movie", Year: 2012, Resolution: "1080p", Source: "BluRay", VideoCodec: "H.264", AudioCodec: "EAC3", Group: "GRP"},
+		},
+		{
+			name: "",
+			want: Info{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.name)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	ordered := []string{
+		"Some.Movie.2012.1080p.BluRay.x264-GRP",
+		"Some.Movie.2012.720p.WEBRip.x264-GRP",
+		"Some.Movie.2012.2160p.BluRay.x265-GRP",
+		"Some.Movie.2012.480p.DVDRip.XviD-GRP",
+		"Some.Movie.2012.HDCAM.x264-GRP",
+	}
+
+	for i := 1; i < len(ordered); i++ {
+		prev, cur := Parse(ordered[i-1]).Score(), Parse(ordered[i]).Score()
+		if prev <= cur {
+			t.Errorf("Score(%q) = %d, want more than Score(%q) = %d", ordered[i-1], prev, ordered[i], cur)
+		}
+	}
+
+	if score := Parse("Some.Movie.2012.HDTS.x264-GRP").Score(); score >= 0 {
+		t.Errorf("telesync score = %d, want below zero", score)
+	}
+}
+
+func TestSortByScore(t *testing.T) {
+	names := []string{
+		"Some.Movie.2012.HDCAM.x264-A",
+		"Some.Movie.2012.720p.BluRay.x264-B",
+		"Some.Movie.2012.1080p.BluRay.x264-C",
+		"Some.Movie.2012.1080p.BluRay.x264-D",
+	}
+
+	SortByScore(names, func(s string) string { return s })
+
+	want := []string{
+		"Some.Movie.2012.1080p.BluRay.x264-C",
+		"Some.Movie.2012.1080p.BluRay.x264-D",
+		"Some.Movie.2012.720p.BluRay.x264-B",
+		"Some.Movie.2012.HDCAM.x264-A",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("SortByScore = %v, want %v", names, want)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	file := Parse("Some.Movie.2012.1080p.BluRay.x264-GRP")
+
+	tests := []struct {
+		name   string
+		better string
+		worse  string
+	}{
+		{"group wins", "Some.Movie.2012.720p.BluRay.x264-GRP", "Some.Movie.2012.1080p.WEB-DL.x264-OTHER"},
+		{"source wins", "Some.Movie.2012.720p.BluRay.x264-OTHER", "Some.Movie.2012.1080p.WEB-DL.x264-OTHER"},
+		{"edition wins", "Some.Movie.2012.1080p.WEB-DL.x264-OTHER", "Some.Movie.2012.EXTENDED.1080p.WEB-DL.x264-OTHER"},
+		{"year must match", "Some.Movie.720p.WEB-DL", "Some.Movie.1999.1080p.BluRay.x264-GRP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			better, worse := Similarity(file, Parse(tt.better)), Similarity(file, Parse(tt.worse))
+			if better <= worse {
+				t.Errorf("Similarity(%q) = %d, want more than Similarity(%q) = %d", tt.better, better, tt.worse, worse)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"server/internal/controllers"
+
+	"github.com/labstack/echo/v4"
+)
+
+func AddAdminRouter(adminRouter *echo.Group, adminController *controllers.AdminController) {
+	adminRouter.GET("/torrents/rate-limits", adminController.GetTorrentRateLimits)
+	adminRouter.PUT("/torrents/rate-limits", adminController.UpdateTorrentRateLimits)
+	adminRouter.GET("/downloads", adminController.ListDownloads)
+	adminRouter.POST("/movies/:id/torrent", adminController.ImportMovieTorrent)
+	adminRouter.GET("/movies/:id/torrent", adminController.GetMovieTorrent)
+	adminRouter.DELETE("/movies/:id/torrent", adminController.DeleteMovieTorrent)
+	adminRouter.GET("/library", adminController.GetLibraryScan)
+	adminRouter.GET("/library/scan", adminController.GetLibraryScanStatus)
+	adminRouter.POST("/library/scan", adminController.ScanLibrary)
+	adminRouter.GET("/storage", adminController.GetStorage)
+	adminRouter.POST("/storage/enforce", adminController.EnforceStorage)
+	adminRouter.GET("/reconcile", adminController.GetReconciliation)
+	adminRouter.POST("/reconcile", adminController.Reconcile)
+	adminRouter.GET("/comments/reports", adminController.GetCommentReports)
+	adminRouter.POST("/comments/:id/moderate", adminController.ModerateComment)
+}
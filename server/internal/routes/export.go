@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"server/internal/controllers"
+	"server/internal/middlewares"
+
+	"github.com/labstack/echo/v4"
+)
+
+func AddExportRouter(exportRouter *echo.Group, exportController *controllers.ExportController) {
+	exportRouter.POST("", exportController.CreateExport, middlewares.Authenticated, middlewares.AttachUser)
+	exportRouter.GET("", exportController.ListExports, middlewares.Authenticated, middlewares.AttachUser)
+	exportRouter.GET("/:id", exportController.GetExport, middlewares.Authenticated, middlewares.AttachUser)
+	exportRouter.GET("/:id/file", exportController.DownloadExport, middlewares.Authenticated, middlewares.AttachUser)
+	exportRouter.DELETE("/:id", exportController.DeleteExport, middlewares.Authenticated, middlewares.AttachUser)
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"server/internal/controllers"
+	"server/internal/middlewares"
+
+	"github.com/labstack/echo/v4"
+)
+
+func AddListRouter(listRouter *echo.Group, listController *controllers.ListController) {
+	listRouter.GET("/public", listController.GetPublicLists, middlewares.AccessTokenExtractor, middlewares.AttachUserOptional)
+	listRouter.GET("/public/:slug", listController.GetListBySlug, middlewares.AccessTokenExtractor, middlewares.AttachUserOptional)
+
+	listRouter.POST("", listController.CreateList, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.GET("", listController.GetLists, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.GET("/:id", listController.GetList, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.PATCH("/:id", listController.UpdateList, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.DELETE("/:id", listController.DeleteList, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.PUT("/:id/items", listController.ReorderListItems, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.PUT("/:id/items/:movieId", listController.AddListItem, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.DELETE("/:id/items/:movieId", listController.RemoveListItem, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.POST("/:id/collaborators", listController.AddListCollaborator, middlewares.Authenticated, middlewares.AttachUser)
+	listRouter.DELETE("/:id/collaborators/:username", listController.RemoveListCollaborator, middlewares.Authenticated, middlewares.AttachUser)
+}
@@ -11,6 +11,8 @@ func AddMovieRouter(movieRouter *echo.Group, movieController *controllers.MovieC
 	movieRouter.GET("/popular", movieController.GetMovies, middlewares.AccessTokenExtractor, middlewares.AttachUserOptional)
 	movieRouter.GET("/search", movieController.SearchMovies)
 	movieRouter.GET("/:id", movieController.GetMovieDetails, middlewares.AccessTokenExtractor, middlewares.AttachUserOptional)
+	movieRouter.GET("/:id/download", movieController.GetDownloadStatus, middlewares.Authenticated, middlewares.AttachUser)
+	movieRouter.POST("/:id/progress", movieController.ReportProgress, middlewares.Authenticated, middlewares.AttachUser)
 	movieRouter.GET("/:id/:source", movieController.GetMovieDetails, middlewares.AccessTokenExtractor, middlewares.AttachUserOptional)
 }
 
@@ -18,6 +20,15 @@ func AddCommentRouter(commentRouter *echo.Group, commentController *controllers.
 	commentRouter.POST("/add", commentController.AddComment, middlewares.Authenticated, middlewares.AttachUser)
 	commentRouter.GET("", commentController.GetComments, middlewares.Authenticated, middlewares.AttachUser)
 	commentRouter.GET("/:id", commentController.GetCommentByID, middlewares.Authenticated, middlewares.AttachUser)
+	commentRouter.GET("/:id/replies", commentController.GetCommentReplies, middlewares.AccessTokenExtractor, middlewares.AttachUserOptional)
+	commentRouter.PUT("/:id/reaction", commentController.ReactToComment, middlewares.Authenticated, middlewares.AttachUser)
+	commentRouter.DELETE("/:id/reaction", commentController.RemoveCommentReaction, middlewares.Authenticated, middlewares.AttachUser)
+	commentRouter.POST("/:id/report", commentController.ReportComment, middlewares.Authenticated, middlewares.AttachUser)
 	commentRouter.PATCH("/:id", commentController.UpdateComment, middlewares.Authenticated, middlewares.AttachUser)
 	commentRouter.DELETE("/:id", commentController.DeleteComment, middlewares.Authenticated, middlewares.AttachUser)
 }
+
+func AddMovieCommentRouter(movieRouter *echo.Group, commentController *controllers.CommentController) {
+	movieRouter.GET("/:id/comments", commentController.GetMovieComments, middlewares.AccessTokenExtractor, middlewares.AttachUserOptional)
+	movieRouter.GET("/:id/comments/timed", commentController.GetTimedComments, middlewares.AccessTokenExtractor, middlewares.AttachUserOptional)
+}
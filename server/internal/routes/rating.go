@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"server/internal/controllers"
+	"server/internal/middlewares"
+
+	"github.com/labstack/echo/v4"
+)
+
+func AddRatingRouter(movieRouter *echo.Group, usersRouter *echo.Group, ratingController *controllers.RatingController) {
+	movieRouter.PUT("/:id/rating", ratingController.RateMovie, middlewares.Authenticated, middlewares.AttachUser)
+	movieRouter.DELETE("/:id/rating", ratingController.DeleteRating, middlewares.Authenticated, middlewares.AttachUser)
+	movieRouter.GET("/:id/reviews", ratingController.GetMovieReviews)
+
+	usersRouter.GET("/:username/ratings", ratingController.GetUserRatings, middlewares.Authenticated, middlewares.AttachUser)
+}
@@ -22,3 +22,18 @@ func AddUserRouter(usersRouter *echo.Group) {
 
 	usersRouter.GET("/:username", controllers.GetUserByUsername, middlewares.Authenticated, middlewares.AttachUser)
 }
+
+func AddWatchHistoryRouter(usersRouter *echo.Group, watchHistoryController *controllers.WatchHistoryController) {
+	usersRouter.GET("/continue-watching", watchHistoryController.GetContinueWatching, middlewares.Authenticated, middlewares.AttachUser)
+	usersRouter.PUT("/watched/:movieId", watchHistoryController.MarkWatched, middlewares.Authenticated, middlewares.AttachUser)
+	usersRouter.DELETE("/watched/:movieId", watchHistoryController.MarkUnwatched, middlewares.Authenticated, middlewares.AttachUser)
+	usersRouter.DELETE("/watch-history", watchHistoryController.ClearHistory, middlewares.Authenticated, middlewares.AttachUser)
+	usersRouter.DELETE("/watch-history/:movieId", watchHistoryController.RemoveFromHistory, middlewares.Authenticated, middlewares.AttachUser)
+}
+
+func AddWatchlistRouter(usersRouter *echo.Group, watchlistController *controllers.WatchlistController) {
+	usersRouter.GET("/watchlist", watchlistController.GetWatchlist, middlewares.Authenticated, middlewares.AttachUser)
+	usersRouter.PUT("/watchlist", watchlistController.ReorderWatchlist, middlewares.Authenticated, middlewares.AttachUser)
+	usersRouter.PUT("/watchlist/:movieId", watchlistController.AddToWatchlist, middlewares.Authenticated, middlewares.AttachUser)
+	usersRouter.DELETE("/watchlist/:movieId", watchlistController.RemoveFromWatchlist, middlewares.Authenticated, middlewares.AttachUser)
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"server/internal/controllers"
+	"server/internal/middlewares"
+
+	"github.com/labstack/echo/v4"
+)
+
+func AddWatchPartyRouter(partyRouter *echo.Group, partyController *controllers.WatchPartyController) {
+	partyRouter.POST("", partyController.CreateParty, middlewares.Authenticated, middlewares.AttachUser)
+	partyRouter.GET("/:code", partyController.GetParty, middlewares.Authenticated, middlewares.AttachUser)
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"log"
 	"net/http"
 	"server/internal/controllers"
 	"server/internal/middlewares"
@@ -21,9 +22,22 @@ var (
 	movieService        *services.MovieService
 	torrentService      *services.TorrentService
 	websocketService    *services.WebSocketService
+	libraryService      *services.LibraryService
+	storageService      *services.StorageService
+	reconciler          *services.Reconciler
+	exportService       *services.ExportService
+	listService         *services.ListService
+	commentService      *services.CommentService
 	movieController     *controllers.MovieController
 	commentController   *controllers.CommentController
 	websocketController *controllers.WebSocketController
+	adminController     *controllers.AdminController
+	exportController    *controllers.ExportController
+	historyController   *controllers.WatchHistoryController
+	watchlistController *controllers.WatchlistController
+	listController      *controllers.ListController
+	ratingController    *controllers.RatingController
+	partyController     *controllers.WatchPartyController
 )
 
 func InitServices() {
@@ -60,7 +74,45 @@ func InitServices() {
 		websocketController,
 	)
 
-	commentController = controllers.NewCommentController(services.PostgresDB())
+	libraryService = services.NewLibraryService(
+		services.Conf.LIBRARY.Directories,
+		services.Conf.LIBRARY.ScanInterval,
+		services.PostgresDB(),
+		movieService,
+	)
+
+	storageService = services.NewStorageService(
+		services.PostgresDB(),
+		torrentService,
+		movieService,
+	)
+
+	reconciler = services.NewReconciler(
+		services.PostgresDB(),
+		torrentService,
+		movieService,
+	)
+
+	exportService, err = services.NewExportService(
+		services.PostgresDB(),
+		movieService,
+		torrentService,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	commentService = services.NewCommentService(services.PostgresDB())
+	commentController = controllers.NewCommentController(services.PostgresDB(), commentService, websocketService)
+	exportController = controllers.NewExportController(exportService)
+	historyController = controllers.NewWatchHistoryController(movieService)
+	watchlistController = controllers.NewWatchlistController(movieService)
+	listService = services.NewListService(services.PostgresDB(), movieService)
+	listController = controllers.NewListController(listService)
+	ratingController = controllers.NewRatingController(movieService)
+	partyController = controllers.NewWatchPartyController(services.NewWatchPartyService())
+
+	adminController = controllers.NewAdminController(torrentService, movieService, libraryService, storageService, reconciler, commentService)
 }
 
 func Init(config string) {
@@ -70,6 +122,8 @@ func Init(config string) {
 	services.LoadMailDialer()
 	services.LoadValidator()
 	services.LoadAWSBucket()
+	services.LoadAvatarStorage()
+	services.LoadMediaStorage()
 	services.LoadDatabase()
 	oauth2.LoadConfig()
 	services.LoadValidator()
@@ -114,12 +168,25 @@ func LoadServer() {
 	routes.AddAuthRouter(Server.Group("/auth"))
 	routes.AddOAuthRouter(Server.Group("/oauth2"))
 	routes.AddUserRouter(Server.Group("/users"))
+	routes.AddWatchHistoryRouter(Server.Group("/users"), historyController)
+	routes.AddWatchlistRouter(Server.Group("/users"), watchlistController)
+	Server.GET("/avatars/:hash/:file", controllers.ServeAvatar)
 	routes.AddMovieRouter(Server.Group("/movies"), movieController)
 	routes.AddCommentRouter(Server.Group("/comments"), commentController)
+	routes.AddMovieCommentRouter(Server.Group("/movies"), commentController)
+	routes.AddExportRouter(Server.Group("/exports"), exportController)
+	routes.AddListRouter(Server.Group("/lists"), listController)
+	routes.AddRatingRouter(Server.Group("/movies"), Server.Group("/users"), ratingController)
+	routes.AddWatchPartyRouter(Server.Group("/parties"), partyController)
+	routes.AddAdminRouter(Server.Group("/admin", middlewares.Authenticated, middlewares.AttachUser, middlewares.Admin), adminController)
 
 	streamGroup := Server.Group("/stream", middlewares.Authenticated, middlewares.AttachUser)
+	streamGroup.GET("/:movieId/direct", movieController.ServeDirectPlay)
+	streamGroup.GET("/:movieId/sources/:sourceId/direct", movieController.ServeDirectPlay)
 	streamGroup.GET("/*", movieController.ServeHLSFile)
-	Server.GET("/ws/:movieId", websocketController.HandleWebSocket)
+	Server.GET("/ws", websocketController.HandleSocket, middlewares.Authenticated, middlewares.AttachUser)
+	Server.GET("/ws/:movieId", websocketController.HandleWebSocket, middlewares.Authenticated, middlewares.AttachUser)
+	Server.GET("/ws/party/:code", partyController.HandlePartySocket, middlewares.Authenticated, middlewares.AttachUser)
 }
 
 func setupSwagger(s *echo.Echo) {
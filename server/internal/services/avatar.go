@@ -0,0 +1,343 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"server/internal/models"
+	"strconv"
+)
+
+const (
+	defaultAvatarMaxSize = 5 << 20
+
+	// Decoding is refused above maxAvatarDimension so that a small file
+	// cannot expand into a huge bitmap.
+	minAvatarDimension = 32
+	maxAvatarDimension = 4096
+
+	avatarJPEGQuality = 90
+)
+
+// avatarSizes are the square sizes an avatar is stored in, the first one
+// being the avatar of the user.
+var avatarSizes = []int{256, 512, 64}
+
+var ErrInvalidAvatar = errors.New("picture must be a JPEG, PNG or GIF image")
+
+// Avatar is an uploaded profile picture.
+type Avatar struct {
+	URL      string            `json:"url" example:"/api/avatars/9f86d081884c7d65/256.jpg"`
+	Variants map[string]string `json:"variants"` // size -> URL
+}
+
+// UploadPicture validates an uploaded profile picture and stores it, without
+// its metadata, in every avatar size. Avatars are stored under the hash of
+// the upload, so the same picture is only stored once.
+func UploadPicture(pic *multipart.FileHeader) (Avatar, error) {
+	store := AvatarStore()
+	if store == nil {
+		return Avatar{}, fmt.Errorf("avatar storage is not configured")
+	}
+
+	maxSize := Conf.AVATARS.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultAvatarMaxSize
+	}
+	if pic.Size > maxSize {
+		return Avatar{}, fmt.Errorf("picture is larger than %d bytes", maxSize)
+	}
+
+	file, err := pic.Open()
+	if err != nil {
+		return Avatar{}, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return Avatar{}, err
+	}
+	if int64(len(data)) > maxSize {
+		return Avatar{}, fmt.Errorf("picture is larger than %d bytes", maxSize)
+	}
+
+	variants, ext, contentType, err := processAvatar(data)
+	if err != nil {
+		return Avatar{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	avatar := Avatar{Variants: make(map[string]string, len(variants))}
+	for i, size := range avatarSizes {
+		key := path.Join(hash, strconv.Itoa(size)+ext)
+		if err := store.Put(context.Background(), key, variants[size], contentType); err != nil {
+			return Avatar{}, fmt.Errorf("failed to store picture: %w", err)
+		}
+		avatar.Variants[strconv.Itoa(size)] = store.URL(key)
+		if i == 0 {
+			avatar.URL = store.URL(key)
+		}
+	}
+
+	return avatar, nil
+}
+
+// ReleaseAvatar removes a stored avatar once no user uses it anymore, nor any
+// comment, which keeps the avatar its author had when posting it.
+func ReleaseAvatar(url string) {
+	store := AvatarStore()
+	if store == nil || url == "" {
+		return
+	}
+	key, ok := store.Key(url)
+	if !ok {
+		return
+	}
+	hash, ext := path.Dir(key), path.Ext(key)
+
+	pattern := "%/" + hash + "/%"
+	for _, model := range []interface{}{&models.User{}, &models.Comment{}} {
+		var count int64
+		if err := PostgresDB().Model(model).Where("avatar LIKE ?", pattern).Count(&count).Error; err != nil || count > 0 {
+			return
+		}
+	}
+
+	for _, size := range avatarSizes {
+		if err := store.Delete(context.Background(), path.Join(hash, strconv.Itoa(size)+ext)); err != nil {
+			Logger.Error(fmt.Sprintf("Failed to delete avatar %s: %v", hash, err))
+		}
+	}
+}
+
+// processAvatar decodes an uploaded picture and encodes its square center in
+// every avatar size. Re-encoding drops the metadata of the upload.
+func processAvatar(data []byte) (map[int][]byte, string, string, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, "", "", ErrInvalidAvatar
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", "", ErrInvalidAvatar
+	}
+	if config.Width < minAvatarDimension || config.Height < minAvatarDimension {
+		return nil, "", "", fmt.Errorf("picture must be at least %dx%d pixels", minAvatarDimension, minAvatarDimension)
+	}
+	if config.Width > maxAvatarDimension || config.Height > maxAvatarDimension {
+		return nil, "", "", fmt.Errorf("picture must be at most %dx%d pixels", maxAvatarDimension, maxAvatarDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", "", ErrInvalidAvatar
+	}
+
+	// Only JPEG keeps the orientation in its metadata.
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	square := cropSquare(img)
+
+	variants := make(map[int][]byte, len(avatarSizes))
+	for _, size := range avatarSizes {
+		resized := downscale(square, size)
+
+		var buf bytes.Buffer
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: avatarJPEGQuality})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+		variants[size] = buf.Bytes()
+	}
+
+	if contentType == "image/jpeg" {
+		return variants, ".jpg", "image/jpeg", nil
+	}
+	return variants, ".png", "image/png", nil
+}
+
+// cropSquare returns the largest centered square of an image.
+func cropSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, origin, draw.Src)
+	return square
+}
+
+// downscale averages a square image down to size pixels. Smaller images are
+// kept as they are.
+func downscale(src *image.RGBA, size int) *image.RGBA {
+	side := src.Bounds().Dx()
+	if size >= side {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					i := sx * 4
+					r += uint64(row[i])
+					g += uint64(row[i+1])
+					b += uint64(row[i+2])
+					a += uint64(row[i+3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// orient applies an EXIF orientation to an image, so that it displays the
+// right way up once the metadata is gone.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // flipped
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG file, 1 when it has
+// none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// The metadata comes before the start of the image data.
+		if marker == 0xD9 || marker == 0xDA {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		if marker == 0xE1 {
+			if orientation := exifOrientation(data[i+4 : i+2+length]); orientation != 0 {
+				return orientation
+			}
+		}
+		i += 2 + length
+	}
+
+	return 1
+}
+
+// exifOrientation reads the orientation tag of an APP1 segment, 0 when it
+// has none.
+func exifOrientation(segment []byte) int {
+	if len(segment) < 14 || string(segment[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := segment[6:]
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < entries; k++ {
+		entry := ifd + 2 + k*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// AvatarStorage stores profile pictures under keys and tells where they are
+// publicly served from.
+type AvatarStorage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL of the avatar stored under key.
+	URL(key string) string
+	// Key returns the key of an avatar from its public URL, or false when
+	// the URL does not point to this storage.
+	Key(url string) (string, bool)
+}
+
+var avatarStorage AvatarStorage
+
+func LoadAvatarStorage() {
+	conf := Conf.AVATARS
+
+	if conf.Backend == "s3" {
+		if AWS_Client() != nil {
+			avatarStorage = NewS3AvatarStorage(AWS_Client())
+			return
+		}
+		Logger.Warn("S3 avatar storage is not available, storing avatars locally")
+	} else if conf.Backend != "" && conf.Backend != "local" {
+		log.Fatalf("unknown avatar storage backend %q", conf.Backend)
+	}
+
+	dir := conf.Directory
+	if dir == "" {
+		dir = "avatars"
+	}
+	store, err := NewLocalAvatarStorage(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	avatarStorage = store
+}
+
+func AvatarStore() AvatarStorage {
+	return avatarStorage
+}
+
+func avatarPublicURL(base, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+func avatarKeyFromURL(base, url string) (string, bool) {
+	prefix := strings.TrimSuffix(base, "/") + "/"
+	if !strings.HasPrefix(url, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(url, prefix), true
+}
+
+// LocalAvatarStorage stores avatars in a directory served by the API.
+type LocalAvatarStorage struct {
+	dir       string
+	publicURL string
+}
+
+func NewLocalAvatarStorage(dir string) (*LocalAvatarStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create avatar directory: %w", err)
+	}
+
+	publicURL := Conf.AVATARS.PublicURL
+	if publicURL == "" {
+		publicURL = "/api/avatars"
+	}
+
+	return &LocalAvatarStorage{dir: dir, publicURL: publicURL}, nil
+}
+
+// Path returns the file of the avatar stored under key.
+func (s *LocalAvatarStorage) Path(key string) (string, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !withinDir(s.dir, p) {
+		return "", fmt.Errorf("invalid avatar key %q", key)
+	}
+	return p, nil
+}
+
+func (s *LocalAvatarStorage) Put(_ context.Context, key string, data []byte, _ string) error {
+	p, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	tmp := p + ".part"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *LocalAvatarStorage) Delete(_ context.Context, key string) error {
+	p, err := s.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Remove the directory of the avatar once its last variant is gone.
+	removeIfEmpty(filepath.Dir(p))
+	return nil
+}
+
+func (s *LocalAvatarStorage) URL(key string) string {
+	return avatarPublicURL(s.publicURL, key)
+}
+
+func (s *LocalAvatarStorage) Key(url string) (string, bool) {
+	return avatarKeyFromURL(s.publicURL, url)
+}
+
+// S3AvatarStorage stores avatars in a public S3-compatible bucket.
+type S3AvatarStorage struct {
+	client    *s3.Client
+	bucket    string
+	publicURL string
+}
+
+func NewS3AvatarStorage(client *s3.Client) *S3AvatarStorage {
+	conf := Conf.AVATARS
+
+	bucket := conf.S3.Bucket
+	if bucket == "" {
+		bucket = AWSBucketName
+	}
+	region := conf.S3.Region
+	if region == "" {
+		region = AWSBucketRegion
+	}
+
+	publicURL := conf.PublicURL
+	switch {
+	case publicURL != "" && !strings.HasPrefix(publicURL, "/"):
+	case conf.S3.Endpoint != "":
+		publicURL = strings.TrimSuffix(conf.S3.Endpoint, "/") + "/" + bucket
+	default:
+		publicURL = "https://" + bucket + ".s3." + region + ".amazonaws.com"
+	}
+
+	return &S3AvatarStorage{client: client, bucket: bucket, publicURL: publicURL}
+}
+
+func (s *S3AvatarStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+		// Keys are content-addressed, an avatar never changes.
+		CacheControl: aws.String("public, max-age=31536000, immutable"),
+	})
+	return err
+}
+
+func (s *S3AvatarStorage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3AvatarStorage) URL(key string) string {
+	return avatarPublicURL(s.publicURL, key)
+}
+
+func (s *S3AvatarStorage) Key(url string) (string, bool) {
+	return avatarKeyFromURL(s.publicURL, url)
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// exifSegment builds an APP1 segment holding an orientation tag, preceded by
+// another tag, in the given byte order.
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+2*12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 2)
+
+	// ImageWidth, then Orientation, a SHORT stored in the value field.
+	order.PutUint16(tiff[10:], 0x0100)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], 640)
+	order.PutUint16(tiff[22:], 0x0112)
+	order.PutUint16(tiff[24:], 3)
+	order.PutUint32(tiff[26:], 1)
+	order.PutUint16(tiff[30:], orientation)
+
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// jpegWith builds the start of a JPEG file from segments given as a marker
+// and a payload.
+func jpegWith(segments ...[]byte) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, segment := range segments {
+		data = append(data, 0xFF, segment[0])
+		data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+1))
+		data = append(data, segment[1:]...)
+	}
+	return append(data, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func app1(payload []byte) []byte {
+	return append([]byte{0xE1}, payload...)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		for orientation := uint16(1); orientation <= 8; orientation++ {
+			if got := jpegOrientation(jpegWith(app1(exifSegment(order, orientation)))); got != int(orientation) {
+				t.Errorf("%v orientation %d read as %d", order, orientation, got)
+			}
+		}
+	}
+
+	valid := exifSegment(binary.BigEndian, 6)
+	badOrder := append([]byte{}, valid...)
+	copy(badOrder[6:], "XX")
+	farIFD := append([]byte{}, valid...)
+	binary.BigEndian.PutUint32(farIFD[10:], 4096)
+	lowIFD := append([]byte{}, valid...)
+	binary.BigEndian.PutUint32(lowIFD[10:], 2)
+	manyEntries := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(manyEntries[14:], 0xFFFF)
+	truncated := jpegWith(app1(valid))
+	binary.BigEndian.PutUint16(truncated[4:], 0xFFF0)
+	shortLength := jpegWith(app1(valid))
+	binary.BigEndian.PutUint16(shortLength[4:], 1)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{name: "empty", want: 1},
+		{name: "not a JPEG", data: []byte("\x89PNG\r\n\x1a\n"), want: 1},
+		{name: "no metadata", data: jpegWith([]byte{0xE0, 'J', 'F', 'I', 'F', 0}), want: 1},
+		{name: "after JFIF", data: jpegWith([]byte{0xE0, 'J', 'F', 'I', 'F', 0}, app1(valid)), want: 6},
+		{name: "after XMP", data: jpegWith(app1([]byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")), app1(valid)), want: 6},
+		{name: "after the image data", data: append(jpegWith(), jpegWith(app1(valid))[2:]...), want: 1},
+		{name: "segment past the end", data: truncated, want: 1},
+		{name: "segment length under 2", data: shortLength, want: 1},
+		{name: "cut inside a marker", data: jpegWith(app1(valid))[:3], want: 1},
+		{name: "no marker", data: []byte{0xFF, 0xD8, 0x00, 0xE1, 0x00, 0x02}, want: 1},
+		{name: "short APP1", data: jpegWith(app1([]byte("Exif\x00\x00MM"))), want: 1},
+		{name: "not Exif", data: jpegWith(app1(append([]byte("Exf\x00\x00\x00"), valid[6:]...))), want: 1},
+		{name: "unknown byte order", data: jpegWith(app1(badOrder)), want: 1},
+		{name: "IFD past the end", data: jpegWith(app1(farIFD)), want: 1},
+		{name: "IFD inside the header", data: jpegWith(app1(lowIFD)), want: 1},
+		{name: "entries past the end", data: jpegWith(app1(manyEntries)), want: 6},
+		{name: "entries cut before orientation", data: jpegWith(app1(valid[:6+8+2+12+6])), want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := jpegOrientation(tt.data); got != tt.want {
+			t.Errorf("%s: jpegOrientation = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	green := color.RGBA{0, 255, 0, 255}
+
+	// A 3x2 picture with its top-left pixel red and the next one green.
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, red)
+	src.Set(1, 0, green)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		red, green  image.Point
+	}{
+		{orientation: 0, w: 3, h: 2, red: image.Pt(0, 0), green: image.Pt(1, 0)},
+		{orientation: 1, w: 3, h: 2, red: image.Pt(0, 0), green: image.Pt(1, 0)},
+		{orientation: 2, w: 3, h: 2, red: image.Pt(2, 0), green: image.Pt(1, 0)},
+		{orientation: 3, w: 3, h: 2, red: image.Pt(2, 1), green: image.Pt(1, 1)},
+		{orientation: 4, w: 3, h: 2, red: image.Pt(0, 1), green: image.Pt(1, 1)},
+		{orientation: 5, w: 2, h: 3, red: image.Pt(0, 0), green: image.Pt(0, 1)},
+		{orientation: 6, w: 2, h: 3, red: image.Pt(1, 0), green: image.Pt(1, 1)},
+		{orientation: 7, w: 2, h: 3, red: image.Pt(1, 2), green: image.Pt(1, 1)},
+		{orientation: 8, w: 2, h: 3, red: image.Pt(0, 2), green: image.Pt(0, 1)},
+		{orientation: 9, w: 3, h: 2, red: image.Pt(0, 0), green: image.Pt(1, 0)},
+	}
+
+	for _, tt := range tests {
+		got := orient(src, tt.orientation)
+		if b := got.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.red.X, tt.red.Y)); c != red {
+			t.Errorf("orientation %d: pixel at %v is %v, want red", tt.orientation, tt.red, c)
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.green.X, tt.green.Y)); c != green {
+			t.Errorf("orientation %d: pixel at %v is %v, want green", tt.orientation, tt.green, c)
+		}
+	}
+}
+
+func TestCropAndDownscale(t *testing.T) {
+	tests := []struct {
+		w, h   int
+		side   int
+		scaled map[int]int // avatar size -> side
+	}{
+		{w: 300, h: 300, side: 300, scaled: map[int]int{256: 256, 512: 300, 64: 64}},
+		{w: 400, h: 300, side: 300, scaled: map[int]int{256: 256, 512: 300, 64: 64}},
+		{w: 120, h: 900, side: 120, scaled: map[int]int{256: 120, 512: 120, 64: 64}},
+		{w: 32, h: 33, side: 32, scaled: map[int]int{256: 32, 64: 32}},
+		{w: 4096, h: 4096, side: 4096, scaled: map[int]int{256: 256, 512: 512, 64: 64}},
+	}
+
+	for _, tt := range tests {
+		square := cropSquare(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)))
+		if b := square.Bounds(); b.Dx() != tt.side || b.Dy() != tt.side {
+			t.Errorf("cropSquare(%dx%d) = %dx%d, want %d", tt.w, tt.h, b.Dx(), b.Dy(), tt.side)
+			continue
+		}
+		for size, want := range tt.scaled {
+			if b := downscale(square, size).Bounds(); b.Dx() != want || b.Dy() != want {
+				t.Errorf("downscale(%d, %d) = %dx%d, want %d", tt.side, size, b.Dx(), b.Dy(), want)
+			}
+		}
+	}
+
+	// The crop is centered.
+	wide := image.NewRGBA(image.Rect(0, 0, 5, 3))
+	wide.Set(1, 0, color.RGBA{255, 0, 0, 255})
+	if c := cropSquare(wide).RGBAAt(0, 0); c != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("cropSquare kept %v at its origin, want the red pixel", c)
+	}
+
+	// Pixels are averaged: a black and white picture turns gray.
+	stripes := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if x%2 == 0 {
+				stripes.Set(x, y, color.White)
+			} else {
+				stripes.Set(x, y, color.Black)
+			}
+		}
+	}
+	if c := downscale(stripes, 2).RGBAAt(1, 1); c != (color.RGBA{127, 127, 127, 255}) {
+		t.Errorf("downscaled stripes to %v, want gray", c)
+	}
+}
+
+func TestProcessAvatar(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 600, 400))); err != nil {
+		t.Fatal(err)
+	}
+
+	variants, ext, contentType, err := processAvatar(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext != ".png" || contentType != "image/png" {
+		t.Errorf("processAvatar stored %s as %s", ext, contentType)
+	}
+	for size, want := range map[int]int{256: 256, 512: 400, 64: 64} {
+		config, err := png.DecodeConfig(bytes.NewReader(variants[size]))
+		if err != nil {
+			t.Fatalf("variant %d: %v", size, err)
+		}
+		if config.Width != want || config.Height != want {
+			t.Errorf("variant %d is %dx%d, want %d", size, config.Width, config.Height, want)
+		}
+	}
+
+	for _, data := range [][]byte{nil, []byte("not a picture"), jpegWith(app1(exifSegment(binary.BigEndian, 6)))} {
+		if _, _, _, err := processAvatar(data); err == nil {
+			t.Errorf("processAvatar(%q) returned no error", data)
+		}
+	}
+}
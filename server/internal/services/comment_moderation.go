@@ -0,0 +1,279 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"server/internal/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	CommentVisible = "visible"
+	CommentHidden  = "hidden"
+	CommentRemoved = "removed"
+
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+
+	defaultCommentRateWindow = time.Minute
+	maxReportDetails         = 1000
+	reportQueuePageSize      = 20
+)
+
+// ReportReasons are the reasons users can report a comment for.
+var ReportReasons = []string{"spam", "harassment", "hate", "spoiler", "inappropriate", "other"}
+
+// ModerationActions are what an administrator can do with a comment.
+var ModerationActions = []string{"hide", "remove", "restore", "dismiss"}
+
+var (
+	ErrCommentRateLimited  = errors.New("you are commenting too fast, try again later")
+	ErrCommentRejected     = errors.New("the comment contains words that are not allowed")
+	ErrCommentModerated    = errors.New("moderated comments cannot be edited")
+	ErrInvalidReport       = errors.New("invalid report")
+	ErrInvalidModeration   = errors.New("invalid moderation action")
+	ErrCannotReportOwnPost = errors.New("you cannot report your own comment")
+)
+
+// ReportRequest is a report of a comment by a user.
+type ReportRequest struct {
+	Reason  string `json:"reason" example:"spoiler" enums:"spam,harassment,hate,spoiler,inappropriate,other"`
+	Details string `json:"details,omitempty" example:"Reveals the ending"`
+}
+
+// ReportedComment is a comment in the moderation queue with its reports.
+type ReportedComment struct {
+	Comment models.Comment
+	Reports []models.CommentReport
+}
+
+// Report records a report of a comment by a user. Reporting a comment again
+// updates the report.
+func (cs *CommentService) Report(commentID, userID uint, req ReportRequest) error {
+	req.Details = strings.TrimSpace(req.Details)
+	if !slices.Contains(ReportReasons, req.Reason) || len(req.Details) > maxReportDetails {
+		return ErrInvalidReport
+	}
+
+	var comment models.Comment
+	if err := cs.db.Where("status <> ?", CommentHidden).First(&comment, commentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCommentNotFound
+		}
+		return err
+	}
+	if uint(comment.UserID) == userID {
+		return ErrCannotReportOwnPost
+	}
+
+	report := models.CommentReport{
+		CommentID:  commentID,
+		ReporterID: userID,
+		Reason:     req.Reason,
+		Details:    req.Details,
+		Status:     ReportOpen,
+	}
+	return cs.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "comment_id"}, {Name: "reporter_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"reason": req.Reason, "details": req.Details, "status": ReportOpen}),
+	}).Create(&report).Error
+}
+
+// ReportQueue returns the reported comments whose reports have a status,
+// the most reported first.
+func (cs *CommentService) ReportQueue(status string, page int) ([]ReportedComment, error) {
+	if status == "" {
+		status = ReportOpen
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var rows []struct {
+		CommentID uint
+	}
+	err := cs.db.Model(&models.CommentReport{}).
+		Select("comment_id").
+		Where("status = ?", status).
+		Group("comment_id").
+		Order("COUNT(*) DESC, MIN(created_at) ASC").
+		Limit(reportQueuePageSize).
+		Offset((page - 1) * reportQueuePageSize).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	queue := []ReportedComment{}
+	if len(rows) == 0 {
+		return queue, nil
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.CommentID
+	}
+
+	var comments []models.Comment
+	if err := cs.db.Unscoped().Where("id IN ?", ids).Find(&comments).Error; err != nil {
+		return nil, err
+	}
+	var reports []models.CommentReport
+	if err := cs.db.Where("comment_id IN ? AND status = ?", ids, status).Order("created_at ASC").Find(&reports).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]models.Comment, len(comments))
+	for _, comment := range comments {
+		byID[comment.ID] = comment
+	}
+	for _, id := range ids {
+		comment, ok := byID[id]
+		if !ok {
+			continue
+		}
+		item := ReportedComment{Comment: comment}
+		for _, report := range reports {
+			if report.CommentID == id {
+				item.Reports = append(item.Reports, report)
+			}
+		}
+		queue = append(queue, item)
+	}
+
+	return queue, nil
+}
+
+// Moderate applies the decision of an administrator to a comment: hide or
+// remove it, restore it, or dismiss its reports. Its open reports are closed.
+func (cs *CommentService) Moderate(commentID, adminID uint, action string) (models.Comment, error) {
+	if !slices.Contains(ModerationActions, action) {
+		return models.Comment{}, ErrInvalidModeration
+	}
+
+	var comment models.Comment
+	if err := cs.db.First(&comment, commentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Comment{}, ErrCommentNotFound
+		}
+		return models.Comment{}, err
+	}
+
+	now := time.Now()
+	reportStatus := ReportResolved
+	status := comment.Status
+	switch action {
+	case "hide":
+		status = CommentHidden
+	case "remove":
+		status = CommentRemoved
+	case "restore":
+		status = CommentVisible
+		reportStatus = ReportDismissed
+	case "dismiss":
+		reportStatus = ReportDismissed
+	}
+
+	err := cs.db.Transaction(func(tx *gorm.DB) error {
+		if status != comment.Status {
+			if err := tx.Model(&comment).Updates(map[string]interface{}{
+				"status":       status,
+				"moderated_by": adminID,
+				"moderated_at": now,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.CommentReport{}).
+			Where("comment_id = ? AND status = ?", commentID, ReportOpen).
+			Updates(map[string]interface{}{
+				"status":      reportStatus,
+				"resolved_by": adminID,
+				"resolved_at": now,
+			}).Error
+	})
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	return comment, nil
+}
+
+// filterWords masks the banned words of a comment, or rejects it when the
+// filter is configured to.
+func (cs *CommentService) filterWords(content string) (string, error) {
+	if cs.wordFilter == nil || !cs.wordFilter.MatchString(content) {
+		return content, nil
+	}
+	if cs.rejectWords {
+		return "", ErrCommentRejected
+	}
+	return cs.wordFilter.ReplaceAllStringFunc(content, func(word string) string {
+		return strings.Repeat("*", len([]rune(word)))
+	}), nil
+}
+
+// allowComment reports whether a user may post a comment now, and counts it
+// if so.
+func (cs *CommentService) allowComment(userID uint) bool {
+	if cs.rateLimit <= 0 {
+		return true
+	}
+
+	cs.rateMu.Lock()
+	defer cs.rateMu.Unlock()
+
+	now := time.Now()
+	recent := cs.recent[userID][:0]
+	for _, at := range cs.recent[userID] {
+		if now.Sub(at) < cs.rateWindow {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= cs.rateLimit {
+		cs.recent[userID] = recent
+		return false
+	}
+	cs.recent[userID] = append(recent, now)
+
+	// Forget users who stopped commenting.
+	if len(cs.recent) > 1024 {
+		for id, times := range cs.recent {
+			if len(times) == 0 || now.Sub(times[len(times)-1]) >= cs.rateWindow {
+				delete(cs.recent, id)
+			}
+		}
+	}
+	return true
+}
+
+// bannedWordsRegexp matches whole banned words regardless of case, nil when
+// there are none. Word boundaries are only required next to letters and
+// digits, so that words like "c++" match too.
+func bannedWordsRegexp(words []string) *regexp.Regexp {
+	wordChar := regexp.MustCompile(`^\w`)
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word == "" {
+			continue
+		}
+		pattern := regexp.QuoteMeta(word)
+		if wordChar.MatchString(word) {
+			pattern = `\b` + pattern
+		}
+		if r := []rune(word); wordChar.MatchString(string(r[len(r)-1])) {
+			pattern += `\b`
+		}
+		quoted = append(quoted, pattern)
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(quoted, "|") + `)`)
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFilterWords(t *testing.T) {
+	filter := bannedWordsRegexp([]string{"spoiler", " rosebud ", "", "c++"})
+
+	tests := []struct {
+		content string
+		reject  bool
+		want    string
+		err     error
+	}{
+		{content: "Great movie", want: "Great movie"},
+		{content: "Rosebud was the sled", want: "******* was the sled"},
+		{content: "SPOILER: spoiler alert", want: "*******: ******* alert"},
+		{content: "No spoilers here", want: "No spoilers here"},
+		{content: "Written in c++ maybe", want: "Written in *** maybe"},
+		{content: "Great movie", reject: true, want: "Great movie"},
+		{content: "Rosebud was the sled", reject: true, err: ErrCommentRejected},
+	}
+
+	for _, tt := range tests {
+		cs := &CommentService{wordFilter: filter, rejectWords: tt.reject}
+		got, err := cs.filterWords(tt.content)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("filterWords(%q, reject %v) = %q, %v, want %q, %v", tt.content, tt.reject, got, err, tt.want, tt.err)
+		}
+	}
+
+	if bannedWordsRegexp([]string{" ", ""}) != nil {
+		t.Error("bannedWordsRegexp of blank words is not nil")
+	}
+}
+
+func TestAllowComment(t *testing.T) {
+	cs := &CommentService{rateLimit: 2, rateWindow: time.Minute, recent: make(map[uint][]time.Time)}
+
+	for i, want := range []bool{true, true, false, false} {
+		if got := cs.allowComment(1); got != want {
+			t.Errorf("comment %d allowed = %v, want %v", i+1, got, want)
+		}
+	}
+	if !cs.allowComment(2) {
+		t.Error("another user was rate limited")
+	}
+
+	// Comments older than the window no longer count.
+	cs.recent[1] = []time.Time{time.Now().Add(-2 * time.Minute), time.Now().Add(-time.Second)}
+	if !cs.allowComment(1) {
+		t.Error("comment allowed = false once the window passed")
+	}
+
+	unlimited := &CommentService{}
+	if !unlimited.allowComment(1) {
+		t.Error("comment allowed = false without a rate limit")
+	}
+}
@@ -0,0 +1,422 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"server/internal/models"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MaxCommentDepth is the deepest a reply can be nested, comments being
+	// at depth 0.
+	MaxCommentDepth = 3
+
+	defaultCommentPageSize = 20
+	maxCommentPageSize     = 100
+
+	// maxCommentTimestamp bounds the moment a comment is anchored to.
+	maxCommentTimestamp = 24 * 60 * 60
+	// defaultTimedWindow is the window of timed comments returned when the
+	// end of the window is not given, in seconds.
+	defaultTimedWindow = 5 * 60
+	maxTimedComments   = 500
+)
+
+// CommentReactions are the reactions users can leave on a comment.
+var CommentReactions = []string{"like", "dislike", "love", "laugh", "wow", "sad"}
+
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrInvalidComment  = errors.New("invalid comment")
+	ErrInvalidCursor   = errors.New("invalid cursor")
+	ErrInvalidReaction = errors.New("invalid reaction")
+	ErrReplyTooDeep    = fmt.Errorf("replies cannot be nested more than %d levels deep", MaxCommentDepth)
+	ErrInvalidReply    = errors.New("a reply must be on the same movie as its parent")
+)
+
+// CommentQuery selects a page of comments. Replies to a comment are listed
+// oldest first, other comments newest first.
+type CommentQuery struct {
+	MovieID  int   // 0 for every movie
+	ParentID *uint // replies to this comment; otherwise top-level comments of MovieID, or every comment
+	Cursor   string
+	Limit    int
+}
+
+// CommentView is a comment with its reactions, as seen by a user.
+type CommentView struct {
+	models.Comment
+	ReplyCount int64
+	Reactions  map[string]int64
+	MyReaction string
+}
+
+// CommentPage is a page of comments. NextCursor is empty on the last page.
+type CommentPage struct {
+	Comments   []CommentView
+	NextCursor string
+}
+
+type CommentService struct {
+	db *gorm.DB
+
+	// wordFilter matches the banned words, nil when there are none.
+	wordFilter  *regexp.Regexp
+	rejectWords bool
+
+	rateLimit  int
+	rateWindow time.Duration
+	rateMu     sync.Mutex
+	recent     map[uint][]time.Time // user -> times of their recent comments
+}
+
+func NewCommentService(db *gorm.DB) *CommentService {
+	cs := &CommentService{
+		db:          db,
+		rejectWords: Conf.COMMENTS.FilterMode == "reject",
+		rateLimit:   Conf.COMMENTS.RateLimit,
+		rateWindow:  Conf.COMMENTS.RateWindow,
+		recent:      make(map[uint][]time.Time),
+	}
+	if cs.rateWindow <= 0 {
+		cs.rateWindow = defaultCommentRateWindow
+	}
+	cs.wordFilter = bannedWordsRegexp(Conf.COMMENTS.BannedWords)
+
+	return cs
+}
+
+// Create adds a comment, or a reply when it has a parent.
+func (cs *CommentService) Create(comment *models.Comment) error {
+	comment.Content = strings.TrimSpace(comment.Content)
+	if comment.Content == "" || comment.MovieID <= 0 {
+		return ErrInvalidComment
+	}
+	// Only comments are anchored to a moment, replies follow them.
+	if ts := comment.Timestamp; ts != nil && (*ts < 0 || *ts > maxCommentTimestamp || comment.ParentID != nil) {
+		return ErrInvalidComment
+	}
+	content, err := cs.filterWords(comment.Content)
+	if err != nil {
+		return err
+	}
+	comment.Content = content
+
+	comment.Depth = 0
+	comment.Status = CommentVisible
+	if comment.ParentID != nil {
+		var parent models.Comment
+		if err := cs.db.Where("status <> ?", CommentHidden).First(&parent, *comment.ParentID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCommentNotFound
+			}
+			return err
+		}
+		if parent.MovieID != comment.MovieID {
+			return ErrInvalidReply
+		}
+		if parent.Depth >= MaxCommentDepth {
+			return ErrReplyTooDeep
+		}
+		comment.Depth = parent.Depth + 1
+	}
+
+	if !cs.allowComment(uint(comment.UserID)) {
+		return ErrCommentRateLimited
+	}
+	return cs.db.Create(comment).Error
+}
+
+// Get returns a comment as seen by a user, nil when anonymous.
+func (cs *CommentService) Get(commentID uint, userID *uint) (CommentView, error) {
+	var comment models.Comment
+	if err := cs.db.Where("status <> ?", CommentHidden).First(&comment, commentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return CommentView{}, ErrCommentNotFound
+		}
+		return CommentView{}, err
+	}
+
+	views, err := cs.views([]models.Comment{comment}, userID)
+	if err != nil {
+		return CommentView{}, err
+	}
+	return views[0], nil
+}
+
+// List returns a page of comments as seen by a user, nil when anonymous.
+func (cs *CommentService) List(q CommentQuery, userID *uint) (CommentPage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultCommentPageSize
+	}
+	limit = min(limit, maxCommentPageSize)
+
+	query := cs.db.Model(&models.Comment{}).Where("status <> ?", CommentHidden)
+	oldestFirst := false
+	switch {
+	case q.ParentID != nil:
+		query = query.Where("parent_id = ?", *q.ParentID)
+		oldestFirst = true
+	case q.MovieID > 0:
+		query = query.Where("movie_id = ? AND parent_id IS NULL", q.MovieID)
+	}
+
+	if q.Cursor != "" {
+		at, id, err := decodeCommentCursor(q.Cursor)
+		if err != nil {
+			return CommentPage{}, err
+		}
+		if oldestFirst {
+			query = query.Where("(created_at, id) > (?, ?)", at, id)
+		} else {
+			query = query.Where("(created_at, id) < (?, ?)", at, id)
+		}
+	}
+	if oldestFirst {
+		query = query.Order("created_at ASC, id ASC")
+	} else {
+		query = query.Order("created_at DESC, id DESC")
+	}
+
+	var comments []models.Comment
+	if err := query.Limit(limit + 1).Find(&comments).Error; err != nil {
+		return CommentPage{}, err
+	}
+
+	page := CommentPage{}
+	if len(comments) > limit {
+		comments = comments[:limit]
+		last := comments[limit-1]
+		page.NextCursor = encodeCommentCursor(last.CreatedAt, last.ID)
+	}
+
+	views, err := cs.views(comments, userID)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	page.Comments = views
+	return page, nil
+}
+
+// Timed returns the visible comments of a movie anchored between two moments,
+// in seconds, in the order they appear. A negative end defaults to a few
+// minutes after the start.
+func (cs *CommentService) Timed(movieID, from, to int, userID *uint) ([]CommentView, error) {
+	from = max(from, 0)
+	if to < 0 {
+		to = from + defaultTimedWindow
+	}
+	if to < from {
+		return nil, ErrInvalidComment
+	}
+
+	var comments []models.Comment
+	err := cs.db.Where("movie_id = ? AND timestamp BETWEEN ? AND ? AND status = ?", movieID, from, to, CommentVisible).
+		Order("timestamp ASC, created_at ASC").
+		Limit(maxTimedComments).
+		Find(&comments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return cs.views(comments, userID)
+}
+
+// Edit changes the content of a comment and records when it was edited.
+// Moderated comments cannot be edited.
+func (cs *CommentService) Edit(comment *models.Comment, content string) error {
+	if comment.Status != CommentVisible {
+		return ErrCommentModerated
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return ErrInvalidComment
+	}
+	content, err := cs.filterWords(content)
+	if err != nil {
+		return err
+	}
+	if content == comment.Content {
+		return nil
+	}
+
+	now := time.Now()
+	if err := cs.db.Model(comment).Updates(map[string]interface{}{
+		"content":   content,
+		"edited_at": now,
+	}).Error; err != nil {
+		return err
+	}
+	comment.Content = content
+	comment.EditedAt = &now
+	return nil
+}
+
+// Delete deletes a comment of its author with its reactions. A comment with
+// replies is removed instead, like moderation does, so that the replies of
+// others stay; it goes once its last reply is deleted.
+func (cs *CommentService) Delete(comment models.Comment) error {
+	return cs.db.Transaction(func(tx *gorm.DB) error {
+		for {
+			var replies int64
+			if err := tx.Model(&models.Comment{}).Where("parent_id = ?", comment.ID).Count(&replies).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("comment_id = ?", comment.ID).Delete(&models.CommentReaction{}).Error; err != nil {
+				return err
+			}
+			if replies > 0 {
+				return tx.Model(&comment).Updates(map[string]interface{}{
+					"status":  CommentRemoved,
+					"content": "",
+				}).Error
+			}
+			if err := tx.Delete(&comment).Error; err != nil {
+				return err
+			}
+
+			// A parent its author deleted is kept only for its replies.
+			if comment.ParentID == nil {
+				return nil
+			}
+			var parent models.Comment
+			err := tx.Where("id = ? AND status = ? AND moderated_by IS NULL", *comment.ParentID, CommentRemoved).First(&parent).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			comment = parent
+		}
+	})
+}
+
+// React sets the reaction of a user to a comment, replacing their previous
+// one.
+func (cs *CommentService) React(commentID, userID uint, reaction string) (CommentView, error) {
+	if !slices.Contains(CommentReactions, reaction) {
+		return CommentView{}, ErrInvalidReaction
+	}
+	if _, err := cs.Get(commentID, nil); err != nil {
+		return CommentView{}, err
+	}
+
+	err := cs.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "comment_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"type", "updated_at"}),
+	}).Create(&models.CommentReaction{CommentID: commentID, UserID: userID, Type: reaction}).Error
+	if err != nil {
+		return CommentView{}, err
+	}
+
+	return cs.Get(commentID, &userID)
+}
+
+// Unreact removes the reaction of a user to a comment.
+func (cs *CommentService) Unreact(commentID, userID uint) (CommentView, error) {
+	if err := cs.db.Where("comment_id = ? AND user_id = ?", commentID, userID).Delete(&models.CommentReaction{}).Error; err != nil {
+		return CommentView{}, err
+	}
+
+	return cs.Get(commentID, &userID)
+}
+
+// views adds their reply count and reactions to comments.
+func (cs *CommentService) views(comments []models.Comment, userID *uint) ([]CommentView, error) {
+	views := make([]CommentView, len(comments))
+	if len(comments) == 0 {
+		return views, nil
+	}
+
+	ids := make([]uint, len(comments))
+	index := make(map[uint]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+		index[comment.ID] = i
+		if comment.Status == CommentRemoved {
+			comment.Content = ""
+		}
+		views[i] = CommentView{Comment: comment, Reactions: map[string]int64{}}
+	}
+
+	var replies []struct {
+		ParentID uint
+		Count    int64
+	}
+	if err := cs.db.Model(&models.Comment{}).
+		Select("parent_id, COUNT(*) AS count").
+		Where("parent_id IN ? AND status <> ?", ids, CommentHidden).
+		Group("parent_id").
+		Scan(&replies).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range replies {
+		views[index[row.ParentID]].ReplyCount = row.Count
+	}
+
+	var reactions []struct {
+		CommentID uint
+		Type      string
+		Count     int64
+	}
+	if err := cs.db.Model(&models.CommentReaction{}).
+		Select("comment_id, type, COUNT(*) AS count").
+		Where("comment_id IN ?", ids).
+		Group("comment_id, type").
+		Scan(&reactions).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range reactions {
+		views[index[row.CommentID]].Reactions[row.Type] = row.Count
+	}
+
+	if userID != nil {
+		var mine []models.CommentReaction
+		if err := cs.db.Where("comment_id IN ? AND user_id = ?", ids, *userID).Find(&mine).Error; err != nil {
+			return nil, err
+		}
+		for _, reaction := range mine {
+			views[index[reaction.CommentID]].MyReaction = reaction.Type
+		}
+	}
+
+	return views, nil
+}
+
+// encodeCommentCursor builds an opaque cursor from the position of the last
+// comment of a page.
+func encodeCommentCursor(at time.Time, id uint) string {
+	raw := strconv.FormatInt(at.UnixNano(), 10) + ":" + strconv.FormatUint(uint64(id), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCommentCursor(cursor string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	commentID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return time.Unix(0, nanos), uint(commentID), nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommentCursor(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Time
+		id   uint
+	}{
+		{name: "nanoseconds", at: time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC), id: 42},
+		{name: "same time", at: time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC), id: 43},
+		{name: "first comment", at: time.Unix(0, 1), id: 1},
+		{name: "large id", at: time.Date(2038, 1, 19, 3, 14, 8, 0, time.UTC), id: 1<<32 - 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, id, err := decodeCommentCursor(encodeCommentCursor(tt.at, tt.id))
+			if err != nil {
+				t.Fatalf("decodeCommentCursor: %v", err)
+			}
+			if !at.Equal(tt.at) || id != tt.id {
+				t.Errorf("cursor decoded to (%v, %d), want (%v, %d)", at, id, tt.at, tt.id)
+			}
+		})
+	}
+
+	invalid := []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("1709296200000000000")),
+		base64.RawURLEncoding.EncodeToString([]byte("yesterday:42")),
+		base64.RawURLEncoding.EncodeToString([]byte("1709296200000000000:-1")),
+		base64.RawURLEncoding.EncodeToString([]byte("1709296200000000000:")),
+	}
+	for _, cursor := range invalid {
+		if _, _, err := decodeCommentCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeCommentCursor(%q) error = %v, want %v", cursor, err, ErrInvalidCursor)
+		}
+	}
+}
@@ -89,6 +89,97 @@ type AppConfig struct {
 		SubtitlesDir string `mapstructure:"SUBTITLES_DIR"`
 		TMDBAPIKey   string `mapstructure:"TMDB_API_KEY"`
 	} `mapstructure:"STREAMING"`
+
+	TORRENT struct {
+		ListenPort              int      `mapstructure:"LISTEN_PORT"`
+		DisableIPv6             bool     `mapstructure:"DISABLE_IPV6"`
+		DisableUTP              bool     `mapstructure:"DISABLE_UTP"`
+		NoDHT                   bool     `mapstructure:"NO_DHT"`
+		DisablePEX              bool     `mapstructure:"DISABLE_PEX"`
+		Trackers                []string `mapstructure:"TRACKERS"`
+		UploadRateLimit         int64    `mapstructure:"UPLOAD_RATE_LIMIT"`   // bytes per second, 0 means unlimited
+		DownloadRateLimit       int64    `mapstructure:"DOWNLOAD_RATE_LIMIT"` // bytes per second, 0 means unlimited
+		MaxConnsPerTorrent      int      `mapstructure:"MAX_CONNS_PER_TORRENT"`
+		HalfOpenConnsPerTorrent int      `mapstructure:"HALF_OPEN_CONNS_PER_TORRENT"`
+
+		Seeding struct {
+			Enabled        bool    `mapstructure:"ENABLED"`
+			RatioTarget    float64 `mapstructure:"RATIO_TARGET"`
+			MinSeedTimeRaw string  `mapstructure:"MIN_SEED_TIME"`
+			MaxSeedTimeRaw string  `mapstructure:"MAX_SEED_TIME"`
+			MinSeedTime    time.Duration
+			MaxSeedTime    time.Duration
+		} `mapstructure:"SEEDING"`
+	} `mapstructure:"TORRENT"`
+
+	LIBRARY struct {
+		Directories     []string `mapstructure:"DIRECTORIES"`
+		ScanIntervalRaw string   `mapstructure:"SCAN_INTERVAL"`
+		ScanInterval    time.Duration
+	} `mapstructure:"LIBRARY"`
+
+	STORAGE struct {
+		DownloadBudget   int64  `mapstructure:"DOWNLOAD_BUDGET"` // bytes, 0 means unlimited
+		HLSBudget        int64  `mapstructure:"HLS_BUDGET"`      // bytes, 0 means unlimited
+		MaxIdleRaw       string `mapstructure:"MAX_IDLE"`
+		CheckIntervalRaw string `mapstructure:"CHECK_INTERVAL"`
+		MaxIdle          time.Duration
+		CheckInterval    time.Duration
+
+		QuarantineDir        string `mapstructure:"QUARANTINE_DIR"`
+		ReconcileIntervalRaw string `mapstructure:"RECONCILE_INTERVAL"`
+		ReconcileInterval    time.Duration
+	} `mapstructure:"STORAGE"`
+
+	MEDIA_STORAGE struct {
+		Backend          string `mapstructure:"BACKEND"` // "local" or "s3"
+		Directory        string `mapstructure:"DIRECTORY"`
+		PublishHLS       bool   `mapstructure:"PUBLISH_HLS"`
+		KeepLocalHLS     bool   `mapstructure:"KEEP_LOCAL_HLS"`
+		ArchiveOriginals bool   `mapstructure:"ARCHIVE_ORIGINALS"`
+		Serve            string `mapstructure:"SERVE"` // "redirect" or "proxy"
+		PresignExpiryRaw string `mapstructure:"PRESIGN_EXPIRY"`
+		PresignExpiry    time.Duration
+
+		S3 struct {
+			Endpoint        string `mapstructure:"ENDPOINT"`
+			Region          string `mapstructure:"REGION"`
+			Bucket          string `mapstructure:"BUCKET"`
+			Prefix          string `mapstructure:"PREFIX"`
+			AccessKeyID     string `mapstructure:"ACCESS_KEY_ID"`
+			SecretAccessKey string `mapstructure:"SECRET_ACCESS_KEY"`
+			UsePathStyle    bool   `mapstructure:"USE_PATH_STYLE"`
+		} `mapstructure:"S3"`
+	} `mapstructure:"MEDIA_STORAGE"`
+
+	AVATARS struct {
+		Backend   string `mapstructure:"BACKEND"` // "local" or "s3"
+		Directory string `mapstructure:"DIRECTORY"`
+		PublicURL string `mapstructure:"PUBLIC_URL"`
+		MaxSize   int64  `mapstructure:"MAX_SIZE"` // bytes
+
+		S3 struct {
+			Endpoint     string `mapstructure:"ENDPOINT"`
+			Region       string `mapstructure:"REGION"`
+			Bucket       string `mapstructure:"BUCKET"`
+			UsePathStyle bool   `mapstructure:"USE_PATH_STYLE"`
+		} `mapstructure:"S3"`
+	} `mapstructure:"AVATARS"`
+
+	EXPORTS struct {
+		Directory  string `mapstructure:"DIRECTORY"`
+		ExpiryRaw  string `mapstructure:"EXPIRY"`
+		MaxPerUser int    `mapstructure:"MAX_PER_USER"` // 0 means unlimited
+		Expiry     time.Duration
+	} `mapstructure:"EXPORTS"`
+
+	COMMENTS struct {
+		BannedWords   []string `mapstructure:"BANNED_WORDS"`
+		FilterMode    string   `mapstructure:"FILTER_MODE"` // "mask" or "reject"
+		RateLimit     int      `mapstructure:"RATE_LIMIT"`  // comments per user per window, 0 means unlimited
+		RateWindowRaw string   `mapstructure:"RATE_WINDOW"`
+		RateWindow    time.Duration
+	} `mapstructure:"COMMENTS"`
 }
 
 func LoadConfig(config string) {
@@ -129,4 +220,67 @@ func setupExtra() {
 	}
 
 	Conf.JWT.RefreshTkExpiresAt = expAt
+
+	if Conf.TORRENT.Seeding.MinSeedTimeRaw != "" {
+		Conf.TORRENT.Seeding.MinSeedTime, err = utils.ParseDuration(Conf.TORRENT.Seeding.MinSeedTimeRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if Conf.TORRENT.Seeding.MaxSeedTimeRaw != "" {
+		Conf.TORRENT.Seeding.MaxSeedTime, err = utils.ParseDuration(Conf.TORRENT.Seeding.MaxSeedTimeRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if Conf.LIBRARY.ScanIntervalRaw != "" {
+		Conf.LIBRARY.ScanInterval, err = utils.ParseDuration(Conf.LIBRARY.ScanIntervalRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if Conf.STORAGE.MaxIdleRaw != "" {
+		Conf.STORAGE.MaxIdle, err = utils.ParseDuration(Conf.STORAGE.MaxIdleRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if Conf.STORAGE.CheckIntervalRaw != "" {
+		Conf.STORAGE.CheckInterval, err = utils.ParseDuration(Conf.STORAGE.CheckIntervalRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if Conf.STORAGE.ReconcileIntervalRaw != "" {
+		Conf.STORAGE.ReconcileInterval, err = utils.ParseDuration(Conf.STORAGE.ReconcileIntervalRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if Conf.MEDIA_STORAGE.PresignExpiryRaw != "" {
+		Conf.MEDIA_STORAGE.PresignExpiry, err = utils.ParseDuration(Conf.MEDIA_STORAGE.PresignExpiryRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if Conf.EXPORTS.ExpiryRaw != "" {
+		Conf.EXPORTS.Expiry, err = utils.ParseDuration(Conf.EXPORTS.ExpiryRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if Conf.COMMENTS.RateWindowRaw != "" {
+		Conf.COMMENTS.RateWindow, err = utils.ParseDuration(Conf.COMMENTS.RateWindowRaw)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"server/internal/models"
+	"server/internal/release"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent"
+)
+
+const (
+	directPlayReadahead     = 10 * 1024 * 1024
+	directPlayProbeTime     = 30 * time.Second
+	directPlayProbeInterval = time.Minute
+)
+
+var (
+	// directPlayExts are the containers browsers play natively.
+	directPlayExts = []string{".mp4", ".m4v"}
+
+	// highBitDepth matches the 10-bit H.264 releases browsers cannot decode.
+	highBitDepth = regexp.MustCompile(`(?i)\b(?:10.?bit|hi10p?)\b`)
+)
+
+// DirectPlayFile is the original video file of a stream, which browsers can
+// play without it being transcoded.
+type DirectPlayFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	path    string
+	file    *torrent.File
+}
+
+// Open reads the file. A file still being downloaded is read from its
+// torrent, reads blocking until the pieces they need are verified.
+func (f DirectPlayFile) Open(ctx context.Context) (io.ReadSeekCloser, error) {
+	if f.file != nil {
+		reader := f.file.NewReader()
+		reader.SetContext(ctx)
+		reader.SetReadahead(directPlayReadahead)
+		return reader, nil
+	}
+	return os.Open(f.path)
+}
+
+// DirectPlay returns the original file of a stream when it is an H.264/AAC
+// MP4 browsers can play as it is. Stored sources are probed in the
+// background; until then they are transcoded.
+func (ts *TorrentService) DirectPlay(key StreamKey) (DirectPlayFile, bool) {
+	if key.SourceID == 0 {
+		if dl, ok := ts.ActiveDownload(key.MovieID); ok {
+			dl.Mu.RLock()
+			videoFile, status := dl.VideoFile, dl.Status
+			dl.Mu.RUnlock()
+
+			if videoFile != nil && status != "completed" {
+				name := videoFile.DisplayPath()
+				info := release.Parse(dl.Torrent.Name())
+				if info.Resolution == "" {
+					info = release.Parse(filepath.Base(name))
+				}
+				if !directPlayCandidate(name, info) || info.VideoCodec != "H.264" || info.AudioCodec != "AAC" {
+					return DirectPlayFile{}, false
+				}
+				return DirectPlayFile{
+					Name: filepath.Base(name),
+					Size: videoFile.Length(),
+					file: videoFile,
+				}, true
+			}
+		}
+	}
+
+	var source StoredSource
+	var ok bool
+	if key.SourceID != 0 {
+		source, ok = ts.Source(key.MovieID, key.SourceID)
+	} else {
+		source, ok = ts.PreferredSource(key.MovieID)
+	}
+	if !ok || source.FilePath == "" {
+		return DirectPlayFile{}, false
+	}
+
+	// Archived sources are only restored to be transcoded.
+	stat, err := os.Stat(source.FilePath)
+	if err != nil || stat.IsDir() {
+		return DirectPlayFile{}, false
+	}
+	if source.DirectPlay == nil || !*source.DirectPlay {
+		return DirectPlayFile{}, false
+	}
+
+	return DirectPlayFile{
+		Name:    filepath.Base(source.FilePath),
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+		path:    source.FilePath,
+	}, true
+}
+
+// directPlayProbeWorker probes the stored sources that were not yet, so that
+// requests only read the result.
+func (ts *TorrentService) directPlayProbeWorker() {
+	ticker := time.NewTicker(directPlayProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		var sources []models.DownloadedMovie
+		if err := ts.db.Where("direct_play IS NULL").Find(&sources).Error; err != nil {
+			Logger.Error(fmt.Sprintf("Failed to list sources to probe: %v", err))
+		}
+		for _, source := range sources {
+			ts.probeSource(source)
+		}
+
+		<-ticker.C
+	}
+}
+
+// probeSource records whether browsers can play the file of a stored source.
+// The release name rules out most files; the others are probed, as names
+// often leave out the audio codec. Files that are not on disk, like archived
+// ones, are probed once restored.
+func (ts *TorrentService) probeSource(source models.DownloadedMovie) {
+	if stat, err := os.Stat(source.FilePath); err != nil || stat.IsDir() {
+		return
+	}
+
+	playable := false
+	if directPlayCandidate(source.FilePath, sourceInfo(source)) {
+		var err error
+		if playable, err = probeDirectPlay(source.FilePath); err != nil {
+			// Left unknown, the source is probed again later.
+			Logger.Warn(fmt.Sprintf("Failed to probe %s: %v", source.FilePath, err))
+			return
+		}
+	}
+	if err := ts.db.Model(&source).Update("direct_play", playable).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to record the direct play probe of source %d: %v", source.ID, err))
+	}
+}
+
+// directPlayCandidate reports whether the name of a video file leaves a
+// chance that browsers play it.
+func directPlayCandidate(name string, info release.Info) bool {
+	if !slices.Contains(directPlayExts, strings.ToLower(filepath.Ext(name))) {
+		return false
+	}
+	if len(info.HDR) > 0 || highBitDepth.MatchString(name) {
+		return false
+	}
+	return (info.VideoCodec == "" || info.VideoCodec == "H.264") && (info.AudioCodec == "" || info.AudioCodec == "AAC")
+}
+
+// probeDirectPlay checks the codecs of a complete file with ffprobe. Files
+// ffprobe cannot read are not playable; an error means the probe itself
+// failed.
+func probeDirectPlay(path string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), directPlayProbeTime)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,pix_fmt",
+		"-of", "json",
+		path,
+	).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			PixFmt    string `json:"pix_fmt"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return false, err
+	}
+
+	video, audio := false, true
+	seenVideo, seenAudio := false, false
+	for _, stream := range result.Streams {
+		switch stream.CodecType {
+		case "video":
+			if seenVideo || stream.CodecName == "mjpeg" || stream.CodecName == "png" {
+				continue // cover art
+			}
+			seenVideo = true
+			video = stream.CodecName == "h264" && (stream.PixFmt == "yuv420p" || stream.PixFmt == "yuvj420p")
+		case "audio":
+			if seenAudio {
+				continue
+			}
+			seenAudio = true
+			audio = stream.CodecName == "aac"
+		}
+	}
+
+	return video && audio, nil
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProbeDirectPlay(t *testing.T) {
+	tests := []struct {
+		name    string
+		ffprobe string // script standing in for ffprobe, none when empty
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:    "playable",
+			ffprobe: `echo '{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p"},{"codec_type":"audio","codec_name":"aac"}]}'`,
+			want:    true,
+		},
+		{
+			name:    "cover art first",
+			ffprobe: `echo '{"streams":[{"codec_type":"video","codec_name":"mjpeg"},{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p"},{"codec_type":"audio","codec_name":"aac"}]}'`,
+			want:    true,
+		},
+		{
+			name:    "10-bit",
+			ffprobe: `echo '{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p10le"},{"codec_type":"audio","codec_name":"aac"}]}'`,
+		},
+		{
+			name:    "ac3 audio",
+			ffprobe: `echo '{"streams":[{"codec_type":"video","codec_name":"h264","pix_fmt":"yuv420p"},{"codec_type":"audio","codec_name":"ac3"}]}'`,
+		},
+		{name: "unreadable file", ffprobe: `echo 'Invalid data found when processing input' >&2; exit 1`},
+		{name: "garbled output", ffprobe: `echo '{"streams":'`, wantErr: true},
+		{name: "ffprobe missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bin := t.TempDir()
+			if tt.ffprobe != "" {
+				script := "#!/bin/sh\n" + tt.ffprobe + "\n"
+				if err := os.WriteFile(filepath.Join(bin, "ffprobe"), []byte(script), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("PATH", bin)
+
+			got, err := probeDirectPlay("movie.mp4")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("probeDirectPlay = %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
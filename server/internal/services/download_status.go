@@ -0,0 +1,218 @@
+package services
+
+import (
+	"path/filepath"
+	"server/internal/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DownloadStatus describes how a movie download is doing in its swarm.
+type DownloadStatus struct {
+	MovieID            int          `json:"movie_id" example:"603"`
+	InfoHash           string       `json:"info_hash" example:"c9e15763f722f23e98a29decdfae341b98d53056"`
+	Name               string       `json:"name" example:"The.Matrix.1999.1080p.BluRay.x264"`
+	SelectedFile       string       `json:"selected_file" example:"The.Matrix.1999.1080p.BluRay.x264/The.Matrix.1999.1080p.BluRay.x264.mkv"`
+	Status             string       `json:"status" example:"downloading"`
+	Progress           float64      `json:"progress" example:"42.5"`
+	BytesCompleted     int64        `json:"bytes_completed" example:"1073741824"`
+	TotalBytes         int64        `json:"total_bytes" example:"2147483648"`
+	FileBytesCompleted int64        `json:"file_bytes_completed" example:"1073741824"`
+	FileTotalBytes     int64        `json:"file_total_bytes" example:"2147483648"`
+	Pieces             PieceSummary `json:"pieces"`
+	ConnectedPeers     int          `json:"connected_peers" example:"24"`
+	Seeders            int          `json:"seeders" example:"18"`
+	Leechers           int          `json:"leechers" example:"6"`
+	DownloadRate       int64        `json:"download_rate" example:"2097152"`
+	UploadRate         int64        `json:"upload_rate" example:"65536"`
+	ETASeconds         *int64       `json:"eta_seconds,omitempty" example:"512"`
+	StartedAt          time.Time    `json:"started_at"`
+	CompletedAt        *time.Time   `json:"completed_at,omitempty"`
+	Active             bool         `json:"active" example:"true"`
+}
+
+// PieceSummary counts the pieces of a torrent by state.
+type PieceSummary struct {
+	Total    int `json:"total" example:"1024"`
+	Complete int `json:"complete" example:"512"`
+	Partial  int `json:"partial" example:"8"`
+	Checking int `json:"checking" example:"2"`
+	Missing  int `json:"missing" example:"502"`
+}
+
+type transferSample struct {
+	at      time.Time
+	read    int64
+	written int64
+}
+
+// transferRateWorker samples the transfer counters of every active torrent
+// to derive download and upload rates.
+func (ts *TorrentService) transferRateWorker() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	samples := make(map[*models.TorrentDownload]transferSample)
+
+	for range ticker.C {
+		seen := make(map[*models.TorrentDownload]bool)
+
+		ts.Downloads.Range(func(_, value interface{}) bool {
+			dl, ok := value.(*models.TorrentDownload)
+			if !ok || dl.Torrent == nil {
+				return true
+			}
+			seen[dl] = true
+
+			stats := dl.Torrent.Stats()
+			current := transferSample{
+				at:      time.Now(),
+				read:    stats.BytesReadUsefulData.Int64(),
+				written: stats.BytesWrittenData.Int64(),
+			}
+
+			if previous, ok := samples[dl]; ok {
+				elapsed := current.at.Sub(previous.at).Seconds()
+				if elapsed > 0 {
+					dl.Mu.Lock()
+					dl.DownloadRate = int64(float64(current.read-previous.read) / elapsed)
+					dl.UploadRate = int64(float64(current.written-previous.written) / elapsed)
+					dl.Mu.Unlock()
+				}
+			}
+			samples[dl] = current
+
+			return true
+		})
+
+		for dl := range samples {
+			if !seen[dl] {
+				delete(samples, dl)
+			}
+		}
+	}
+}
+
+// DownloadStatus returns the swarm statistics of the download of a movie.
+// Movies that already finished downloading are reported from their preferred
+// source.
+func (ts *TorrentService) DownloadStatus(movieID int) (*DownloadStatus, error) {
+	if dl, ok := ts.ActiveDownload(movieID); ok && dl.Torrent != nil {
+		return buildDownloadStatus(dl), nil
+	}
+
+	source, ok := ts.PreferredSource(movieID)
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	completedAt := source.DownloadedAt
+	return &DownloadStatus{
+		MovieID:            movieID,
+		InfoHash:           source.InfoHash,
+		SelectedFile:       selectedSourceFile(ts.downloadDir, source.DownloadedMovie),
+		Status:             "completed",
+		Progress:           100,
+		BytesCompleted:     source.FileSize,
+		TotalBytes:         source.FileSize,
+		FileBytesCompleted: source.FileSize,
+		FileTotalBytes:     source.FileSize,
+		CompletedAt:        &completedAt,
+	}, nil
+}
+
+// selectedSourceFile names the file of a source the way active downloads do,
+// relative to the directory of its torrent; files from anywhere else are
+// named by their base name, keeping server paths private.
+func selectedSourceFile(downloadDir string, row models.DownloadedMovie) string {
+	if root := downloadRoot(downloadDir, row); root != row.FilePath {
+		if rel, err := filepath.Rel(root, row.FilePath); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.Base(row.FilePath)
+}
+
+// ListDownloadStatuses returns the statistics of every active download.
+func (ts *TorrentService) ListDownloadStatuses() []DownloadStatus {
+	statuses := []DownloadStatus{}
+
+	ts.Downloads.Range(func(_, value interface{}) bool {
+		if dl, ok := value.(*models.TorrentDownload); ok && dl.Torrent != nil {
+			statuses = append(statuses, *buildDownloadStatus(dl))
+		}
+		return true
+	})
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].MovieID < statuses[j].MovieID
+	})
+
+	return statuses
+}
+
+func buildDownloadStatus(dl *models.TorrentDownload) *DownloadStatus {
+	t := dl.Torrent
+
+	dl.Mu.RLock()
+	status := &DownloadStatus{
+		MovieID:      dl.MovieID,
+		Status:       dl.Status,
+		Progress:     dl.Progress,
+		DownloadRate: dl.DownloadRate,
+		UploadRate:   dl.UploadRate,
+		StartedAt:    dl.StartedAt,
+		CompletedAt:  dl.CompletedAt,
+		Active:       true,
+	}
+	videoFile := dl.VideoFile
+	dl.Mu.RUnlock()
+
+	status.InfoHash = t.InfoHash().HexString()
+
+	// Without metadata the torrent has no name, size or pieces yet.
+	select {
+	case <-t.GotInfo():
+	default:
+		return status
+	}
+
+	status.Name = t.Name()
+	status.BytesCompleted = t.BytesCompleted()
+	status.TotalBytes = t.Length()
+
+	if videoFile != nil {
+		status.SelectedFile = videoFile.Path()
+		status.FileBytesCompleted = videoFile.BytesCompleted()
+		status.FileTotalBytes = videoFile.Length()
+	}
+
+	for _, run := range t.PieceStateRuns() {
+		status.Pieces.Total += run.Length
+		switch {
+		case run.Complete:
+			status.Pieces.Complete += run.Length
+		case run.Hashing || run.QueuedForHash || run.Marking:
+			status.Pieces.Checking += run.Length
+		case run.Partial:
+			status.Pieces.Partial += run.Length
+		default:
+			status.Pieces.Missing += run.Length
+		}
+	}
+
+	stats := t.Stats()
+	status.ConnectedPeers = stats.ActivePeers
+	status.Seeders = stats.ConnectedSeeders
+	status.Leechers = max(stats.ActivePeers-stats.ConnectedSeeders, 0)
+
+	remaining := status.TotalBytes - status.BytesCompleted
+	if remaining > 0 && status.DownloadRate > 0 {
+		eta := remaining / status.DownloadRate
+		status.ETASeconds = &eta
+	}
+
+	return status
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"server/internal/models"
+	"testing"
+)
+
+func TestSelectedSourceFile(t *testing.T) {
+	tests := []struct {
+		name string
+		row  models.DownloadedMovie
+		want string
+	}{
+		{
+			name: "torrent",
+			row:  models.DownloadedMovie{MovieID: 603, InfoHash: "abc", FilePath: "/downloads/603/abc/The.Matrix.1999.1080p/The.Matrix.1999.1080p.mkv"},
+			want: "The.Matrix.1999.1080p/The.Matrix.1999.1080p.mkv",
+		},
+		{
+			name: "single file torrent",
+			row:  models.DownloadedMovie{MovieID: 603, InfoHash: "abc", FilePath: "/downloads/603/abc/The.Matrix.1999.mp4"},
+			want: "The.Matrix.1999.mp4",
+		},
+		{
+			name: "before the per-torrent layout",
+			row:  models.DownloadedMovie{MovieID: 603, FilePath: "/downloads/603/The.Matrix.1999/The.Matrix.1999.mkv"},
+			want: "The.Matrix.1999.mkv",
+		},
+		{
+			name: "library",
+			row:  models.DownloadedMovie{MovieID: 603, FilePath: "/mnt/movies/The Matrix (1999)/The Matrix (1999).mkv"},
+			want: "The Matrix (1999).mkv",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := selectedSourceFile("/downloads", tt.row); got != tt.want {
+			t.Errorf("%s: selectedSourceFile = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"server/internal/models"
+	"server/internal/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+
+	defaultExportExpiry   = 48 * time.Hour
+	exportCleanupInterval = 10 * time.Minute
+	exportQueueSize       = 64
+)
+
+var (
+	ErrExportNotFound  = errors.New("export not found")
+	ErrExportNotReady  = errors.New("export is not ready")
+	ErrInvalidExport   = errors.New("invalid export")
+	ErrStreamNotReady  = errors.New("the movie must be fully transcoded in this quality before it can be exported")
+	ErrTooManyExports  = errors.New("too many exports, delete one first")
+	ErrExportQueueFull = errors.New("too many exports are being prepared, try again later")
+)
+
+var exportLanguageRegex = regexp.MustCompile(`^[a-zA-Z-]{2,10}$`)
+
+// ExportRequest selects the stream, quality, audio track and subtitles of an
+// export.
+type ExportRequest struct {
+	MovieID  int    `json:"movie_id" example:"603"`
+	SourceID uint   `json:"source_id,omitempty" example:"12"`
+	Quality  string `json:"quality" example:"720p"`
+	// AudioTrack is the audio track of the source file. The first one is
+	// taken from the stream, others from the source file, which must still
+	// be on disk.
+	AudioTrack int      `json:"audio_track" example:"0"`
+	Subtitles  []string `json:"subtitles" example:"en,fr"`
+}
+
+// ExportEvent is pushed to the user of an export when its status changes.
+type ExportEvent struct {
+	Type     string `json:"type" example:"export"`
+	ExportID uint   `json:"export_id" example:"4"`
+	MovieID  int    `json:"movie_id" example:"603"`
+	Status   string `json:"status" example:"ready"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ExportService remuxes finished HLS renditions into MP4 files users can
+// download for offline viewing. Exports are built one at a time in the
+// background and removed once they expire.
+type ExportService struct {
+	db             *gorm.DB
+	movieService   *MovieService
+	torrentService *TorrentService
+	dir            string
+	expiry         time.Duration
+	queue          chan uint
+}
+
+func NewExportService(db *gorm.DB, movieService *MovieService, torrentService *TorrentService) (*ExportService, error) {
+	dir := Conf.EXPORTS.Directory
+	if dir == "" {
+		dir = "exports"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	expiry := Conf.EXPORTS.Expiry
+	if expiry <= 0 {
+		expiry = defaultExportExpiry
+	}
+
+	es := &ExportService{
+		db:             db,
+		movieService:   movieService,
+		torrentService: torrentService,
+		dir:            dir,
+		expiry:         expiry,
+		queue:          make(chan uint, exportQueueSize),
+	}
+
+	go es.worker()
+	go es.resumeExports()
+	go es.cleanupWorker()
+
+	return es, nil
+}
+
+// Create validates an export request and queues the export. An identical
+// export of the user that is still usable is returned instead of a new one.
+func (es *ExportService) Create(userID uint, req ExportRequest) (models.MovieExport, error) {
+	if !exportQualityEnabled(req.Quality) {
+		return models.MovieExport{}, fmt.Errorf("%w: unknown quality %q", ErrInvalidExport, req.Quality)
+	}
+	if req.AudioTrack < 0 {
+		return models.MovieExport{}, fmt.Errorf("%w: invalid audio track %d", ErrInvalidExport, req.AudioTrack)
+	}
+
+	var subtitles []string
+	for _, lang := range req.Subtitles {
+		if !exportLanguageRegex.MatchString(lang) {
+			return models.MovieExport{}, fmt.Errorf("%w: invalid subtitle language %q", ErrInvalidExport, lang)
+		}
+		if !slices.Contains(subtitles, lang) {
+			subtitles = append(subtitles, lang)
+		}
+	}
+	slices.Sort(subtitles)
+
+	key := StreamKey{MovieID: req.MovieID, SourceID: req.SourceID}
+	if key.SourceID != 0 {
+		if _, ok := es.torrentService.Source(key.MovieID, key.SourceID); !ok {
+			return models.MovieExport{}, fmt.Errorf("%w: unknown source %d", ErrInvalidExport, key.SourceID)
+		}
+	}
+	if !es.renditionAvailable(key, req.Quality) {
+		return models.MovieExport{}, ErrStreamNotReady
+	}
+	for _, lang := range subtitles {
+		if !es.subtitleAvailable(key, lang) {
+			return models.MovieExport{}, fmt.Errorf("%w: no %q subtitles for this movie", ErrInvalidExport, lang)
+		}
+	}
+
+	var existing models.MovieExport
+	err := es.db.Where("user_id = ? AND movie_id = ? AND source_id = ? AND quality = ? AND audio_track = ? AND subtitles = ? AND status <> ?",
+		userID, key.MovieID, key.SourceID, req.Quality, req.AudioTrack, strings.Join(subtitles, ","), ExportFailed).
+		First(&existing).Error
+	if err == nil {
+		return existing, nil
+	}
+
+	if limit := Conf.EXPORTS.MaxPerUser; limit > 0 {
+		var count int64
+		if err := es.db.Model(&models.MovieExport{}).Where("user_id = ? AND status <> ?", userID, ExportFailed).Count(&count).Error; err != nil {
+			return models.MovieExport{}, err
+		}
+		if count >= int64(limit) {
+			return models.MovieExport{}, ErrTooManyExports
+		}
+	}
+
+	export := models.MovieExport{
+		UserID:     userID,
+		MovieID:    key.MovieID,
+		SourceID:   key.SourceID,
+		Quality:    req.Quality,
+		AudioTrack: req.AudioTrack,
+		Subtitles:  strings.Join(subtitles, ","),
+		Status:     ExportPending,
+	}
+	if err := es.db.Create(&export).Error; err != nil {
+		return models.MovieExport{}, err
+	}
+
+	select {
+	case es.queue <- export.ID:
+	default:
+		es.db.Delete(&export)
+		return models.MovieExport{}, ErrExportQueueFull
+	}
+
+	Logger.Info(fmt.Sprintf("Queued export %d of %s in %s", export.ID, key, export.Quality))
+	return export, nil
+}
+
+// List returns the exports of a user, newest first.
+func (es *ExportService) List(userID uint) ([]models.MovieExport, error) {
+	var exports []models.MovieExport
+	err := es.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&exports).Error
+	return exports, err
+}
+
+// Get returns an export of a user.
+func (es *ExportService) Get(userID uint, id uint) (models.MovieExport, error) {
+	var export models.MovieExport
+	if err := es.db.Where("id = ? AND user_id = ?", id, userID).First(&export).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.MovieExport{}, ErrExportNotFound
+		}
+		return models.MovieExport{}, err
+	}
+	return export, nil
+}
+
+// File returns the file of a ready export of a user.
+func (es *ExportService) File(userID uint, id uint) (models.MovieExport, error) {
+	export, err := es.Get(userID, id)
+	if err != nil {
+		return models.MovieExport{}, err
+	}
+	if export.Status != ExportReady || export.FilePath == "" {
+		return models.MovieExport{}, ErrExportNotReady
+	}
+	if export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt) {
+		return models.MovieExport{}, ErrExportNotFound
+	}
+	if _, err := os.Stat(export.FilePath); err != nil {
+		return models.MovieExport{}, ErrExportNotFound
+	}
+	return export, nil
+}
+
+// Delete removes an export of a user and its file. An export being built is
+// dropped once ffmpeg is done with it.
+func (es *ExportService) Delete(userID uint, id uint) error {
+	export, err := es.Get(userID, id)
+	if err != nil {
+		return err
+	}
+	return es.remove(export)
+}
+
+func (es *ExportService) remove(export models.MovieExport) error {
+	if err := es.db.Delete(&export).Error; err != nil {
+		return err
+	}
+	if export.Status != ExportProcessing {
+		return os.RemoveAll(es.workDir(export.ID))
+	}
+	return nil
+}
+
+// ExportFileName is the name an export is downloaded as.
+func ExportFileName(export models.MovieExport) string {
+	return fmt.Sprintf("movie-%d-%s.mp4", export.MovieID, export.Quality)
+}
+
+func (es *ExportService) workDir(id uint) string {
+	return filepath.Join(es.dir, strconv.FormatUint(uint64(id), 10))
+}
+
+func exportQualityEnabled(name string) bool {
+	for _, quality := range VideoTranscoderConf.Qualities {
+		if quality.Enabled && quality.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// renditionAvailable reports whether a quality of a stream was fully
+// transcoded, locally or in the media storage.
+func (es *ExportService) renditionAvailable(key StreamKey, quality string) bool {
+	playlist := filepath.Join(hlsBaseDir(), key.Dir(), quality, "playlist.m3u8")
+	if complete, err := playlistComplete(playlist); err == nil {
+		return complete
+	}
+	_, ok := es.movieService.PublishedStream(key)
+	return ok
+}
+
+func (es *ExportService) subtitleAvailable(key StreamKey, lang string) bool {
+	if _, err := os.Stat(filepath.Join(hlsBaseDir(), key.Dir(), "subs", lang, "subtitle.vtt")); err == nil {
+		return true
+	}
+	objectKey, ok := es.movieService.PublishedFile(key, "subs/"+lang+"/subtitle.vtt")
+	if !ok || MediaStore() == nil {
+		return false
+	}
+	exists, err := MediaStore().Exists(context.Background(), objectKey)
+	return err == nil && exists
+}
+
+// resumeExports queues again the exports a restart interrupted.
+func (es *ExportService) resumeExports() {
+	var exports []models.MovieExport
+	if err := es.db.Where("status IN ?", []string{ExportPending, ExportProcessing}).Order("id").Find(&exports).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to load pending exports: %v", err))
+		return
+	}
+
+	for _, export := range exports {
+		if export.Status == ExportProcessing {
+			os.RemoveAll(es.workDir(export.ID))
+			es.db.Model(&export).Update("status", ExportPending)
+		}
+		es.queue <- export.ID
+	}
+}
+
+func (es *ExportService) worker() {
+	for id := range es.queue {
+		es.build(id)
+	}
+}
+
+func (es *ExportService) build(id uint) {
+	var export models.MovieExport
+	if err := es.db.First(&export, id).Error; err != nil || export.Status != ExportPending {
+		return
+	}
+	if err := es.db.Model(&export).Update("status", ExportProcessing).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to start export %d: %v", id, err))
+		return
+	}
+	es.notify(export, ExportProcessing, "")
+
+	Logger.Info(fmt.Sprintf("Building export %d of movie %d in %s", id, export.MovieID, export.Quality))
+
+	workDir := es.workDir(id)
+	output := filepath.Join(workDir, "movie.mp4")
+	err := es.remux(export, workDir, output)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("Export %d of movie %d failed: %v", id, export.MovieID, err))
+		os.RemoveAll(workDir)
+		es.db.Model(&export).Updates(map[string]interface{}{
+			"status": ExportFailed,
+			"error":  err.Error(),
+		})
+		es.notify(export, ExportFailed, err.Error())
+		return
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		os.RemoveAll(workDir)
+		es.db.Model(&export).Updates(map[string]interface{}{
+			"status": ExportFailed,
+			"error":  err.Error(),
+		})
+		es.notify(export, ExportFailed, err.Error())
+		return
+	}
+
+	expiresAt := time.Now().Add(es.expiry)
+	result := es.db.Model(&export).Updates(map[string]interface{}{
+		"status":     ExportReady,
+		"file_path":  output,
+		"file_size":  info.Size(),
+		"expires_at": expiresAt,
+	})
+	// The export was deleted while it was built.
+	if result.Error != nil || result.RowsAffected == 0 {
+		os.RemoveAll(workDir)
+		return
+	}
+
+	Logger.Info(fmt.Sprintf("Export %d of movie %d is ready (%d bytes)", id, export.MovieID, info.Size()))
+	es.notify(export, ExportReady, "")
+}
+
+// notify pushes the status of an export to its user.
+func (es *ExportService) notify(export models.MovieExport, status, message string) {
+	if es.movieService == nil || es.movieService.websocketService == nil {
+		return
+	}
+	es.movieService.websocketService.PublishUser(export.UserID, ExportEvent{
+		Type:     "export",
+		ExportID: export.ID,
+		MovieID:  export.MovieID,
+		Status:   status,
+		Error:    message,
+	})
+}
+
+// remux writes the MP4 file of an export. Video, and the first audio track,
+// are copied from the HLS rendition; other audio tracks are encoded from the
+// source file and subtitles are converted to MP4 text tracks.
+func (es *ExportService) remux(export models.MovieExport, workDir, output string) error {
+	key := StreamKey{MovieID: export.MovieID, SourceID: export.SourceID}
+	var subtitles []string
+	if export.Subtitles != "" {
+		subtitles = strings.Split(export.Subtitles, ",")
+	}
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+
+	streamDir := filepath.Join(hlsBaseDir(), key.Dir())
+	if complete, err := playlistComplete(filepath.Join(streamDir, export.Quality, "playlist.m3u8")); err != nil || !complete {
+		streamDir = filepath.Join(workDir, "hls")
+		if err := es.fetchRendition(key, export.Quality, subtitles, streamDir); err != nil {
+			return fmt.Errorf("failed to fetch published stream: %w", err)
+		}
+	}
+
+	args := []string{"-y", "-i", filepath.Join(streamDir, export.Quality, "playlist.m3u8")}
+	input := 1
+
+	audioInput := 0
+	if export.AudioTrack > 0 {
+		original, err := es.sourceFile(key)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-i", original)
+		audioInput = input
+		input++
+	}
+
+	subtitleInputs := make([]int, len(subtitles))
+	for i, lang := range subtitles {
+		args = append(args, "-i", filepath.Join(streamDir, "subs", lang, "subtitle.vtt"))
+		subtitleInputs[i] = input
+		input++
+	}
+
+	args = append(args, "-map", "0:v:0")
+	if audioInput == 0 {
+		args = append(args, "-map", "0:a:0?", "-c:a", "copy")
+	} else {
+		args = append(args,
+			"-map", fmt.Sprintf("%d:a:%d", audioInput, export.AudioTrack),
+			"-c:a", "aac",
+			"-b:a", VideoTranscoderConf.Encoding.AudioBitrate,
+		)
+		if VideoTranscoderConf.Encoding.AudioChannels > 0 {
+			args = append(args, "-ac", strconv.Itoa(VideoTranscoderConf.Encoding.AudioChannels))
+		}
+	}
+	for i, lang := range subtitles {
+		args = append(args,
+			"-map", fmt.Sprintf("%d:s:0", subtitleInputs[i]),
+			fmt.Sprintf("-metadata:s:s:%d", i), "language="+lang,
+			fmt.Sprintf("-metadata:s:s:%d", i), "title="+utils.GetLanguageLabel(lang),
+		)
+	}
+
+	tmp := output + ".part"
+	args = append(args,
+		"-c:v", "copy",
+		"-c:s", "mov_text",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		tmp,
+	)
+
+	out, err := exec.Command("ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ffmpeg: %v: %s", err, lastLine(out))
+	}
+
+	return os.Rename(tmp, output)
+}
+
+// sourceFile returns the file a stream was transcoded from, when it is still
+// on disk.
+func (es *ExportService) sourceFile(key StreamKey) (string, error) {
+	var source StoredSource
+	var ok bool
+	if key.SourceID != 0 {
+		source, ok = es.torrentService.Source(key.MovieID, key.SourceID)
+	} else {
+		source, ok = es.torrentService.PreferredSource(key.MovieID)
+	}
+	if !ok || source.FilePath == "" {
+		return "", fmt.Errorf("the source of %s is gone, only its first audio track can be exported", key)
+	}
+	if _, err := os.Stat(source.FilePath); err != nil {
+		return "", fmt.Errorf("the source of %s is not on disk, only its first audio track can be exported", key)
+	}
+	return source.FilePath, nil
+}
+
+// fetchRendition copies a quality and subtitles of a published stream from the
+// media storage to dir.
+func (es *ExportService) fetchRendition(key StreamKey, quality string, subtitles []string, dir string) error {
+	store := MediaStore()
+	if store == nil {
+		return fmt.Errorf("media storage is not configured")
+	}
+	ctx := context.Background()
+
+	fetch := func(name string) error {
+		objectKey, ok := es.movieService.PublishedFile(key, name)
+		if !ok {
+			return fmt.Errorf("%s is not published", key)
+		}
+		return fetchMedia(ctx, store, objectKey, filepath.Join(dir, filepath.FromSlash(name)))
+	}
+
+	playlist := quality + "/playlist.m3u8"
+	if err := fetch(playlist); err != nil {
+		return err
+	}
+
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(playlist)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fetch(quality + "/" + line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, lang := range subtitles {
+		if err := fetch("subs/" + lang + "/subtitle.vtt"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lastLine returns the last non-empty line of a command output, where ffmpeg
+// writes its error.
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func (es *ExportService) cleanupWorker() {
+	es.Cleanup()
+
+	ticker := time.NewTicker(exportCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		es.Cleanup()
+	}
+}
+
+// Cleanup removes expired exports, failed exports older than the expiry and
+// export files no export refers to.
+func (es *ExportService) Cleanup() {
+	now := time.Now()
+
+	var expired []models.MovieExport
+	if err := es.db.Where("(status = ? AND expires_at < ?) OR (status = ? AND updated_at < ?)",
+		ExportReady, now, ExportFailed, now.Add(-es.expiry)).Find(&expired).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to load expired exports: %v", err))
+		return
+	}
+	for _, export := range expired {
+		if err := es.remove(export); err != nil {
+			Logger.Error(fmt.Sprintf("Failed to remove export %d: %v", export.ID, err))
+			continue
+		}
+		Logger.Info(fmt.Sprintf("Removed expired export %d of movie %d", export.ID, export.MovieID))
+	}
+
+	entries, err := os.ReadDir(es.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		var count int64
+		if err := es.db.Model(&models.MovieExport{}).Where("id = ?", id).Count(&count).Error; err != nil || count > 0 {
+			continue
+		}
+		os.RemoveAll(filepath.Join(es.dir, entry.Name()))
+	}
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"server/internal/models"
+	"server/internal/release"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var errNoLibraryMatch = errors.New("no tmdb match")
+
+var (
+	libraryVideoExts = []string{".mp4", ".mkv", ".avi", ".mov", ".wmv", ".webm", ".m4v"}
+
+	// tmdbIDTag matches the "{tmdb-603}" and "[tmdbid-603]" tags media
+	// servers use in file and folder names.
+	tmdbIDTag = regexp.MustCompile(`(?i)[\[{(]tmdb(?:id)?[-=](\d+)[\]})]`)
+)
+
+// LibraryService registers movie files found in the configured library
+// directories as downloaded movies, without copying them.
+type LibraryService struct {
+	directories  []string
+	scanInterval time.Duration
+	db           *gorm.DB
+	movieService *MovieService
+
+	scanMu sync.Mutex
+	// unmatched remembers files TMDB had no match for, by modification
+	// time, so they are not looked up again on every scan.
+	unmatched map[string]time.Time
+
+	lastScanMu sync.RWMutex
+	lastScan   LibraryScanResult
+	scanning   bool
+}
+
+// LibraryScanResult summarizes what a library scan changed.
+type LibraryScanResult struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Files      int       `json:"files" example:"120"`
+	Added      int       `json:"added" example:"3"`
+	Moved      int       `json:"moved" example:"1"`
+	Removed    int       `json:"removed" example:"2"`
+	Unmatched  []string  `json:"unmatched"`
+	Errors     []string  `json:"errors"`
+}
+
+// LibraryScanStatus tells whether a library scan is running, with the result
+// of the latest one.
+type LibraryScanStatus struct {
+	Running  bool              `json:"running" example:"true"`
+	LastScan LibraryScanResult `json:"last_scan"`
+}
+
+// libraryFile is a video file found in a library directory.
+type libraryFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type matchedLibraryFile struct {
+	libraryFile
+	movieID int
+}
+
+func NewLibraryService(directories []string, scanInterval time.Duration, db *gorm.DB, movieService *MovieService) *LibraryService {
+	ls := &LibraryService{
+		directories:  directories,
+		scanInterval: scanInterval,
+		db:           db,
+		movieService: movieService,
+		unmatched:    make(map[string]time.Time),
+	}
+
+	if len(directories) > 0 {
+		go ls.scanWorker()
+	}
+
+	return ls
+}
+
+func (ls *LibraryService) scanWorker() {
+	ls.Scan()
+
+	if ls.scanInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(ls.scanInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ls.Scan()
+	}
+}
+
+// LastScan returns the result of the latest library scan.
+func (ls *LibraryService) LastScan() LibraryScanResult {
+	ls.lastScanMu.RLock()
+	defer ls.lastScanMu.RUnlock()
+
+	return ls.lastScan
+}
+
+// Status returns whether a library scan is running and the result of the
+// latest one.
+func (ls *LibraryService) Status() LibraryScanStatus {
+	ls.lastScanMu.RLock()
+	defer ls.lastScanMu.RUnlock()
+
+	return LibraryScanStatus{Running: ls.scanning, LastScan: ls.lastScan}
+}
+
+// StartScan scans the library in the background, unless a scan is already
+// running, and returns the scan status.
+func (ls *LibraryService) StartScan() LibraryScanStatus {
+	ls.lastScanMu.Lock()
+	defer ls.lastScanMu.Unlock()
+
+	if !ls.scanning {
+		ls.scanning = true
+		go ls.Scan()
+	}
+
+	return LibraryScanStatus{Running: true, LastScan: ls.lastScan}
+}
+
+// Scan walks the library directories and brings the library entries of the
+// database in line with the files found: new files are matched and added as
+// sources of their movie, moved files have their path updated and deleted
+// files are removed.
+func (ls *LibraryService) Scan() LibraryScanResult {
+	ls.scanMu.Lock()
+	defer ls.scanMu.Unlock()
+
+	ls.lastScanMu.Lock()
+	ls.scanning = true
+	ls.lastScanMu.Unlock()
+
+	result := LibraryScanResult{
+		StartedAt: time.Now(),
+		Unmatched: []string{},
+		Errors:    []string{},
+	}
+
+	files := ls.walk(&result)
+	result.Files = len(files)
+
+	var entries []models.DownloadedMovie
+	if err := ls.db.Where("source = ?", "library").Find(&entries).Error; err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("failed to load library entries: %v", err))
+		return ls.finishScan(result)
+	}
+
+	known := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		known[entry.FilePath] = true
+	}
+
+	// Files already registered need no lookup; the others are matched and
+	// either replace an entry of the same movie whose file is gone, or
+	// become a new source of the movie.
+	var found []matchedLibraryFile
+	for _, f := range files {
+		if known[f.path] {
+			continue
+		}
+
+		if modTime, ok := ls.unmatched[f.path]; ok && modTime.Equal(f.modTime) {
+			result.Unmatched = append(result.Unmatched, f.path)
+			continue
+		}
+
+		movieID, err := ls.matchFile(f.path)
+		if err != nil {
+			if errors.Is(err, errNoLibraryMatch) {
+				ls.unmatched[f.path] = f.modTime
+			}
+			result.Unmatched = append(result.Unmatched, f.path)
+			Logger.Warn(fmt.Sprintf("Library file %s not matched: %v", f.path, err))
+			continue
+		}
+		delete(ls.unmatched, f.path)
+
+		found = append(found, matchedLibraryFile{libraryFile: f, movieID: movieID})
+	}
+
+	for _, entry := range entries {
+		if _, err := os.Stat(entry.FilePath); err == nil {
+			continue
+		}
+
+		if i := movedLibraryFile(found, entry); i >= 0 {
+			f := found[i]
+			if err := ls.db.Model(&entry).Updates(map[string]interface{}{
+				"file_path": f.path,
+				"file_size": f.size,
+			}).Error; err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("failed to update %s: %v", f.path, err))
+			} else {
+				Logger.Info(fmt.Sprintf("Library movie %d moved from %s to %s", entry.MovieID, entry.FilePath, f.path))
+				result.Moved++
+			}
+			found = append(found[:i], found[i+1:]...)
+			continue
+		}
+
+		if err := ls.db.Delete(&entry).Error; err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("failed to remove %s: %v", entry.FilePath, err))
+			continue
+		}
+		Logger.Info(fmt.Sprintf("Library movie %d removed, %s no longer exists", entry.MovieID, entry.FilePath))
+		result.Removed++
+	}
+
+	for _, f := range found {
+		entry := models.DownloadedMovie{
+			MovieID:      f.movieID,
+			Quality:      release.Parse(filepath.Base(f.path)).Quality(),
+			FilePath:     f.path,
+			FileSize:     f.size,
+			Source:       "library",
+			DownloadedAt: f.modTime,
+			LastWatched:  time.Now(),
+		}
+		if err := ls.db.Create(&entry).Error; err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("failed to add %s: %v", f.path, err))
+			continue
+		}
+		Logger.Info(fmt.Sprintf("Library movie %d added from %s", f.movieID, f.path))
+		result.Added++
+	}
+
+	return ls.finishScan(result)
+}
+
+// movedLibraryFile returns the index of the new file an entry whose file is
+// gone was moved to: a file of the same movie and size, -1 when there is none.
+func movedLibraryFile(found []matchedLibraryFile, entry models.DownloadedMovie) int {
+	for i, f := range found {
+		if f.movieID == entry.MovieID && f.size == entry.FileSize {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ls *LibraryService) finishScan(result LibraryScanResult) LibraryScanResult {
+	result.FinishedAt = time.Now()
+
+	ls.lastScanMu.Lock()
+	ls.lastScan = result
+	ls.scanning = false
+	ls.lastScanMu.Unlock()
+
+	Logger.Info(fmt.Sprintf("Library scan done: %d file(s), %d added, %d moved, %d removed, %d unmatched",
+		result.Files, result.Added, result.Moved, result.Removed, len(result.Unmatched)))
+
+	return result
+}
+
+func (ls *LibraryService) walk(result *LibraryScanResult) []libraryFile {
+	var files []libraryFile
+
+	for _, dir := range ls.directories {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				result.Errors = append(result.Errors, err.Error())
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || !isLibraryVideo(path) {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				result.Errors = append(result.Errors, err.Error())
+				return nil
+			}
+
+			files = append(files, libraryFile{path: path, size: info.Size(), modTime: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("failed to scan %s: %v", dir, err))
+		}
+	}
+
+	return files
+}
+
+func isLibraryVideo(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, videoExt := range libraryVideoExts {
+		if ext == videoExt {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFile finds the TMDB ID of a library file, from a tmdb tag in its name
+// or its folder's name, otherwise by searching TMDB for the title and year.
+func (ls *LibraryService) matchFile(path string) (int, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	folder := filepath.Base(filepath.Dir(path))
+
+	for _, candidate := range []string{name, folder} {
+		if m := tmdbIDTag.FindStringSubmatch(candidate); m != nil {
+			return strconv.Atoi(m[1])
+		}
+	}
+
+	for _, candidate := range []string{name, folder} {
+		info := release.Parse(tmdbIDTag.ReplaceAllString(candidate, " "))
+		if info.Title == "" {
+			continue
+		}
+
+		year := ""
+		if info.Year != 0 {
+			year = strconv.Itoa(info.Year)
+		}
+
+		movies, err := ls.movieService.SearchMovies(info.Title, year)
+		if err != nil {
+			return 0, fmt.Errorf("tmdb search failed: %w", err)
+		}
+		if len(movies) > 0 {
+			return movies[0].ID, nil
+		}
+	}
+
+	return 0, errNoLibraryMatch
+}
@@ -0,0 +1,477 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"server/internal/models"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxListTitle       = 200
+	maxListDescription = 2000
+	maxListItems       = 500
+	maxListSlugTitle   = 60
+
+	defaultPublicListsLimit = 20
+)
+
+var (
+	ErrListNotFound        = errors.New("list not found")
+	ErrListForbidden       = errors.New("you are not allowed to change this list")
+	ErrInvalidList         = errors.New("invalid list")
+	ErrListFull            = errors.New("the list is full")
+	ErrInvalidListOrder    = errors.New("the order must list every movie of the list once")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrInvalidCollaborator = errors.New("the owner of a list cannot be one of its collaborators")
+)
+
+// ListRequest holds the editable fields of a list. Fields left empty keep
+// their value on update.
+type ListRequest struct {
+	Title       string `json:"title" example:"Halloween marathon"`
+	Description string `json:"description" example:"Scary movies for the 31st"`
+	Privacy     string `json:"privacy" example:"unlisted" enums:"private,unlisted,public"`
+}
+
+type ListService struct {
+	db           *gorm.DB
+	movieService *MovieService
+}
+
+func NewListService(db *gorm.DB, ms *MovieService) *ListService {
+	return &ListService{
+		db:           db,
+		movieService: ms,
+	}
+}
+
+// CanRead reports whether a user, nil when anonymous, may read a list from
+// its ID: public lists and the lists they own or collaborate on. Unlisted
+// lists are only readable from their slug, which cannot be guessed.
+func CanRead(list models.MovieList, userID *uint) bool {
+	if list.Privacy == models.ListPublic {
+		return true
+	}
+	return userID != nil && CanEdit(list, *userID)
+}
+
+// CanReadBySlug reports whether a user, nil when anonymous, may read a list
+// from its slug. Unlisted lists are readable by anyone who knows it.
+func CanReadBySlug(list models.MovieList, userID *uint) bool {
+	if list.Privacy == models.ListUnlisted {
+		return true
+	}
+	return CanRead(list, userID)
+}
+
+// CanEdit reports whether a user may change the movies of a list.
+func CanEdit(list models.MovieList, userID uint) bool {
+	if list.UserID == userID {
+		return true
+	}
+	for _, collaborator := range list.Collaborators {
+		if collaborator.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Create creates a list owned by a user.
+func (ls *ListService) Create(userID uint, req ListRequest) (models.MovieList, error) {
+	if req.Privacy == "" {
+		req.Privacy = models.ListPrivate
+	}
+	if err := validateList(req); err != nil {
+		return models.MovieList{}, err
+	}
+
+	slug, err := listSlug(req.Title)
+	if err != nil {
+		return models.MovieList{}, err
+	}
+
+	list := models.MovieList{
+		UserID:      userID,
+		Slug:        slug,
+		Title:       strings.TrimSpace(req.Title),
+		Description: strings.TrimSpace(req.Description),
+		Privacy:     req.Privacy,
+	}
+	if err := ls.db.Create(&list).Error; err != nil {
+		return models.MovieList{}, err
+	}
+
+	return ls.load(ls.db.Where("id = ?", list.ID))
+}
+
+// Lists returns the lists a user owns or collaborates on, most recently
+// updated first.
+func (ls *ListService) Lists(userID uint) ([]models.MovieList, error) {
+	lists := []models.MovieList{}
+	err := ls.preload(ls.db).
+		Where("user_id = ? OR id IN (?)", userID,
+			ls.db.Model(&models.MovieListCollaborator{}).Select("list_id").Where("user_id = ?", userID)).
+		Order("updated_at DESC").
+		Find(&lists).Error
+
+	return lists, err
+}
+
+// PublicLists returns the public lists, most recently updated first.
+func (ls *ListService) PublicLists(limit, offset int) ([]models.MovieList, error) {
+	if limit <= 0 {
+		limit = defaultPublicListsLimit
+	}
+
+	lists := []models.MovieList{}
+	err := ls.preload(ls.db).
+		Where("privacy = ?", models.ListPublic).
+		Order("updated_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&lists).Error
+
+	return lists, err
+}
+
+// Get returns a list a user may read.
+func (ls *ListService) Get(userID *uint, listID uint) (models.MovieList, error) {
+	list, err := ls.load(ls.db.Where("id = ?", listID))
+	if err != nil {
+		return models.MovieList{}, err
+	}
+	if !CanRead(list, userID) {
+		return models.MovieList{}, ErrListNotFound
+	}
+	return list, nil
+}
+
+// BySlug returns a list from its slug, for the user, nil when anonymous, who
+// reads it.
+func (ls *ListService) BySlug(userID *uint, slug string) (models.MovieList, error) {
+	list, err := ls.load(ls.db.Where("slug = ?", slug))
+	if err != nil {
+		return models.MovieList{}, err
+	}
+	if !CanReadBySlug(list, userID) {
+		return models.MovieList{}, ErrListNotFound
+	}
+	return list, nil
+}
+
+// Update changes the title, description or privacy of a list. Only its
+// owner may.
+func (ls *ListService) Update(userID, listID uint, req ListRequest) (models.MovieList, error) {
+	list, err := ls.owned(userID, listID)
+	if err != nil {
+		return models.MovieList{}, err
+	}
+
+	if req.Title != "" {
+		list.Title = strings.TrimSpace(req.Title)
+	}
+	if req.Description != "" {
+		list.Description = strings.TrimSpace(req.Description)
+	}
+	if req.Privacy != "" {
+		list.Privacy = req.Privacy
+	}
+	if err := validateList(ListRequest{Title: list.Title, Description: list.Description, Privacy: list.Privacy}); err != nil {
+		return models.MovieList{}, err
+	}
+
+	err = ls.db.Model(&list).Updates(map[string]interface{}{
+		"title":       list.Title,
+		"description": list.Description,
+		"privacy":     list.Privacy,
+	}).Error
+	if err != nil {
+		return models.MovieList{}, err
+	}
+
+	return ls.load(ls.db.Where("id = ?", listID))
+}
+
+// Delete deletes a list with its movies. Only its owner may.
+func (ls *ListService) Delete(userID, listID uint) error {
+	if _, err := ls.owned(userID, listID); err != nil {
+		return err
+	}
+
+	return ls.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("list_id = ?", listID).Delete(&models.MovieListItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("list_id = ?", listID).Delete(&models.MovieListCollaborator{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.MovieList{}, listID).Error
+	})
+}
+
+// AddItem adds a movie at the end of a list. A movie already in the list
+// keeps its place.
+func (ls *ListService) AddItem(userID, listID uint, movieID int) (models.MovieList, error) {
+	list, err := ls.editable(userID, listID)
+	if err != nil {
+		return models.MovieList{}, err
+	}
+	for _, item := range list.Items {
+		if item.MovieID == movieID {
+			return list, nil
+		}
+	}
+	if len(list.Items) >= maxListItems {
+		return models.MovieList{}, ErrListFull
+	}
+
+	details := ls.movieService.watchedMovieDetails(movieID)
+	if details == nil {
+		return models.MovieList{}, ErrMovieNotFound
+	}
+
+	position := 0
+	if len(list.Items) > 0 {
+		position = list.Items[len(list.Items)-1].Position + 1
+	}
+
+	err = ls.db.Transaction(func(tx *gorm.DB) error {
+		item := models.MovieListItem{
+			ListID:     listID,
+			MovieID:    movieID,
+			MovieTitle: details.Title,
+			PosterPath: details.PosterPath,
+			Position:   position,
+			AddedBy:    userID,
+		}
+		// A collaborator may have added the movie meanwhile.
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&item).Error; err != nil {
+			return err
+		}
+		return touchList(tx, listID)
+	})
+	if err != nil {
+		return models.MovieList{}, err
+	}
+
+	return ls.load(ls.db.Where("id = ?", listID))
+}
+
+// RemoveItem removes a movie from a list.
+func (ls *ListService) RemoveItem(userID, listID uint, movieID int) (models.MovieList, error) {
+	if _, err := ls.editable(userID, listID); err != nil {
+		return models.MovieList{}, err
+	}
+
+	err := ls.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("list_id = ? AND movie_id = ?", listID, movieID).Delete(&models.MovieListItem{}).Error; err != nil {
+			return err
+		}
+		return touchList(tx, listID)
+	})
+	if err != nil {
+		return models.MovieList{}, err
+	}
+
+	return ls.load(ls.db.Where("id = ?", listID))
+}
+
+// ReorderItems orders a list as the given movies, which must be every movie
+// of the list.
+func (ls *ListService) ReorderItems(userID, listID uint, movieIDs []int) (models.MovieList, error) {
+	if _, err := ls.editable(userID, listID); err != nil {
+		return models.MovieList{}, err
+	}
+
+	err := ls.db.Transaction(func(tx *gorm.DB) error {
+		var items []models.MovieListItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("list_id = ?", listID).
+			Find(&items).Error; err != nil {
+			return err
+		}
+		if len(items) != len(movieIDs) {
+			return ErrInvalidListOrder
+		}
+
+		current := make(map[int]bool, len(items))
+		for _, item := range items {
+			current[item.MovieID] = true
+		}
+		for _, movieID := range movieIDs {
+			if !current[movieID] {
+				return ErrInvalidListOrder
+			}
+			delete(current, movieID)
+		}
+
+		for position, movieID := range movieIDs {
+			if err := tx.Model(&models.MovieListItem{}).
+				Where("list_id = ? AND movie_id = ?", listID, movieID).
+				Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return touchList(tx, listID)
+	})
+	if err != nil {
+		return models.MovieList{}, err
+	}
+
+	return ls.load(ls.db.Where("id = ?", listID))
+}
+
+// AddCollaborator invites a user to edit the movies of a list. Only its
+// owner may.
+func (ls *ListService) AddCollaborator(userID, listID uint, username string) (models.MovieList, error) {
+	if _, err := ls.owned(userID, listID); err != nil {
+		return models.MovieList{}, err
+	}
+
+	var user models.User
+	err := ls.db.Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.MovieList{}, ErrUserNotFound
+	}
+	if err != nil {
+		return models.MovieList{}, err
+	}
+	if user.ID == userID {
+		return models.MovieList{}, ErrInvalidCollaborator
+	}
+
+	collaborator := models.MovieListCollaborator{ListID: listID, UserID: user.ID}
+	if err := ls.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&collaborator).Error; err != nil {
+		return models.MovieList{}, err
+	}
+
+	return ls.load(ls.db.Where("id = ?", listID))
+}
+
+// RemoveCollaborator removes a collaborator from a list. The owner may remove
+// anyone, a collaborator only themselves.
+func (ls *ListService) RemoveCollaborator(userID, listID uint, username string) error {
+	list, err := ls.load(ls.db.Where("id = ?", listID))
+	if err != nil {
+		return err
+	}
+	if !CanEdit(list, userID) {
+		return ErrListNotFound
+	}
+
+	for _, collaborator := range list.Collaborators {
+		if collaborator.User.Username != username {
+			continue
+		}
+		if list.UserID != userID && collaborator.UserID != userID {
+			return ErrListForbidden
+		}
+		return ls.db.Delete(&collaborator).Error
+	}
+
+	return ErrUserNotFound
+}
+
+// owned returns a list only its owner may change. Lists a user cannot read
+// are reported as not found.
+func (ls *ListService) owned(userID, listID uint) (models.MovieList, error) {
+	list, err := ls.editable(userID, listID)
+	if err != nil {
+		return models.MovieList{}, err
+	}
+	if list.UserID != userID {
+		return models.MovieList{}, ErrListForbidden
+	}
+	return list, nil
+}
+
+// editable returns a list whose movies a user may change.
+func (ls *ListService) editable(userID, listID uint) (models.MovieList, error) {
+	list, err := ls.load(ls.db.Where("id = ?", listID))
+	if err != nil {
+		return models.MovieList{}, err
+	}
+	if !CanEdit(list, userID) {
+		if CanRead(list, &userID) {
+			return models.MovieList{}, ErrListForbidden
+		}
+		return models.MovieList{}, ErrListNotFound
+	}
+	return list, nil
+}
+
+func (ls *ListService) load(query *gorm.DB) (models.MovieList, error) {
+	var list models.MovieList
+	err := ls.preload(query).First(&list).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.MovieList{}, ErrListNotFound
+	}
+	return list, err
+}
+
+func (ls *ListService) preload(query *gorm.DB) *gorm.DB {
+	return query.
+		Preload("Owner").
+		Preload("Collaborators", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at ASC")
+		}).
+		Preload("Collaborators.User").
+		Preload("Items", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, created_at ASC")
+		})
+}
+
+func touchList(tx *gorm.DB, listID uint) error {
+	return tx.Model(&models.MovieList{}).Where("id = ?", listID).Update("updated_at", time.Now()).Error
+}
+
+func validateList(req ListRequest) error {
+	title := strings.TrimSpace(req.Title)
+	if title == "" || len(title) > maxListTitle || len(strings.TrimSpace(req.Description)) > maxListDescription {
+		return ErrInvalidList
+	}
+	switch req.Privacy {
+	case models.ListPrivate, models.ListUnlisted, models.ListPublic:
+		return nil
+	}
+	return ErrInvalidList
+}
+
+// listSlug builds a unique, readable slug from the title of a list. The
+// random suffix keeps unlisted lists from being guessed.
+func listSlug(title string) (string, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+		if b.Len() >= maxListSlugTitle {
+			break
+		}
+	}
+
+	base := strings.Trim(b.String(), "-")
+	if base == "" {
+		base = "list"
+	}
+	return base + "-" + hex.EncodeToString(suffix), nil
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"server/internal/models"
+	"testing"
+)
+
+func TestListPermissions(t *testing.T) {
+	owner, collaborator, stranger := uint(1), uint(2), uint(3)
+
+	tests := []struct {
+		privacy    string
+		userID     *uint
+		read       bool
+		readBySlug bool
+		edit       bool
+	}{
+		{privacy: models.ListPrivate, userID: &owner, read: true, readBySlug: true, edit: true},
+		{privacy: models.ListPrivate, userID: &collaborator, read: true, readBySlug: true, edit: true},
+		{privacy: models.ListPrivate, userID: &stranger},
+		{privacy: models.ListPrivate},
+		{privacy: models.ListUnlisted, userID: &owner, read: true, readBySlug: true, edit: true},
+		{privacy: models.ListUnlisted, userID: &collaborator, read: true, readBySlug: true, edit: true},
+		{privacy: models.ListUnlisted, userID: &stranger, readBySlug: true},
+		{privacy: models.ListUnlisted, readBySlug: true},
+		{privacy: models.ListPublic, userID: &owner, read: true, readBySlug: true, edit: true},
+		{privacy: models.ListPublic, userID: &stranger, read: true, readBySlug: true},
+		{privacy: models.ListPublic, read: true, readBySlug: true},
+	}
+
+	for _, tt := range tests {
+		list := models.MovieList{
+			UserID:        owner,
+			Privacy:       tt.privacy,
+			Collaborators: []models.MovieListCollaborator{{UserID: collaborator}},
+		}
+		user := "anonymous"
+		if tt.userID != nil {
+			user = map[uint]string{owner: "owner", collaborator: "collaborator", stranger: "stranger"}[*tt.userID]
+		}
+
+		if got := CanRead(list, tt.userID); got != tt.read {
+			t.Errorf("CanRead(%s list, %s) = %v, want %v", tt.privacy, user, got, tt.read)
+		}
+		if got := CanReadBySlug(list, tt.userID); got != tt.readBySlug {
+			t.Errorf("CanReadBySlug(%s list, %s) = %v, want %v", tt.privacy, user, got, tt.readBySlug)
+		}
+		if tt.userID == nil {
+			continue
+		}
+		if got := CanEdit(list, *tt.userID); got != tt.edit {
+			t.Errorf("CanEdit(%s list, %s) = %v, want %v", tt.privacy, user, got, tt.edit)
+		}
+	}
+}
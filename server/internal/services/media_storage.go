@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MediaStorage keeps finished media under slash-separated keys, in a local
+// directory or in an S3-compatible bucket.
+type MediaStorage interface {
+	// Name identifies the backend, "local" or "s3".
+	Name() string
+	// Put stores the file at localPath under key.
+	Put(ctx context.Context, key, localPath string) error
+	// Get reads the object stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists reports whether an object is stored under key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// URL returns a URL clients can fetch the object from directly, or an
+	// empty string when it must go through the API.
+	URL(ctx context.Context, key string) (string, error)
+	// DeletePrefix removes every object under the prefix directory.
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+var mediaStorage MediaStorage
+
+func LoadMediaStorage() {
+	conf := Conf.MEDIA_STORAGE
+
+	switch conf.Backend {
+	case "", "local":
+		dir := conf.Directory
+		if dir == "" {
+			dir = "media"
+		}
+		store, err := NewLocalMediaStorage(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mediaStorage = store
+	case "s3":
+		store, err := NewS3MediaStorage(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+		mediaStorage = store
+	default:
+		log.Fatalf("unknown media storage backend %q", conf.Backend)
+	}
+}
+
+func MediaStore() MediaStorage {
+	return mediaStorage
+}
+
+// MediaContentType returns the content type stored with a media file.
+func MediaContentType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".vtt":
+		return "text/vtt"
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// putMediaDir stores the files under dir with keys under prefix, skipping the
+// directories named in skip. It returns the number of bytes stored.
+func putMediaDir(ctx context.Context, store MediaStorage, dir, prefix string, skip ...string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			for _, name := range skip {
+				if rel == name {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		// ffmpeg writes segments to .tmp files before renaming them.
+		if strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := store.Put(ctx, path.Join(prefix, filepath.ToSlash(rel)), p); err != nil {
+			return fmt.Errorf("failed to store %s: %w", rel, err)
+		}
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
+
+// fetchMedia copies the object stored under key to localPath.
+func fetchMedia(ctx context.Context, store MediaStorage, key, localPath string) error {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+
+	tmp := localPath + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, localPath)
+}
+
+// LocalMediaStorage stores media in a directory, e.g. a mounted NAS. Files
+// are served by the API.
+type LocalMediaStorage struct {
+	root string
+}
+
+func NewLocalMediaStorage(root string) (*LocalMediaStorage, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media storage directory: %w", err)
+	}
+	return &LocalMediaStorage{root: root}, nil
+}
+
+func (s *LocalMediaStorage) Name() string {
+	return "local"
+}
+
+func (s *LocalMediaStorage) path(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(key))
+	if !withinDir(s.root, p) {
+		return "", fmt.Errorf("invalid media key %q", key)
+	}
+	return p, nil
+}
+
+func (s *LocalMediaStorage) Put(_ context.Context, key, localPath string) error {
+	dest, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if dest == filepath.Clean(localPath) {
+		return nil
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	tmp := dest + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+func (s *LocalMediaStorage) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *LocalMediaStorage) Exists(_ context.Context, key string) (bool, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *LocalMediaStorage) URL(context.Context, string) (string, error) {
+	return "", nil
+}
+
+func (s *LocalMediaStorage) DeletePrefix(_ context.Context, prefix string) error {
+	p, err := s.path(prefix)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"server/internal/models"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// memoryMediaStorage records what is stored, by key.
+type memoryMediaStorage struct {
+	MediaStorage
+	objects map[string]string // key -> local path
+}
+
+func (s *memoryMediaStorage) Put(_ context.Context, key, localPath string) error {
+	s.objects[key] = localPath
+	return nil
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testMediaStorage checks the behavior every backend shares.
+func testMediaStorage(t *testing.T, store MediaStorage) {
+	ctx := context.Background()
+	src := filepath.Join(t.TempDir(), "segment_000.ts")
+	writeTestFile(t, src, "segment")
+
+	for _, key := range []string{"hls/603/default/720p/segment_000.ts", "hls/603/default/master.m3u8", "hls/604/default/master.m3u8"} {
+		if err := store.Put(ctx, key, src); err != nil {
+			t.Fatalf("Put(%s): %v", key, err)
+		}
+	}
+
+	body, err := store.Get(ctx, "hls/603/default/720p/segment_000.ts")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "segment" {
+		t.Errorf("Get read %q, %v, want %q", data, err, "segment")
+	}
+
+	if ok, err := store.Exists(ctx, "hls/603/default/master.m3u8"); !ok || err != nil {
+		t.Errorf("Exists of a stored key = %v, %v", ok, err)
+	}
+	if ok, err := store.Exists(ctx, "hls/603/default/missing.m3u8"); ok || err != nil {
+		t.Errorf("Exists of a missing key = %v, %v", ok, err)
+	}
+
+	if err := store.DeletePrefix(ctx, "hls/603"); err != nil {
+		t.Fatalf("DeletePrefix: %v", err)
+	}
+	for key, want := range map[string]bool{
+		"hls/603/default/720p/segment_000.ts": false,
+		"hls/603/default/master.m3u8":         false,
+		"hls/604/default/master.m3u8":         true,
+	} {
+		if ok, err := store.Exists(ctx, key); ok != want || err != nil {
+			t.Errorf("after DeletePrefix, Exists(%s) = %v, %v, want %v", key, ok, err, want)
+		}
+	}
+}
+
+func TestLocalMediaStorage(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "media")
+	store, err := NewLocalMediaStorage(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMediaStorage(t, store)
+
+	// Keys cannot leave the storage directory, nor name it as a whole.
+	outside := filepath.Join(filepath.Dir(root), "outside")
+	writeTestFile(t, outside, "secret")
+	src := filepath.Join(t.TempDir(), "file")
+	writeTestFile(t, src, "data")
+
+	for _, key := range []string{"../outside", "hls/../../outside", "..", "", "."} {
+		if err := store.Put(context.Background(), key, src); err == nil {
+			t.Errorf("Put(%q) succeeded", key)
+		}
+		if body, err := store.Get(context.Background(), key); err == nil {
+			body.Close()
+			t.Errorf("Get(%q) succeeded", key)
+		}
+		if err := store.DeletePrefix(context.Background(), key); err == nil {
+			t.Errorf("DeletePrefix(%q) succeeded", key)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("file outside the storage was touched: %v", err)
+	}
+
+	// Storing a file where it already is keeps it.
+	inPlace := filepath.Join(root, "exports", "603.mp4")
+	writeTestFile(t, inPlace, "movie")
+	if err := store.Put(context.Background(), "exports/603.mp4", inPlace); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(inPlace); err != nil || string(data) != "movie" {
+		t.Errorf("file stored in place reads %q, %v", data, err)
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "/media/hls/603", want: true},
+		{path: "/media/..hidden", want: true},
+		{path: "/media"},
+		{path: "/media/"},
+		{path: "/media/.."},
+		{path: "/media/../etc/passwd"},
+		{path: "/mediaX/file"},
+		{path: "/etc/passwd"},
+	}
+
+	for _, tt := range tests {
+		if got := withinDir("/media", tt.path); got != tt.want {
+			t.Errorf("withinDir(/media, %s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPutMediaDir(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "master.m3u8"), "master")
+	writeTestFile(t, filepath.Join(dir, "720p", "playlist.m3u8"), "playlist")
+	writeTestFile(t, filepath.Join(dir, "720p", "segment_000.ts"), "seg")
+	writeTestFile(t, filepath.Join(dir, "720p", "segment_001.ts.tmp"), "partial")
+	writeTestFile(t, filepath.Join(dir, "sources", "12", "master.m3u8"), "source")
+	writeTestFile(t, filepath.Join(dir, "subtitles", "en.vtt"), "subtitles")
+
+	store := &memoryMediaStorage{objects: map[string]string{}}
+	size, err := putMediaDir(context.Background(), store, dir, "hls/603/default", "sources")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for key := range store.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	want := []string{
+		"hls/603/default/720p/playlist.m3u8",
+		"hls/603/default/720p/segment_000.ts",
+		"hls/603/default/master.m3u8",
+		"hls/603/default/subtitles/en.vtt",
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("stored %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("stored %v, want %v", keys, want)
+			break
+		}
+	}
+	if wantSize := int64(len("master") + len("playlist") + len("seg") + len("subtitles")); size != wantSize {
+		t.Errorf("stored %d bytes, want %d", size, wantSize)
+	}
+}
+
+func TestPublishedFile(t *testing.T) {
+	ms := &MovieService{}
+	key := StreamKey{MovieID: 603}
+	ms.publishedStreams.Store(key, models.PublishedStream{Prefix: "hls/603/default"})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "master.m3u8", want: "hls/603/default/master.m3u8"},
+		{name: "720p/segment_000.ts", want: "hls/603/default/720p/segment_000.ts"},
+		{name: "/720p/playlist.m3u8", want: "hls/603/default/720p/playlist.m3u8"},
+		{name: "720p/../master.m3u8", want: "hls/603/default/master.m3u8"},
+		{name: "../../604/default/master.m3u8", want: "hls/603/default/604/default/master.m3u8"},
+		{name: "../../../secret", want: "hls/603/default/secret"},
+	}
+
+	for _, tt := range tests {
+		got, ok := ms.PublishedFile(key, tt.name)
+		if !ok || got != tt.want {
+			t.Errorf("PublishedFile(%q) = %q, %v, want %q", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+// TestS3MediaStorage runs against the MinIO service of docker-compose.yml, as
+// make test-s3 does:
+//
+//	docker compose --profile minio up -d minio
+//	MEDIA_STORAGE_TEST_S3_ENDPOINT=http://localhost:9000 go test ./internal/services -run S3
+func TestS3MediaStorage(t *testing.T) {
+	endpoint := os.Getenv("MEDIA_STORAGE_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MEDIA_STORAGE_TEST_S3_ENDPOINT is not set")
+	}
+
+	saved := Conf.MEDIA_STORAGE
+	defer func() { Conf.MEDIA_STORAGE = saved }()
+
+	conf := &Conf.MEDIA_STORAGE
+	conf.S3.Endpoint = endpoint
+	conf.S3.Region = "us-east-1"
+	conf.S3.Bucket = "hypertube-test"
+	conf.S3.Prefix = "test-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	conf.S3.AccessKeyID = envOr("MEDIA_STORAGE_TEST_S3_ACCESS_KEY_ID", "minioadmin")
+	conf.S3.SecretAccessKey = envOr("MEDIA_STORAGE_TEST_S3_SECRET_ACCESS_KEY", "minioadmin")
+	conf.S3.UsePathStyle = true
+	conf.Serve = "redirect"
+
+	store, err := NewS3MediaStorage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.client.CreateBucket(context.Background(), &s3.CreateBucketInput{Bucket: aws.String(conf.S3.Bucket)})
+	var owned *types.BucketAlreadyOwnedByYou
+	if err != nil && !errors.As(err, &owned) {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+	defer store.DeletePrefix(context.Background(), "hls")
+
+	testMediaStorage(t, store)
+
+	url, err := store.URL(context.Background(), "hls/604/default/master.m3u8")
+	if err != nil || url == "" {
+		t.Errorf("URL = %q, %v, want a presigned URL", url, err)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
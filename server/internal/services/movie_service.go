@@ -26,10 +26,15 @@ type MovieService struct {
 	client             *http.Client
 	torrentSources     []string
 	genreCacheTime     time.Time
-	StreamStatus       sync.Map // map[int]map[string]interface{}
-	MasterPlaylists    sync.Map // map[int]*m3u8.MasterPlaylist - movieID -> master playlist
+	StreamStatus       sync.Map // map[StreamKey]map[string]interface{}
+	MasterPlaylists    sync.Map // map[StreamKey]*m3u8.MasterPlaylist - stream -> master playlist
 	LastSegmentCache   sync.Map // map[int]string - movieID -> last segment filename
-	UserWatchedMovies  sync.Map // map[string]map[int]string - "userID:movieID" -> last segment visited
+	pendingProgress    sync.Map // map[watchKey]watchProgress - playback progress waiting to be persisted
+	playerProgress     sync.Map // map[watchKey]time.Time - last progress reported by the player
+	streamDurations    sync.Map // map[StreamKey]int - duration in seconds of finished streams
+	historyMu          sync.Mutex
+	StreamAccess       sync.Map // map[StreamKey]time.Time - stream -> last file served
+	publishedStreams   sync.Map // map[StreamKey]models.PublishedStream
 	SegmentFormatParse string
 	SearchSources      map[string]Source
 	db                 *gorm.DB
@@ -59,8 +64,11 @@ func NewMovieService(tmdbKey, omdbKey, watchModeKey string, db *gorm.DB, ws *Web
 		// "omdb": NewOMDB(omdbKey, ms.genreCacheTime, ms.client),
 	}
 
+	if torrentService != nil {
+		torrentService.SetTranscodingCheck(ms.Transcoding)
+	}
+
 	go ms.persistWatchHistoryWorker()
-	go ms.cleanupOldHLSFilesWorker(Conf.STREAMING.HLSOutputDir)
 
 	return ms
 }
@@ -79,7 +87,9 @@ func (ms *MovieService) DiscoverMovies(p MovieDiscoverParams, userID *uint, sour
 	var movieIDs []int
 	var err error
 
-	if source != "" {
+	if p.Sort == SortCommunityRating {
+		movies, movieIDs, err = ms.discoverRated(p)
+	} else if source != "" {
 		src, err := ms.GetSource(source)
 		if err == nil {
 			movies, movieIDs, err = src.DiscoverMovies(p)
@@ -107,13 +117,15 @@ func (ms *MovieService) DiscoverMovies(p MovieDiscoverParams, userID *uint, sour
 		err = ms.db.Model(&models.WatchHistory{}).
 			Where("user_id = ?", userID).
 			Where("movie_id IN ?", movieIDs).
-			// Where("watch_count > ?", 0).
+			Where("watch_count > ?", 0).
 			Find(&watchHistory).Error
 
 		for _, wh := range watchHistory {
 			watchHistoryMap[wh.MovieID] = true
 		}
 	}
+	watchlist := ms.watchlistSet(userID, movieIDs)
+	ratings := ms.communityRatings(movieIDs)
 
 	for _, m := range movies {
 		isWatched := false
@@ -130,6 +142,9 @@ func (ms *MovieService) DiscoverMovies(p MovieDiscoverParams, userID *uint, sour
 			VoteAverage: m.VoteAverage,
 			GenreIDs:    m.GenreIDs,
 			IsWatched:   isWatched,
+			InWatchlist: watchlist[m.ID],
+			Community:   ratings[m.ID].Average,
+			RatingCount: ratings[m.ID].Count,
 		})
 	}
 
@@ -209,86 +224,71 @@ func (ms *MovieService) searchTorrentsByIMDb(movie models.MovieDetails, metadata
 	}
 
 	for _, t := range data.Streams {
-		results = append(results, TorrentSearchResult{InfoHash: t.InfoHash, Name: t.Title})
+		results = append(results, TorrentSearchResult{InfoHash: t.InfoHash, Name: t.Title, Seeders: parseSeeders(t.Title)})
 	}
 
 	return results, nil
 }
 
-func (ms *MovieService) TrackUserSegment(userID uint, movieID int) {
-	key := fmt.Sprintf("%d:%d", userID, movieID)
-	ms.UserWatchedMovies.Store(key, true)
+// TouchStream records that a file of a stream was just served, which keeps
+// the stream from being evicted while it is watched.
+func (ms *MovieService) TouchStream(key StreamKey) {
+	ms.StreamAccess.Store(key, time.Now())
 }
 
-func (ms *MovieService) persistWatchHistoryWorker() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		ms.UserWatchedMovies.Range(func(key, value interface{}) bool {
-			keyStr := key.(string)
-
-			var userID uint
-			var movieID int
-
-			if _, err := fmt.Sscanf(keyStr, "%d:%d", &userID, &movieID); err != nil {
-				return true
-			}
-
-			var watchHistory models.WatchHistory
-			result := ms.db.Where("user_id = ? AND movie_id = ?", userID, movieID).First(&watchHistory)
-
-			if result.Error == gorm.ErrRecordNotFound {
-				watchHistory = models.WatchHistory{
-					UserID:     userID,
-					MovieID:    movieID,
-					WatchCount: 0,
-					WatchedAt:  time.Now(),
-				}
-
-				ms.db.Create(&watchHistory)
-			}
+// Transcoding reports whether any stream of a movie is being prepared.
+func (ms *MovieService) Transcoding(movieID int) bool {
+	transcoding := false
 
+	ms.StreamStatus.Range(func(k, value interface{}) bool {
+		key := k.(StreamKey)
+		status, ok := value.(map[string]interface{})
+		if key.MovieID != movieID || !ok {
 			return true
-		})
-	}
-}
+		}
+		if stage := status["stage"]; stage != "ready" && stage != "error" {
+			transcoding = true
+			return false
+		}
+		return true
+	})
 
-func (ms *MovieService) cleanupOldHLSFilesWorker(hlsDir string) {
-	ticker := time.NewTicker(4 * time.Second)
-	defer ticker.Stop()
+	return transcoding
+}
 
-	type MovieLastWatched struct {
-		MovieID   int
-		WatchedAt time.Time
+// StreamInUse reports whether any stream of a movie is being prepared or was
+// served within streamPinWindow.
+func (ms *MovieService) StreamInUse(movieID int) bool {
+	if ms.Transcoding(movieID) {
+		return true
 	}
 
-	for {
-		var oldMovies []MovieLastWatched
-		oneMonthAgo := time.Now().AddDate(0, -1, 0)
-
-		err := ms.db.Model(&models.WatchHistory{}).
-			Select("movie_id, MAX(watched_at) as watched_at").
-			Group("movie_id").
-			Having("MAX(watched_at) < ?", oneMonthAgo).
-			Find(&oldMovies).Error
-
-		if err != nil || len(oldMovies) == 0 {
-			return
+	inUse := false
+	ms.StreamAccess.Range(func(k, value interface{}) bool {
+		if k.(StreamKey).MovieID == movieID && time.Since(value.(time.Time)) < streamPinWindow {
+			inUse = true
+			return false
 		}
+		return true
+	})
 
-		for _, movie := range oldMovies {
-			movieHLSDir := filepath.Join(hlsDir, fmt.Sprintf("%d", movie.MovieID))
-			if _, err := os.Stat(movieHLSDir); err == nil {
-				os.RemoveAll(movieHLSDir)
-			}
-		}
+	return inUse
+}
 
-		<-ticker.C
+// ForgetStream drops what is kept in memory about a stream whose HLS output
+// was removed.
+func (ms *MovieService) ForgetStream(key StreamKey) {
+	ms.StreamStatus.Delete(key)
+	ms.MasterPlaylists.Delete(key)
+	ms.StreamAccess.Delete(key)
+	ms.streamDurations.Delete(key)
+	if key.SourceID == 0 {
+		ms.LastSegmentCache.Delete(key.MovieID)
 	}
 }
 
-func (ms *MovieService) EnsureMovieIsPartiallyDownloadedAndStartedTranscoding(movieID int, outputDir string) error {
+func (ms *MovieService) EnsureMovieIsPartiallyDownloadedAndStartedTranscoding(key StreamKey, outputDir string) error {
+	movieID := key.MovieID
 	var downloadedMovie models.DownloadedMovie
 	err := ms.db.Where("movie_id = ?", movieID).First(&downloadedMovie).Error
 	if err == nil && downloadedMovie.Transcoded {
@@ -298,87 +298,89 @@ func (ms *MovieService) EnsureMovieIsPartiallyDownloadedAndStartedTranscoding(mo
 	if err != nil {
 		Logger.Info(fmt.Sprintf("Movie %d is not downloaded", movieID))
 	}
-	Logger.Info(fmt.Sprintf("Checking if transcoding already started for movie %d", movieID))
+	Logger.Info(fmt.Sprintf("Checking if transcoding already started for %s", key))
 	initialStatus := map[string]interface{}{
 		"movieID": movieID,
 		"stage":   "initializing",
 		"message": "Stream initialization starting",
 	}
-	if _, loaded := ms.StreamStatus.LoadOrStore(movieID, initialStatus); !loaded {
-		Logger.Info(fmt.Sprintf("Starting transcoding for movie %d", movieID))
-		go ms.startMovieStream(movieID, outputDir)
+	if key.SourceID != 0 {
+		initialStatus["sourceID"] = key.SourceID
 	}
-	Logger.Info(fmt.Sprintf("Transcoding check completed for movie %d", movieID))
-	Logger.Info(fmt.Sprintf("Transcoding process initiated for movie %d", movieID))
+	if _, loaded := ms.StreamStatus.LoadOrStore(key, initialStatus); !loaded {
+		Logger.Info(fmt.Sprintf("Starting transcoding for %s", key))
+		go ms.startMovieStream(key, outputDir)
+	}
+	Logger.Info(fmt.Sprintf("Transcoding check completed for %s", key))
+	Logger.Info(fmt.Sprintf("Transcoding process initiated for %s", key))
 	return nil
 }
 
-func (ms *MovieService) updateStreamStatus(movieID int, stage string, message string, additionalData map[string]interface{}) {
+func (ms *MovieService) updateStreamStatus(key StreamKey, stage string, message string, additionalData map[string]interface{}) {
 	status := map[string]interface{}{
-		"movieID": movieID,
+		"movieID": key.MovieID,
 		"stage":   stage,
 		"message": message,
 	}
+	if key.SourceID != 0 {
+		status["sourceID"] = key.SourceID
+	}
 
 	for key, value := range additionalData {
 		status[key] = value
 	}
 
-	ms.StreamStatus.Store(movieID, status)
+	ms.StreamStatus.Store(key, status)
 
 	if ms.websocketService != nil {
-		ms.websocketService.UpdateStreamState(movieID, status)
+		ms.websocketService.UpdateStreamState(key.MovieID, status)
 	}
 }
 
-func (ms *MovieService) startMovieStream(movieID int, outputDir string) {
-	ms.updateStreamStatus(movieID, "initializing", "Starting movie stream", nil)
-	movieIDStr := fmt.Sprintf("%d", movieID)
-	hlsBaseDir := VideoTranscoderConf.Output.Directory
-	if Conf.STREAMING.HLSOutputDir != "" {
-		hlsBaseDir = Conf.STREAMING.HLSOutputDir
-	}
-	hlsOutputDir := filepath.Join(hlsBaseDir, movieIDStr)
+func (ms *MovieService) startMovieStream(key StreamKey, outputDir string) {
+	ms.updateStreamStatus(key, "initializing", "Starting movie stream", nil)
+	hlsOutputDir := filepath.Join(hlsBaseDir(), key.Dir())
 
-	srtFiles := ms.downloadMovieSubtitles(movieID)
+	srtFiles := ms.downloadMovieSubtitles(key)
 
-	ms.updateStreamStatus(movieID, "downloading", "Finding and downloading movie", nil)
-	activeDownload, err := ms.findAndDownloadMovie(movieID)
+	ms.updateStreamStatus(key, "downloading", "Finding and downloading movie", nil)
+	activeDownload, err := ms.findAndDownloadMovie(key)
 	if err != nil {
-		ms.updateStreamStatus(movieID, "error", "Failed to download movie: "+err.Error(), nil)
+		ms.updateStreamStatus(key, "error", "Failed to download movie: "+err.Error(), nil)
 		return
 	}
 
 	if err := os.MkdirAll(hlsOutputDir, 0755); err != nil {
-		ms.updateStreamStatus(movieID, "error", "Failed to create HLS output directory: "+err.Error(), nil)
+		ms.updateStreamStatus(key, "error", "Failed to create HLS output directory: "+err.Error(), nil)
 		return
 	}
 
 	filePath, videoFile, status, progress := ms.getTorrentMovieDetails(activeDownload)
-	ms.updateStreamStatus(movieID, "downloading", fmt.Sprintf("Downloading: %.1f%% complete", progress), map[string]interface{}{
+	ms.updateStreamStatus(key, "downloading", fmt.Sprintf("Downloading: %.1f%% complete", progress), map[string]interface{}{
 		"downloadProgress": progress,
 		"downloadStatus":   status,
 	})
 	ms.waitUntilVideoFileIsReady(activeDownload, filePath, videoFile, status)
 
 	if err := ms.convertSubtitlesToHLS(srtFiles, hlsOutputDir); err != nil {
-		ms.updateStreamStatus(movieID, "error", "Failed to convert subtitles to HLS: "+err.Error(), nil)
+		ms.updateStreamStatus(key, "error", "Failed to convert subtitles to HLS: "+err.Error(), nil)
 		return
 	}
 
 	masterPlaylist, err := ms.createMasterPlaylist(hlsOutputDir, srtFiles)
 	if err != nil {
-		ms.updateStreamStatus(movieID, "error", "Failed to create master playlist: "+err.Error(), nil)
+		ms.updateStreamStatus(key, "error", "Failed to create master playlist: "+err.Error(), nil)
 		return
 	}
 
-	ms.updateStreamStatus(movieID, "transcoding", "Converting video to HLS format", map[string]interface{}{
+	ms.updateStreamStatus(key, "transcoding", "Converting video to HLS format", map[string]interface{}{
 		"transcodingStatus": "in_progress",
 	})
-	ms.tryFFmpegTranscodingWithPlaylist(activeDownload, movieID, hlsOutputDir, masterPlaylist)
+	ms.tryFFmpegTranscodingWithPlaylist(activeDownload, key, hlsOutputDir, masterPlaylist)
 }
 
-func (ms *MovieService) downloadMovieSubtitles(movieID int) []string {
+func (ms *MovieService) downloadMovieSubtitles(key StreamKey) []string {
+	movieID := key.MovieID
 	if ms.subtitleService == nil {
 		Logger.Warn("Subtitle service not initialized, skipping subtitle download")
 		return []string{}
@@ -396,7 +398,7 @@ func (ms *MovieService) downloadMovieSubtitles(movieID int) []string {
 	}
 
 	Logger.Info(fmt.Sprintf("Downloading subtitles for movie %d from subdl.com", movieID))
-	downloadedCount := ms.subtitleService.DownloadSubtitles(movieID, dirPath)
+	downloadedCount := ms.subtitleService.DownloadSubtitles(movieID, dirPath, ms.knownReleaseName(key))
 	Logger.Info(fmt.Sprintf("Downloaded %d subtitle(s) for movie %d", downloadedCount, movieID))
 
 	srtFiles, err := FindFilesWithExtension(dirPath, "srt")
@@ -408,50 +410,60 @@ func (ms *MovieService) downloadMovieSubtitles(movieID int) []string {
 	return srtFiles
 }
 
-func (ms *MovieService) findAndDownloadMovie(movieID int) (*models.TorrentDownload, error) {
-	ms.updateStreamStatus(movieID, "searching", "Fetching movie information", map[string]interface{}{
-		"step": "fetch_details",
-	})
-
-	s, _ := ms.GetSource("tmdb")
-
-	details, err := s.GetIMDbID(movieID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch movie details: %w", err)
+// knownReleaseName returns the release name of the file a movie is or will
+// be streamed from, when it is known before the download starts.
+func (ms *MovieService) knownReleaseName(key StreamKey) string {
+	if key.SourceID != 0 {
+		if source, ok := ms.torrentService.Source(key.MovieID, key.SourceID); ok {
+			return source.Name()
+		}
+		return ""
 	}
 
-	ms.updateStreamStatus(movieID, "searching", "Searching torrent sources", map[string]interface{}{
-		"step":         "search_torrents",
-		"imdb_id":      details.IMDbID,
-		"title":        details.Title,
-		"release_date": details.ReleaseDate,
-	})
+	if override, ok := ms.torrentService.TorrentOverride(key.MovieID); ok {
+		return override.Name
+	}
 
-	torrents, err := ms.searchTorrentsByIMDb(*details, 30*time.Second)
-	if err != nil {
-		Logger.Error(fmt.Sprintf("Error searching torrents: %v", err))
-		return nil, fmt.Errorf("failed to search torrents: %w", err)
+	if source, ok := ms.torrentService.PreferredSource(key.MovieID); ok {
+		return source.Name()
 	}
 
-	ms.updateStreamStatus(movieID, "searching", fmt.Sprintf("Found %d torrent(s)", len(torrents)), map[string]interface{}{
-		"step":          "torrents_found",
-		"torrent_count": len(torrents),
-	})
+	return ""
+}
 
-	if len(torrents) == 0 {
-		return nil, fmt.Errorf("no suitable torrent found")
+func (ms *MovieService) findAndDownloadMovie(key StreamKey) (*models.TorrentDownload, error) {
+	movieID := key.MovieID
+
+	if key.SourceID != 0 {
+		download, ok := ms.torrentService.SourceDownload(movieID, key.SourceID)
+		if !ok {
+			return nil, fmt.Errorf("source %d of movie %d is not available", key.SourceID, movieID)
+		}
+		ms.updateStreamStatus(key, "downloading", "Source is available", map[string]interface{}{
+			"step":             "download_ready",
+			"downloadProgress": 100.0,
+			"fileName":         filepath.Base(download.FilePath),
+		})
+		return download, nil
 	}
 
-	bestTorrent := &torrents[0]
+	if download, ok := ms.torrentService.CompletedDownload(movieID); ok {
+		ms.updateStreamStatus(key, "downloading", "Movie is already available", map[string]interface{}{
+			"step":             "download_ready",
+			"downloadProgress": 100.0,
+			"fileName":         filepath.Base(download.FilePath),
+		})
+		return download, nil
+	}
 
-	ms.updateStreamStatus(movieID, "searching", "Selected best torrent", map[string]interface{}{
-		"step": "torrent_selected",
-		"name": bestTorrent.Name,
-	})
+	infoHash, err := ms.selectTorrent(key)
+	if err != nil {
+		return nil, err
+	}
 
-	download, err := ms.torrentService.GetOrStartDownload(movieID, bestTorrent.InfoHash)
+	download, err := ms.torrentService.GetOrStartDownload(movieID, infoHash)
 	if err != nil {
-		ms.updateStreamStatus(movieID, "error", "Failed to start download: "+err.Error(), nil)
+		ms.updateStreamStatus(key, "error", "Failed to start download: "+err.Error(), nil)
 		return nil, fmt.Errorf("failed to start download: %w", err)
 	}
 
@@ -470,7 +482,7 @@ func (ms *MovieService) findAndDownloadMovie(movieID int) (*models.TorrentDownlo
 		download.Mu.RUnlock()
 
 		if ready && filePath != "" {
-			ms.updateStreamStatus(movieID, "downloading", "Download ready for streaming", map[string]interface{}{
+			ms.updateStreamStatus(key, "downloading", "Download ready for streaming", map[string]interface{}{
 				"step":             "download_ready",
 				"downloadProgress": progress,
 				"fileName":         filepath.Base(filePath),
@@ -481,7 +493,7 @@ func (ms *MovieService) findAndDownloadMovie(movieID int) (*models.TorrentDownlo
 		progressChanged := progress-lastProgress >= 1.0
 		if progressChanged || waitCount%5 == 0 {
 			if progressChanged && progress > 0 {
-				ms.updateStreamStatus(movieID, "downloading", fmt.Sprintf("Downloading: %.1f%% complete", progress), map[string]interface{}{
+				ms.updateStreamStatus(key, "downloading", fmt.Sprintf("Downloading: %.1f%% complete", progress), map[string]interface{}{
 					"step":             "downloading",
 					"downloadProgress": progress,
 					"downloadStatus":   status,
@@ -495,6 +507,95 @@ func (ms *MovieService) findAndDownloadMovie(movieID int) (*models.TorrentDownlo
 	}
 }
 
+// selectTorrent returns the infohash to download a movie from. A torrent
+// attached by an administrator takes precedence over the torrent search.
+func (ms *MovieService) selectTorrent(key StreamKey) (string, error) {
+	movieID := key.MovieID
+
+	if override, ok := ms.torrentService.TorrentOverride(movieID); ok {
+		ms.updateStreamStatus(key, "searching", "Using torrent selected by an administrator", map[string]interface{}{
+			"step": "torrent_selected",
+			"name": override.Name,
+		})
+		return override.InfoHash, nil
+	}
+
+	ms.updateStreamStatus(key, "searching", "Fetching movie information", map[string]interface{}{
+		"step": "fetch_details",
+	})
+
+	s, _ := ms.GetSource("tmdb")
+
+	details, err := s.GetIMDbID(movieID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch movie details: %w", err)
+	}
+
+	ms.updateStreamStatus(key, "searching", "Searching torrent sources", map[string]interface{}{
+		"step":         "search_torrents",
+		"imdb_id":      details.IMDbID,
+		"title":        details.Title,
+		"release_date": details.ReleaseDate,
+	})
+
+	torrents, err := ms.searchTorrentsByIMDb(*details, 30*time.Second)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("Error searching torrents: %v", err))
+		return "", fmt.Errorf("failed to search torrents: %w", err)
+	}
+
+	ms.updateStreamStatus(key, "searching", fmt.Sprintf("Found %d torrent(s)", len(torrents)), map[string]interface{}{
+		"step":          "torrents_found",
+		"torrent_count": len(torrents),
+	})
+
+	if len(torrents) == 0 {
+		return "", fmt.Errorf("no suitable torrent found")
+	}
+
+	rankTorrents(torrents)
+
+	bestTorrent := &torrents[0]
+
+	ms.updateStreamStatus(key, "searching", "Selected best torrent", map[string]interface{}{
+		"step":    "torrent_selected",
+		"name":    bestTorrent.Name,
+		"seeders": bestTorrent.Seeders,
+	})
+
+	return bestTorrent.InfoHash, nil
+}
+
+// ResetMovieStream forgets the HLS output of a movie so that the next stream
+// request transcodes it again, e.g. after its torrent was replaced.
+func (ms *MovieService) ResetMovieStream(movieID int) error {
+	key := StreamKey{MovieID: movieID}
+	ms.ForgetStream(key)
+	if err := ms.UnpublishStream(key); err != nil {
+		return err
+	}
+
+	// The outputs of the movie's sources are kept.
+	hlsDir := filepath.Join(hlsBaseDir(), key.Dir())
+	entries, err := os.ReadDir(hlsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == sourcesDir {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(hlsDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (ms *MovieService) getTorrentMovieDetails(activeDownload *models.TorrentDownload) (string, *torrent.File, string, float64) {
 	activeDownload.Mu.RLock()
 	filePath := activeDownload.FilePath
@@ -663,7 +764,7 @@ func (ms *MovieService) createMasterPlaylist(hlsOutputDir string, srtFiles []str
 
 func (ms *MovieService) tryFFmpegTranscodingWithPlaylist(
 	activeDownload *models.TorrentDownload,
-	movieID int,
+	key StreamKey,
 	hlsOutputDir string,
 	masterPlaylist *m3u8.MasterPlaylist,
 ) {
@@ -673,42 +774,40 @@ func (ms *MovieService) tryFFmpegTranscodingWithPlaylist(
 	for {
 		attempt++
 
-		_, videoFile, _, _ := ms.getTorrentMovieDetails(activeDownload)
-
-		if videoFile == nil {
+		reader, err := ms.openVideoReader(activeDownload)
+		if err != nil {
+			ms.updateStreamStatus(key, "error", "Failed to open video file: "+err.Error(), map[string]interface{}{
+				"transcodingStatus": "failed",
+			})
+			break
+		}
+		if reader == nil {
 			time.Sleep(retryDelay)
 			continue
 		}
 
-		reader := videoFile.NewReader()
-		reader.SetResponsive()                // Blocks until pieces are complete
-		reader.SetReadahead(10 * 1024 * 1024) // 10MB read-ahead
-
-		err := ms.runFFmpegTranscoding(reader, hlsOutputDir)
+		err = ms.runFFmpegTranscoding(reader, hlsOutputDir)
 		reader.Close()
+		if err == nil {
+			err = endHLSPlaylists(hlsOutputDir)
+		}
 
 		if err == nil {
-			var downloadedMovie models.DownloadedMovie
-			if err := ms.db.Where("movie_id = ?", movieID).First(&downloadedMovie).Error; err == nil {
-
-				outputDir := activeDownload.RootDir
-				// log.Printf("Cleaning up torrent files for movie %d dir %s", movieID, outputDir)
-				err := ms.torrentService.RemoveTorrentFiles(movieID, downloadedMovie.Quality, outputDir)
-				if err != nil {
-					// log.Printf("Error cleaning up torrent files for movie %d: %v", movieID, err)
-				}
-			}
+			// The torrent keeps seeding until the seeding policy drops it.
+			filePath, _, _, _ := ms.getTorrentMovieDetails(activeDownload)
+			ms.torrentService.MarkTranscoded(key.MovieID, filePath)
 
-			ms.MasterPlaylists.Store(movieID, masterPlaylist)
+			ms.MasterPlaylists.Store(key, masterPlaylist)
 
-			ms.updateStreamStatus(movieID, "ready", "Stream is ready to play", map[string]interface{}{
+			ms.updateStreamStatus(key, "ready", "Stream is ready to play", map[string]interface{}{
 				"transcodingStatus": "ready",
 				"masterPlaylist":    masterPlaylist,
 			})
+			ms.publishStream(key, hlsOutputDir)
 			break
 		}
 
-		ms.updateStreamStatus(movieID, "transcoding", fmt.Sprintf("Transcoding attempt %d failed, retrying...", attempt), map[string]interface{}{
+		ms.updateStreamStatus(key, "transcoding", fmt.Sprintf("Transcoding attempt %d failed, retrying...", attempt), map[string]interface{}{
 			"transcodingStatus": "retrying",
 			"attempt":           attempt,
 			"error":             err.Error(),
@@ -716,7 +815,7 @@ func (ms *MovieService) tryFFmpegTranscodingWithPlaylist(
 
 		_, _, status, progress := ms.getTorrentMovieDetails(activeDownload)
 		if status == "completed" || progress >= 100.0 {
-			ms.updateStreamStatus(movieID, "error", "Transcoding failed after download completed", map[string]interface{}{
+			ms.updateStreamStatus(key, "error", "Transcoding failed after download completed", map[string]interface{}{
 				"transcodingStatus": "failed",
 				"lastError":         err.Error(),
 			})
@@ -727,6 +826,26 @@ func (ms *MovieService) tryFFmpegTranscodingWithPlaylist(
 	}
 }
 
+// openVideoReader reads the video file of a download from its torrent, or
+// from disk when the file is complete without one (library files and movies
+// downloaded before a restart). It returns nil while the file is not known yet.
+func (ms *MovieService) openVideoReader(activeDownload *models.TorrentDownload) (io.ReadCloser, error) {
+	filePath, videoFile, status, _ := ms.getTorrentMovieDetails(activeDownload)
+
+	if videoFile != nil {
+		reader := videoFile.NewReader()
+		reader.SetResponsive()                // Blocks until pieces are complete
+		reader.SetReadahead(10 * 1024 * 1024) // 10MB read-ahead
+		return reader, nil
+	}
+
+	if status == "completed" && filePath != "" {
+		return os.Open(filePath)
+	}
+
+	return nil, nil
+}
+
 func (ms *MovieService) runFFmpegTranscoding(reader io.Reader, hlsOutputDir string) error {
 	var args []string
 
@@ -822,3 +941,53 @@ func (ms *MovieService) runFFmpegTranscoding(reader io.Reader, hlsOutputDir stri
 
 	return nil
 }
+
+// hlsEndList marks a media playlist as complete. ffmpeg is told to omit it so
+// players keep polling while a movie is transcoded.
+const hlsEndList = "#EXT-X-ENDLIST"
+
+// variantPlaylists returns the media playlists of the enabled qualities of
+// an HLS output.
+func variantPlaylists(hlsOutputDir string) []string {
+	var playlists []string
+	for _, quality := range VideoTranscoderConf.Qualities {
+		if quality.Enabled {
+			playlists = append(playlists, filepath.Join(hlsOutputDir, quality.Name, "playlist.m3u8"))
+		}
+	}
+	return playlists
+}
+
+// endHLSPlaylists appends the end tag to the media playlists of a finished
+// transcode.
+func endHLSPlaylists(hlsOutputDir string) error {
+	for _, playlist := range variantPlaylists(hlsOutputDir) {
+		complete, err := playlistComplete(playlist)
+		if err != nil {
+			return err
+		}
+		if complete {
+			continue
+		}
+
+		f, err := os.OpenFile(playlist, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(hlsEndList + "\n")
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func playlistComplete(playlist string) (bool, error) {
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(data), hlsEndList), nil
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"server/internal/models"
+	"server/internal/release"
+	"sort"
+	"time"
+)
+
+// sourcesDir is the directory of a movie's HLS output holding the outputs of
+// its individual sources.
+const sourcesDir = "sources"
+
+// StreamKey identifies an HLS output: the default stream of a movie, or the
+// stream of one of its stored sources.
+type StreamKey struct {
+	MovieID  int
+	SourceID uint // 0 for the default stream
+}
+
+// Dir is the directory of the stream relative to the HLS output directory,
+// which is also its path under /stream.
+func (k StreamKey) Dir() string {
+	if k.SourceID == 0 {
+		return fmt.Sprintf("%d", k.MovieID)
+	}
+	return filepath.Join(fmt.Sprintf("%d", k.MovieID), sourcesDir, fmt.Sprintf("%d", k.SourceID))
+}
+
+func (k StreamKey) String() string {
+	if k.SourceID == 0 {
+		return fmt.Sprintf("movie %d", k.MovieID)
+	}
+	return fmt.Sprintf("movie %d source %d", k.MovieID, k.SourceID)
+}
+
+func hlsBaseDir() string {
+	if Conf.STREAMING.HLSOutputDir != "" {
+		return Conf.STREAMING.HLSOutputDir
+	}
+	return VideoTranscoderConf.Output.Directory
+}
+
+// sourceName returns the release name of a stored source.
+func sourceName(dm models.DownloadedMovie) string {
+	if dm.ReleaseName != "" {
+		return dm.ReleaseName
+	}
+	return filepath.Base(dm.FilePath)
+}
+
+func sourceInfo(dm models.DownloadedMovie) release.Info {
+	info := release.Parse(sourceName(dm))
+	if info.Resolution == "" {
+		info = release.Parse(filepath.Base(dm.FilePath))
+	}
+	return info
+}
+
+// StoredSource is a DownloadedMovie seen as a source of its movie.
+type StoredSource struct {
+	models.DownloadedMovie
+}
+
+// Name returns the release name of the source.
+func (s StoredSource) Name() string {
+	return sourceName(s.DownloadedMovie)
+}
+
+// storedSources returns the sources of a movie whose file is on disk or
+// archived, best release first.
+func (ts *TorrentService) storedSources(movieID int) []models.DownloadedMovie {
+	var rows []models.DownloadedMovie
+	if err := ts.db.Where("movie_id = ?", movieID).Order("id").Find(&rows).Error; err != nil {
+		return nil
+	}
+
+	sources := rows[:0]
+	for _, row := range rows {
+		if row.FilePath == "" {
+			continue
+		}
+		// Archived files are restored when they are streamed.
+		if _, err := os.Stat(row.FilePath); err != nil && row.StorageKey == "" {
+			continue
+		}
+		sources = append(sources, row)
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sourceInfo(sources[i]).Score() > sourceInfo(sources[j]).Score()
+	})
+
+	return sources
+}
+
+// PreferredSource returns the source a movie streams from by default.
+func (ts *TorrentService) PreferredSource(movieID int) (StoredSource, bool) {
+	sources := ts.storedSources(movieID)
+	if len(sources) == 0 {
+		return StoredSource{}, false
+	}
+
+	if override, ok := ts.TorrentOverride(movieID); ok {
+		for _, source := range sources {
+			if source.InfoHash == override.InfoHash {
+				return StoredSource{source}, true
+			}
+		}
+	}
+
+	return StoredSource{sources[0]}, true
+}
+
+// Source returns a stored source of a movie.
+func (ts *TorrentService) Source(movieID int, sourceID uint) (StoredSource, bool) {
+	var source models.DownloadedMovie
+	if err := ts.db.Where("id = ? AND movie_id = ?", sourceID, movieID).First(&source).Error; err != nil {
+		return StoredSource{}, false
+	}
+	return StoredSource{source}, true
+}
+
+// SourceDownload returns a finished download reading the file of a stored
+// source. Archived files are restored and sources whose file is gone are
+// forgotten.
+func (ts *TorrentService) SourceDownload(movieID int, sourceID uint) (*models.TorrentDownload, bool) {
+	source, ok := ts.Source(movieID, sourceID)
+	if !ok || source.FilePath == "" {
+		return nil, false
+	}
+
+	if _, err := os.Stat(source.FilePath); err != nil {
+		if source.StorageKey != "" {
+			if err := ts.restoreOriginal(source.DownloadedMovie); err != nil {
+				Logger.Error(fmt.Sprintf("Failed to restore source %d of movie %d: %v", sourceID, movieID, err))
+				return nil, false
+			}
+		} else {
+			// log.Printf("Movie %d marked as downloaded but file missing, deleting database record", movieID)
+			ts.db.Delete(&source.DownloadedMovie)
+			return nil, false
+		}
+	}
+
+	ts.db.Model(&source.DownloadedMovie).Update("last_watched", time.Now())
+
+	return &models.TorrentDownload{
+		MovieID:        movieID,
+		Quality:        source.Quality,
+		Progress:       100,
+		Status:         "completed",
+		StreamReady:    true,
+		StreamingReady: true,
+		FilePath:       source.FilePath,
+		CompletedAt:    &[]time.Time{time.Now()}[0],
+	}, true
+}
+
+// MovieSources lists the stored sources of a movie, the default one first.
+func (ts *TorrentService) MovieSources(movieID int) []models.MovieSource {
+	rows := ts.storedSources(movieID)
+	preferred, _ := ts.PreferredSource(movieID)
+
+	sources := make([]models.MovieSource, 0, len(rows))
+	for _, row := range rows {
+		info := sourceInfo(row)
+		source := models.MovieSource{
+			ID:          row.ID,
+			Quality:     row.Quality,
+			Source:      info.Source,
+			Edition:     info.Edition,
+			VideoCodec:  info.VideoCodec,
+			AudioCodec:  info.AudioCodec,
+			HDR:         info.HDR,
+			Languages:   info.Languages,
+			ReleaseName: sourceName(row),
+			FileSize:    row.FileSize,
+			Origin:      row.Source,
+			AddedAt:     row.DownloadedAt,
+			Default:     row.ID == preferred.ID,
+			StreamURL:   fmt.Sprintf("/api/stream/%d/%s/%d", movieID, sourcesDir, row.ID),
+		}
+		if _, err := os.Stat(row.FilePath); err == nil && row.DirectPlay != nil && *row.DirectPlay {
+			source.DirectPlay = true
+			source.DirectURL = source.StreamURL + "/direct"
+		}
+		if source.Default {
+			sources = append([]models.MovieSource{source}, sources...)
+		} else {
+			sources = append(sources, source)
+		}
+	}
+
+	return sources
+}
@@ -55,11 +55,49 @@ func loadMigrations(db *gorm.DB) {
 		log.Fatal(err)
 	}
 
+	err = db.AutoMigrate(&models.CommentReaction{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = db.AutoMigrate(&models.CommentReport{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	err = db.AutoMigrate(&models.DownloadedMovie{})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// A movie used to have a single file per quality.
+	if db.Migrator().HasIndex(&models.DownloadedMovie{}, "idx_movie_quality") {
+		err = db.Migrator().DropIndex(&models.DownloadedMovie{}, "idx_movie_quality")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = db.AutoMigrate(&models.ActiveTorrent{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = db.AutoMigrate(&models.TorrentOverride{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = db.AutoMigrate(&models.PublishedStream{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = db.AutoMigrate(&models.MovieExport{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	err = db.AutoMigrate(&models.Subtitle{})
 	if err != nil {
 		log.Fatal(err)
@@ -69,4 +107,19 @@ func loadMigrations(db *gorm.DB) {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	err = db.AutoMigrate(&models.WatchlistItem{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = db.AutoMigrate(&models.MovieList{}, &models.MovieListItem{}, &models.MovieListCollaborator{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = db.AutoMigrate(&models.Rating{})
+	if err != nil {
+		log.Fatal(err)
+	}
 }
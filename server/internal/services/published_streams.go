@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"server/internal/models"
+	"strconv"
+)
+
+// hlsMediaKey is the media storage directory of the HLS output of a stream.
+func hlsMediaKey(key StreamKey) string {
+	return path.Join("hls", filepath.ToSlash(key.Dir()))
+}
+
+// originalMediaKey is the media storage directory of the archived file of a
+// source.
+func originalMediaKey(dm models.DownloadedMovie) string {
+	return path.Join("originals", strconv.Itoa(dm.MovieID), strconv.FormatUint(uint64(dm.ID), 10))
+}
+
+// publishStream copies the finished HLS output of a stream to the media
+// storage and, unless the local copy is to be kept, removes it.
+func (ms *MovieService) publishStream(key StreamKey, hlsOutputDir string) {
+	store := MediaStore()
+	if !Conf.MEDIA_STORAGE.PublishHLS || store == nil {
+		return
+	}
+
+	ctx := context.Background()
+	prefix := hlsMediaKey(key)
+	skip := []string{"subs_vtt_temp"}
+	if key.SourceID == 0 {
+		skip = append(skip, sourcesDir)
+	}
+
+	size, err := putMediaDir(ctx, store, hlsOutputDir, prefix, skip...)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("Failed to publish HLS output of %s: %v", key, err))
+		store.DeletePrefix(ctx, prefix)
+		return
+	}
+
+	published := models.PublishedStream{
+		MovieID:  key.MovieID,
+		SourceID: key.SourceID,
+		Backend:  store.Name(),
+		Prefix:   prefix,
+		Size:     size,
+	}
+	if err := ms.db.Where("movie_id = ? AND source_id = ?", key.MovieID, key.SourceID).
+		Assign(published).
+		FirstOrCreate(&published).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to record published HLS output of %s: %v", key, err))
+		return
+	}
+	ms.publishedStreams.Store(key, published)
+	Logger.Info(fmt.Sprintf("Published HLS output of %s to %s storage (%d bytes)", key, store.Name(), size))
+
+	if Conf.MEDIA_STORAGE.KeepLocalHLS {
+		return
+	}
+
+	if key.SourceID != 0 {
+		err = os.RemoveAll(hlsOutputDir)
+	} else {
+		err = removeDefaultOutput(hlsOutputDir)
+	}
+	if err != nil {
+		Logger.Error(fmt.Sprintf("Failed to remove local HLS output of %s: %v", key, err))
+	}
+}
+
+// removeDefaultOutput removes the output of the default stream of a movie,
+// keeping the outputs of its sources.
+func removeDefaultOutput(movieDir string) error {
+	entries, err := os.ReadDir(movieDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == sourcesDir {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(movieDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishedStream returns the published HLS output of a stream, if any.
+func (ms *MovieService) PublishedStream(key StreamKey) (models.PublishedStream, bool) {
+	if value, ok := ms.publishedStreams.Load(key); ok {
+		return value.(models.PublishedStream), true
+	}
+
+	var published models.PublishedStream
+	if err := ms.db.Where("movie_id = ? AND source_id = ?", key.MovieID, key.SourceID).First(&published).Error; err != nil {
+		return models.PublishedStream{}, false
+	}
+	ms.publishedStreams.Store(key, published)
+
+	return published, true
+}
+
+// PublishedFile returns the media storage key of a file of a published
+// stream, name being relative to the stream directory.
+func (ms *MovieService) PublishedFile(key StreamKey, name string) (string, bool) {
+	published, ok := ms.PublishedStream(key)
+	if !ok {
+		return "", false
+	}
+
+	objectKey := path.Join(published.Prefix, path.Clean("/"+name))
+	return objectKey, true
+}
+
+// UnpublishStream removes the published HLS output of a stream.
+func (ms *MovieService) UnpublishStream(key StreamKey) error {
+	ms.publishedStreams.Delete(key)
+
+	var published models.PublishedStream
+	if err := ms.db.Where("movie_id = ? AND source_id = ?", key.MovieID, key.SourceID).First(&published).Error; err != nil {
+		return nil
+	}
+
+	if store := MediaStore(); store != nil && store.Name() == published.Backend {
+		if err := store.DeletePrefix(context.Background(), published.Prefix); err != nil {
+			return fmt.Errorf("failed to remove published HLS output: %w", err)
+		}
+	} else {
+		Logger.Warn(fmt.Sprintf("HLS output of %s was published to %s storage, which is no longer configured", key, published.Backend))
+	}
+
+	return ms.db.Delete(&published).Error
+}
+
+// archiveOriginal copies the file of a downloaded source to the media
+// storage, from where it can be restored once evicted.
+func (ts *TorrentService) archiveOriginal(dm models.DownloadedMovie) {
+	store := MediaStore()
+	if !Conf.MEDIA_STORAGE.ArchiveOriginals || store == nil || dm.Source != "torrent" {
+		return
+	}
+
+	key := path.Join(originalMediaKey(dm), filepath.Base(dm.FilePath))
+	if err := store.Put(context.Background(), key, dm.FilePath); err != nil {
+		Logger.Error(fmt.Sprintf("Failed to archive source %d of movie %d: %v", dm.ID, dm.MovieID, err))
+		return
+	}
+
+	if err := ts.db.Model(&dm).Update("storage_key", key).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to record archive of source %d of movie %d: %v", dm.ID, dm.MovieID, err))
+		return
+	}
+	Logger.Info(fmt.Sprintf("Archived source %d of movie %d to %s storage", dm.ID, dm.MovieID, store.Name()))
+}
+
+// restoreOriginal fetches the archived file of a source back to its path.
+func (ts *TorrentService) restoreOriginal(dm models.DownloadedMovie) error {
+	store := MediaStore()
+	if store == nil || dm.StorageKey == "" {
+		return fmt.Errorf("source %d of movie %d is not archived", dm.ID, dm.MovieID)
+	}
+
+	Logger.Info(fmt.Sprintf("Restoring source %d of movie %d from %s storage", dm.ID, dm.MovieID, store.Name()))
+	return fetchMedia(context.Background(), store, dm.StorageKey, dm.FilePath)
+}
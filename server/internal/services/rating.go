@@ -0,0 +1,233 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"server/internal/models"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// SortCommunityRating sorts discover results by community rating. Only
+	// movies rated by users are listed.
+	SortCommunityRating = "community_rating"
+
+	minScore        = 1
+	maxScore        = 10
+	maxReviewLength = 5000
+
+	ratingsPerPage = 20
+)
+
+var ErrInvalidRating = errors.New("the score must be between 1 and 10 and the review at most 5000 characters")
+
+// RatingRequest is the rating a user gives a movie.
+type RatingRequest struct {
+	Score  int    `json:"score" example:"8"`
+	Review string `json:"review" example:"Still holds up."`
+}
+
+// CommunityRating is the average score users gave a movie.
+type CommunityRating struct {
+	Average float64 `json:"average" example:"7.8"`
+	Count   int64   `json:"count" example:"12"`
+}
+
+// RateMovie records the score and review a user gives a movie, replacing
+// their previous rating.
+func (ms *MovieService) RateMovie(userID uint, movieID int, req RatingRequest) (models.Rating, error) {
+	req.Review = strings.TrimSpace(req.Review)
+	if req.Score < minScore || req.Score > maxScore || len(req.Review) > maxReviewLength {
+		return models.Rating{}, ErrInvalidRating
+	}
+
+	var rating models.Rating
+	err := ms.db.Where("user_id = ? AND movie_id = ?", userID, movieID).First(&rating).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		details := ms.watchedMovieDetails(movieID)
+		if details == nil {
+			return models.Rating{}, ErrMovieNotFound
+		}
+		rating = models.Rating{
+			UserID:      userID,
+			MovieID:     movieID,
+			MovieTitle:  details.Title,
+			PosterPath:  details.PosterPath,
+			ReleaseDate: details.ReleaseDate,
+		}
+		for _, genre := range details.Genres {
+			rating.GenreIDs = append(rating.GenreIDs, int64(genre.ID))
+		}
+	} else if err != nil {
+		return models.Rating{}, err
+	}
+
+	rating.Score = req.Score
+	rating.Review = req.Review
+
+	if rating.ID == 0 {
+		// A concurrent request may have rated the movie meanwhile.
+		err = ms.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "movie_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"score", "review", "updated_at"}),
+		}).Create(&rating).Error
+	} else {
+		err = ms.db.Save(&rating).Error
+	}
+	if err != nil {
+		return models.Rating{}, err
+	}
+
+	return rating, nil
+}
+
+// DeleteRating removes the rating a user gave a movie.
+func (ms *MovieService) DeleteRating(userID uint, movieID int) error {
+	return ms.db.Where("user_id = ? AND movie_id = ?", userID, movieID).Delete(&models.Rating{}).Error
+}
+
+// UserRating returns the rating a user gave a movie.
+func (ms *MovieService) UserRating(userID uint, movieID int) (models.Rating, bool) {
+	var rating models.Rating
+	err := ms.db.Where("user_id = ? AND movie_id = ?", userID, movieID).First(&rating).Error
+	return rating, err == nil
+}
+
+// MovieRating returns the community rating of a movie.
+func (ms *MovieService) MovieRating(movieID int) CommunityRating {
+	return ms.communityRatings([]int{movieID})[movieID]
+}
+
+// MovieReviews returns the ratings of a movie that come with a review, newest
+// first.
+func (ms *MovieService) MovieReviews(movieID, page int) ([]models.Rating, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	reviews := []models.Rating{}
+	err := ms.db.Preload("User").
+		Where("movie_id = ? AND review <> ''", movieID).
+		Order("updated_at DESC").
+		Limit(ratingsPerPage).
+		Offset((page - 1) * ratingsPerPage).
+		Find(&reviews).Error
+
+	return reviews, err
+}
+
+// UserRatings returns the ratings of a user, newest first.
+func (ms *MovieService) UserRatings(userID uint, page int) ([]models.Rating, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	ratings := []models.Rating{}
+	err := ms.db.Where("user_id = ?", userID).
+		Order("updated_at DESC").
+		Limit(ratingsPerPage).
+		Offset((page - 1) * ratingsPerPage).
+		Find(&ratings).Error
+
+	return ratings, err
+}
+
+// communityRatings returns the community rating of the given movies. Movies
+// nobody rated are left out.
+func (ms *MovieService) communityRatings(movieIDs []int) map[int]CommunityRating {
+	ratings := make(map[int]CommunityRating)
+	if len(movieIDs) == 0 {
+		return ratings
+	}
+
+	var rows []struct {
+		MovieID int
+		Average float64
+		Count   int64
+	}
+	ms.db.Model(&models.Rating{}).
+		Select("movie_id, AVG(score) AS average, COUNT(*) AS count").
+		Where("movie_id IN ?", movieIDs).
+		Group("movie_id").
+		Scan(&rows)
+
+	for _, row := range rows {
+		ratings[row.MovieID] = CommunityRating{Average: roundRating(row.Average), Count: row.Count}
+	}
+	return ratings
+}
+
+// discoverRated lists the movies users rated, best rated first. Filters
+// apply to the movie fields cached with the ratings, the minimum rating to
+// the community average.
+func (ms *MovieService) discoverRated(p MovieDiscoverParams) ([]models.Movie, []int, error) {
+	if p.Page < 1 {
+		p.Page = 1
+	}
+
+	query := ms.db.Model(&models.Rating{}).
+		Select("movie_id, MAX(movie_title) AS title, MAX(poster_path) AS poster_path, MAX(release_date) AS release_date, AVG(score) AS average").
+		Group("movie_id")
+
+	if p.YearFrom != nil {
+		query = query.Where("release_date >= ?", strconv.Itoa(*p.YearFrom)+"-01-01")
+	}
+	if p.YearTo != nil {
+		query = query.Where("release_date <> '' AND release_date <= ?", strconv.Itoa(*p.YearTo)+"-12-31")
+	}
+	if len(p.Genres) > 0 {
+		if tmdb, ok := ms.SearchSources["tmdb"]; ok {
+			if ids, err := tmdb.getGenreIDs(p.Genres); err == nil && ids != "" {
+				var genreIDs pq.Int64Array
+				for _, id := range strings.Split(ids, ",") {
+					if v, err := strconv.ParseInt(id, 10, 64); err == nil {
+						genreIDs = append(genreIDs, v)
+					}
+				}
+				query = query.Where("genre_ids @> ?", genreIDs)
+			}
+		}
+	}
+	if p.MinRating != nil {
+		query = query.Having("AVG(score) >= ?", *p.MinRating)
+	}
+
+	var rows []struct {
+		MovieID     int
+		Title       string
+		PosterPath  string
+		ReleaseDate string
+		Average     float64
+	}
+	err := query.
+		Order("average DESC, COUNT(*) DESC, movie_id").
+		Limit(ratingsPerPage).
+		Offset((p.Page - 1) * ratingsPerPage).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	movies := make([]models.Movie, 0, len(rows))
+	movieIDs := make([]int, 0, len(rows))
+	for _, row := range rows {
+		movies = append(movies, models.Movie{
+			ID:          row.MovieID,
+			Title:       row.Title,
+			PosterPath:  row.PosterPath,
+			ReleaseDate: row.ReleaseDate,
+		})
+		movieIDs = append(movieIDs, row.MovieID)
+	}
+
+	return movies, movieIDs, nil
+}
+
+func roundRating(average float64) float64 {
+	return math.Round(average*10) / 10
+}
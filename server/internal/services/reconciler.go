@@ -0,0 +1,554 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"server/internal/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// reconcileGracePeriod keeps the reconciler away from downloads that were
+// just written to, which may belong to a torrent that is being added.
+const reconcileGracePeriod = time.Hour
+
+// endListMigratedMarker, in the HLS output directory, records that the output
+// transcoded before media playlists were ended on completion was migrated.
+const endListMigratedMarker = ".endlist-migrated"
+
+// Reconciler brings the database and the media directories back in line:
+// rows whose file is gone are deleted, downloads no row refers to and
+// unfinished HLS output are moved to the quarantine directory.
+type Reconciler struct {
+	db             *gorm.DB
+	torrentService *TorrentService
+	movieService   *MovieService
+	quarantineDir  string
+	interval       time.Duration
+
+	runMu sync.Mutex
+
+	lastRunMu sync.RWMutex
+	lastRun   ReconcileResult
+}
+
+// ReconcileResult lists what a reconciliation changed.
+type ReconcileResult struct {
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Changes    []ReconcileChange `json:"changes"`
+	Errors     []string          `json:"errors"`
+}
+
+// ReconcileChange is a single fix made by a reconciliation.
+type ReconcileChange struct {
+	MovieID int    `json:"movie_id" example:"603"`
+	Path    string `json:"path" example:"/app/hls_output/603"`
+	Action  string `json:"action" example:"quarantined"` // "row_deleted", "quarantined", "removed" or "playlists_ended"
+	Reason  string `json:"reason" example:"transcoding did not finish"`
+	MovedTo string `json:"moved_to,omitempty" example:"/app/quarantine/20250101T000000/hls/603"`
+}
+
+// reconcileRun collects the changes of one reconciliation.
+type reconcileRun struct {
+	result        ReconcileResult
+	quarantineDir string
+}
+
+func NewReconciler(db *gorm.DB, torrentService *TorrentService, movieService *MovieService) *Reconciler {
+	quarantineDir := Conf.STORAGE.QuarantineDir
+	if quarantineDir == "" {
+		quarantineDir = filepath.Join(filepath.Dir(torrentService.downloadDir), "quarantine")
+	}
+
+	r := &Reconciler{
+		db:             db,
+		torrentService: torrentService,
+		movieService:   movieService,
+		quarantineDir:  quarantineDir,
+		interval:       Conf.STORAGE.ReconcileInterval,
+	}
+
+	go r.reconcileWorker()
+
+	return r
+}
+
+func (r *Reconciler) reconcileWorker() {
+	r.Reconcile()
+
+	if r.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.Reconcile()
+	}
+}
+
+// LastRun returns the result of the latest reconciliation.
+func (r *Reconciler) LastRun() ReconcileResult {
+	r.lastRunMu.RLock()
+	defer r.lastRunMu.RUnlock()
+
+	return r.lastRun
+}
+
+// Reconcile checks the database rows against the files on disk and fixes or
+// quarantines what does not match.
+func (r *Reconciler) Reconcile() ReconcileResult {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	startedAt := time.Now()
+	run := &reconcileRun{
+		result: ReconcileResult{
+			StartedAt: startedAt,
+			Changes:   []ReconcileChange{},
+			Errors:    []string{},
+		},
+		quarantineDir: filepath.Join(r.quarantineDir, startedAt.Format("20060102T150405")),
+	}
+
+	r.reconcileDownloadedMovies(run)
+	r.reconcileSubtitles(run)
+	r.reconcileDownloadDir(run)
+	r.migrateEndList(run)
+	r.reconcileHLSDir(run)
+	r.reconcilePublishedStreams(run)
+
+	run.result.FinishedAt = time.Now()
+	Logger.Info(fmt.Sprintf("Reconciliation finished: %d change(s), %d error(s)", len(run.result.Changes), len(run.result.Errors)))
+
+	r.lastRunMu.Lock()
+	r.lastRun = run.result
+	r.lastRunMu.Unlock()
+
+	return run.result
+}
+
+func (run *reconcileRun) change(c ReconcileChange) {
+	if c.MovedTo != "" {
+		Logger.Info(fmt.Sprintf("Reconcile: %s %s of movie %d to %s: %s", c.Action, c.Path, c.MovieID, c.MovedTo, c.Reason))
+	} else {
+		Logger.Info(fmt.Sprintf("Reconcile: %s %s of movie %d: %s", c.Action, c.Path, c.MovieID, c.Reason))
+	}
+	run.result.Changes = append(run.result.Changes, c)
+}
+
+func (run *reconcileRun) fail(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	Logger.Error("Reconcile: " + msg)
+	run.result.Errors = append(run.result.Errors, msg)
+}
+
+// quarantine moves path to the same relative location under the quarantine
+// directory of the run.
+func (run *reconcileRun) quarantine(path, rel string) (string, error) {
+	dest := filepath.Join(run.quarantineDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+func fileMissing(path string) bool {
+	if path == "" {
+		return true
+	}
+	_, err := os.Stat(path)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// reconcileDownloadedMovies deletes the sources whose file is gone and was
+// not archived.
+func (r *Reconciler) reconcileDownloadedMovies(run *reconcileRun) {
+	var rows []models.DownloadedMovie
+	if err := r.db.Find(&rows).Error; err != nil {
+		run.fail("failed to list downloaded movies: %v", err)
+		return
+	}
+
+	for _, row := range rows {
+		if !fileMissing(row.FilePath) {
+			continue
+		}
+		if row.StorageKey != "" && MediaStore() != nil {
+			archived, err := MediaStore().Exists(context.Background(), row.StorageKey)
+			if err != nil {
+				run.fail("failed to check archive of source %d of movie %d: %v", row.ID, row.MovieID, err)
+				continue
+			}
+			if archived {
+				continue
+			}
+		}
+		if err := r.db.Delete(&row).Error; err != nil {
+			run.fail("failed to delete source %d of movie %d: %v", row.ID, row.MovieID, err)
+			continue
+		}
+		run.change(ReconcileChange{
+			MovieID: row.MovieID,
+			Path:    row.FilePath,
+			Action:  "row_deleted",
+			Reason:  fmt.Sprintf("file of %s source %d is missing", row.Source, row.ID),
+		})
+	}
+}
+
+// reconcileSubtitles deletes the subtitles whose file is gone, so they are
+// downloaded again.
+func (r *Reconciler) reconcileSubtitles(run *reconcileRun) {
+	var rows []models.Subtitle
+	if err := r.db.Find(&rows).Error; err != nil {
+		run.fail("failed to list subtitles: %v", err)
+		return
+	}
+
+	for _, row := range rows {
+		if !fileMissing(row.FilePath) {
+			continue
+		}
+		if err := r.db.Delete(&row).Error; err != nil {
+			run.fail("failed to delete %s subtitle of movie %d: %v", row.Language, row.MovieID, err)
+			continue
+		}
+		run.change(ReconcileChange{
+			MovieID: row.MovieID,
+			Path:    row.FilePath,
+			Action:  "row_deleted",
+			Reason:  fmt.Sprintf("%s subtitle file is missing", row.Language),
+		})
+	}
+}
+
+// reconcileDownloadDir quarantines the torrent directories that no source,
+// active torrent or running download refers to.
+func (r *Reconciler) reconcileDownloadDir(run *reconcileRun) {
+	downloadDir := r.torrentService.downloadDir
+
+	var sources []models.DownloadedMovie
+	if err := r.db.Where("source = ?", "torrent").Find(&sources).Error; err != nil {
+		run.fail("failed to list downloaded movies: %v", err)
+		return
+	}
+	var active []models.ActiveTorrent
+	if err := r.db.Find(&active).Error; err != nil {
+		run.fail("failed to list active torrents: %v", err)
+		return
+	}
+
+	movieDirs, err := os.ReadDir(downloadDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			run.fail("failed to read download directory: %v", err)
+		}
+		return
+	}
+
+	for _, movieDir := range movieDirs {
+		// Other entries hold the torrent client's own state.
+		movieID, err := strconv.Atoi(movieDir.Name())
+		if err != nil || !movieDir.IsDir() {
+			continue
+		}
+
+		movieDirPath := filepath.Join(downloadDir, movieDir.Name())
+		entries, err := os.ReadDir(movieDirPath)
+		if err != nil {
+			run.fail("failed to read %s: %v", movieDirPath, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(movieDirPath, entry.Name())
+			if r.downloadReferenced(movieID, path, entry.Name(), sources, active) {
+				continue
+			}
+			if info, err := entry.Info(); err != nil || time.Since(info.ModTime()) < reconcileGracePeriod {
+				continue
+			}
+
+			movedTo, err := run.quarantine(path, filepath.Join("downloads", movieDir.Name(), entry.Name()))
+			if err != nil {
+				run.fail("failed to quarantine %s: %v", path, err)
+				continue
+			}
+			run.change(ReconcileChange{
+				MovieID: movieID,
+				Path:    path,
+				Action:  "quarantined",
+				Reason:  "no source or active torrent refers to it",
+				MovedTo: movedTo,
+			})
+		}
+
+		removeIfEmpty(movieDirPath)
+	}
+}
+
+func (r *Reconciler) downloadReferenced(movieID int, path, name string, sources []models.DownloadedMovie, active []models.ActiveTorrent) bool {
+	for _, source := range sources {
+		if source.MovieID == movieID && (source.FilePath == path || withinDir(path, source.FilePath)) {
+			return true
+		}
+	}
+	for _, at := range active {
+		if at.MovieID == movieID && at.InfoHash == name {
+			return true
+		}
+	}
+	if dl, ok := r.torrentService.ActiveDownload(movieID); ok {
+		dl.Mu.RLock()
+		rootDir := dl.RootDir
+		dl.Mu.RUnlock()
+		if rootDir == path {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileHLSDir quarantines the HLS output whose transcoding did not
+// finish and the output of sources that no longer exist. Movies that are
+// being streamed are left alone.
+func (r *Reconciler) reconcileHLSDir(run *reconcileRun) {
+	hlsDir := hlsBaseDir()
+
+	movieDirs, err := os.ReadDir(hlsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			run.fail("failed to read HLS output directory: %v", err)
+		}
+		return
+	}
+
+	for _, movieDir := range movieDirs {
+		movieID, err := strconv.Atoi(movieDir.Name())
+		if err != nil || !movieDir.IsDir() {
+			continue
+		}
+		if r.movieService.StreamInUse(movieID) {
+			continue
+		}
+
+		movieDirPath := filepath.Join(hlsDir, movieDir.Name())
+		r.reconcileSourceOutputs(run, movieID, movieDirPath)
+		r.reconcileDefaultOutput(run, movieID, movieDirPath)
+
+		removeIfEmpty(filepath.Join(movieDirPath, sourcesDir))
+		removeIfEmpty(movieDirPath)
+	}
+}
+
+// migrateEndList ends the media playlists of the HLS output transcoded before
+// they were ended on completion, once, so that streams finished by earlier
+// versions are not taken for interrupted ones. Output with a master playlist
+// was complete then, and nothing is transcoded before the first run.
+func (r *Reconciler) migrateEndList(run *reconcileRun) {
+	hlsDir := hlsBaseDir()
+	marker := filepath.Join(hlsDir, endListMigratedMarker)
+	if !fileMissing(marker) {
+		return
+	}
+
+	movieDirs, err := os.ReadDir(hlsDir)
+	if err != nil && !os.IsNotExist(err) {
+		run.fail("failed to read HLS output directory: %v", err)
+		return
+	}
+
+	for _, movieDir := range movieDirs {
+		movieID, err := strconv.Atoi(movieDir.Name())
+		if err != nil || !movieDir.IsDir() {
+			continue
+		}
+
+		movieDirPath := filepath.Join(hlsDir, movieDir.Name())
+		outputs := []string{movieDirPath}
+		if entries, err := os.ReadDir(filepath.Join(movieDirPath, sourcesDir)); err == nil {
+			for _, entry := range entries {
+				outputs = append(outputs, filepath.Join(movieDirPath, sourcesDir, entry.Name()))
+			}
+		}
+
+		for _, output := range outputs {
+			if fileMissing(filepath.Join(output, "master.m3u8")) || hlsOutputComplete(output) {
+				continue
+			}
+			if err := endHLSPlaylists(output); err != nil {
+				run.fail("failed to end the playlists of %s: %v", output, err)
+				continue
+			}
+			run.change(ReconcileChange{MovieID: movieID, Path: output, Action: "playlists_ended", Reason: "transcoded before playlists were ended"})
+		}
+	}
+
+	if err := os.MkdirAll(hlsDir, 0755); err != nil {
+		run.fail("failed to create HLS output directory: %v", err)
+		return
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		run.fail("failed to record the playlist migration: %v", err)
+	}
+}
+
+func (r *Reconciler) reconcileSourceOutputs(run *reconcileRun, movieID int, movieDirPath string) {
+	entries, err := os.ReadDir(filepath.Join(movieDirPath, sourcesDir))
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(movieDirPath, sourcesDir, entry.Name())
+
+		reason := ""
+		sourceID, err := strconv.ParseUint(entry.Name(), 10, 64)
+		if _, ok := r.torrentService.Source(movieID, uint(sourceID)); err != nil || !ok {
+			reason = "source no longer exists"
+		} else if !hlsOutputComplete(path) {
+			reason = "transcoding did not finish"
+		}
+		if reason == "" {
+			continue
+		}
+
+		if err == nil {
+			key := StreamKey{MovieID: movieID, SourceID: uint(sourceID)}
+			r.movieService.ForgetStream(key)
+			if reason == "source no longer exists" {
+				r.movieService.UnpublishStream(key)
+			}
+		}
+		r.quarantineHLS(run, movieID, path, filepath.Join("hls", strconv.Itoa(movieID), sourcesDir, entry.Name()), reason)
+	}
+}
+
+// reconcileDefaultOutput checks the output of the default stream of a movie,
+// which is its HLS directory without the outputs of its sources.
+func (r *Reconciler) reconcileDefaultOutput(run *reconcileRun, movieID int, movieDirPath string) {
+	entries, err := os.ReadDir(movieDirPath)
+	if err != nil {
+		return
+	}
+
+	found := false
+	for _, entry := range entries {
+		if entry.Name() != sourcesDir {
+			found = true
+		}
+	}
+	if !found || hlsOutputComplete(movieDirPath) {
+		return
+	}
+
+	r.movieService.ForgetStream(StreamKey{MovieID: movieID})
+	for _, entry := range entries {
+		if entry.Name() == sourcesDir {
+			continue
+		}
+		path := filepath.Join(movieDirPath, entry.Name())
+		r.quarantineHLS(run, movieID, path, filepath.Join("hls", strconv.Itoa(movieID), entry.Name()), "transcoding did not finish")
+	}
+}
+
+// reconcilePublishedStreams forgets the published HLS output that is gone from
+// the media storage and removes the output of sources that no longer exist.
+func (r *Reconciler) reconcilePublishedStreams(run *reconcileRun) {
+	store := MediaStore()
+	if store == nil {
+		return
+	}
+
+	var rows []models.PublishedStream
+	if err := r.db.Find(&rows).Error; err != nil {
+		run.fail("failed to list published streams: %v", err)
+		return
+	}
+
+	for _, row := range rows {
+		key := StreamKey{MovieID: row.MovieID, SourceID: row.SourceID}
+
+		if key.SourceID != 0 {
+			if _, ok := r.torrentService.Source(key.MovieID, key.SourceID); !ok {
+				if err := r.movieService.UnpublishStream(key); err != nil {
+					run.fail("failed to unpublish %s: %v", key, err)
+					continue
+				}
+				run.change(ReconcileChange{MovieID: key.MovieID, Path: row.Prefix, Action: "removed", Reason: "source no longer exists"})
+				continue
+			}
+		}
+
+		if row.Backend != store.Name() {
+			continue
+		}
+		exists, err := store.Exists(context.Background(), path.Join(row.Prefix, "master.m3u8"))
+		if err != nil {
+			run.fail("failed to check published output of %s: %v", key, err)
+			continue
+		}
+		if exists {
+			continue
+		}
+
+		r.movieService.publishedStreams.Delete(key)
+		if err := r.db.Delete(&row).Error; err != nil {
+			run.fail("failed to forget published output of %s: %v", key, err)
+			continue
+		}
+		run.change(ReconcileChange{MovieID: key.MovieID, Path: row.Prefix, Action: "row_deleted", Reason: "published HLS output is missing from the media storage"})
+	}
+}
+
+// quarantineHLS moves HLS output to the quarantine directory, or removes it
+// when it cannot be moved since it can always be transcoded again.
+func (r *Reconciler) quarantineHLS(run *reconcileRun, movieID int, path, rel, reason string) {
+	movedTo, err := run.quarantine(path, rel)
+	if err == nil {
+		run.change(ReconcileChange{MovieID: movieID, Path: path, Action: "quarantined", Reason: reason, MovedTo: movedTo})
+		return
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		run.fail("failed to remove %s: %v", path, err)
+		return
+	}
+	run.change(ReconcileChange{MovieID: movieID, Path: path, Action: "removed", Reason: reason})
+}
+
+// hlsOutputComplete reports whether an HLS output has its master playlist and
+// every media playlist was ended.
+func hlsOutputComplete(hlsOutputDir string) bool {
+	if fileMissing(filepath.Join(hlsOutputDir, "master.m3u8")) {
+		return false
+	}
+
+	for _, playlist := range variantPlaylists(hlsOutputDir) {
+		if complete, err := playlistComplete(playlist); err != nil || !complete {
+			return false
+		}
+	}
+
+	return true
+}
+
+func removeIfEmpty(dir string) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		os.Remove(dir)
+	}
+}
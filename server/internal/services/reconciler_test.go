@@ -0,0 +1,69 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeTestHLS(t *testing.T, dir string, master bool) string {
+	t.Helper()
+	playlist := filepath.Join(dir, "720p", "playlist.m3u8")
+	if err := os.MkdirAll(filepath.Dir(playlist), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(playlist, []byte("#EXTM3U\n#EXTINF:6.0,\nsegment_000.ts\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if master {
+		if err := os.WriteFile(filepath.Join(dir, "master.m3u8"), []byte("#EXTM3U\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return playlist
+}
+
+func TestMigrateEndList(t *testing.T) {
+	savedDir, savedQualities := Conf.STREAMING.HLSOutputDir, VideoTranscoderConf.Qualities
+	defer func() {
+		Conf.STREAMING.HLSOutputDir, VideoTranscoderConf.Qualities = savedDir, savedQualities
+	}()
+
+	hlsDir := t.TempDir()
+	Conf.STREAMING.HLSOutputDir = hlsDir
+	qualities := slices.Grow(VideoTranscoderConf.Qualities[:0:0], 1)[:1]
+	qualities[0].Name = "720p"
+	qualities[0].Enabled = true
+	VideoTranscoderConf.Qualities = qualities
+
+	legacy := writeTestHLS(t, filepath.Join(hlsDir, "603"), true)
+	legacySource := writeTestHLS(t, filepath.Join(hlsDir, "604", sourcesDir, "12"), true)
+	noMaster := writeTestHLS(t, filepath.Join(hlsDir, "605"), false)
+
+	r := &Reconciler{}
+	run := &reconcileRun{}
+	r.migrateEndList(run)
+
+	for _, playlist := range []string{legacy, legacySource} {
+		if complete, _ := playlistComplete(playlist); !complete {
+			t.Errorf("%s was not ended", playlist)
+		}
+	}
+	if complete, _ := playlistComplete(noMaster); complete {
+		t.Errorf("%s without a master playlist was ended", noMaster)
+	}
+	if got := len(run.result.Changes); got != 2 {
+		t.Errorf("migration made %d changes, want 2", got)
+	}
+	if len(run.result.Errors) > 0 {
+		t.Errorf("migration failed: %v", run.result.Errors)
+	}
+
+	// Output interrupted after the migration is left to the reconciler.
+	interrupted := writeTestHLS(t, filepath.Join(hlsDir, "606"), true)
+	r.migrateEndList(&reconcileRun{})
+	if complete, _ := playlistComplete(interrupted); complete {
+		t.Errorf("%s was ended after the migration", interrupted)
+	}
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// s3MultipartThreshold is the size over which files are uploaded in
+	// parts, S3 refusing single uploads over 5 GiB.
+	s3MultipartThreshold = 64 << 20
+	s3PartSize           = 64 << 20
+
+	defaultPresignExpiry = time.Hour
+)
+
+// S3MediaStorage stores media in an S3-compatible bucket. Objects are served
+// through presigned URLs, or proxied by the API.
+type S3MediaStorage struct {
+	client        *s3.Client
+	presign       *s3.PresignClient
+	bucket        string
+	prefix        string
+	proxy         bool
+	presignExpiry time.Duration
+}
+
+func NewS3MediaStorage(ctx context.Context) (*S3MediaStorage, error) {
+	conf := Conf.MEDIA_STORAGE
+	if conf.S3.Bucket == "" {
+		return nil, fmt.Errorf("media storage bucket is not configured")
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{}
+	if conf.S3.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(conf.S3.Region))
+	}
+	if conf.S3.AccessKeyID != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(conf.S3.AccessKeyID, conf.S3.SecretAccessKey, ""),
+		))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// MinIO and most other S3-compatible servers.
+		if conf.S3.Endpoint != "" {
+			o.BaseEndpoint = aws.String(conf.S3.Endpoint)
+		}
+		o.UsePathStyle = conf.S3.UsePathStyle
+	})
+
+	presignExpiry := conf.PresignExpiry
+	if presignExpiry <= 0 {
+		presignExpiry = defaultPresignExpiry
+	}
+
+	return &S3MediaStorage{
+		client:        client,
+		presign:       s3.NewPresignClient(client),
+		bucket:        conf.S3.Bucket,
+		prefix:        conf.S3.Prefix,
+		proxy:         conf.Serve == "proxy",
+		presignExpiry: presignExpiry,
+	}, nil
+}
+
+func (s *S3MediaStorage) Name() string {
+	return "s3"
+}
+
+func (s *S3MediaStorage) key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+func (s *S3MediaStorage) Put(ctx context.Context, key, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > s3MultipartThreshold {
+		return s.putMultipart(ctx, key, f, info.Size())
+	}
+
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.key(key)),
+		Body:          f,
+		ContentLength: aws.Int64(info.Size()),
+		ContentType:   aws.String(MediaContentType(key)),
+	})
+	return err
+}
+
+func (s *S3MediaStorage) putMultipart(ctx context.Context, key string, f *os.File, size int64) error {
+	upload, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(key)),
+		ContentType: aws.String(MediaContentType(key)),
+	})
+	if err != nil {
+		return err
+	}
+
+	abort := func(err error) error {
+		s.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(s.key(key)),
+			UploadId: upload.UploadId,
+		})
+		return err
+	}
+
+	var parts []types.CompletedPart
+	for offset, number := int64(0), int32(1); offset < size; offset, number = offset+s3PartSize, number+1 {
+		length := min(int64(s3PartSize), size-offset)
+		part, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(s.key(key)),
+			UploadId:      upload.UploadId,
+			PartNumber:    aws.Int32(number),
+			Body:          io.NewSectionReader(f, offset, length),
+			ContentLength: aws.Int64(length),
+		})
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: part.ETag, PartNumber: aws.Int32(number)})
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(s.key(key)),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(err)
+	}
+
+	return nil
+}
+
+func (s *S3MediaStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3MediaStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3MediaStorage) URL(ctx context.Context, key string) (string, error) {
+	if s.proxy {
+		return "", nil
+	}
+
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	}, s3.WithPresignExpires(s.presignExpiry))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3MediaStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.key(prefix) + "/"),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, 0, len(page.Contents))
+		for _, object := range page.Contents {
+			objects = append(objects, types.ObjectIdentifier{Key: object.Key})
+		}
+		if _, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
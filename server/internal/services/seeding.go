@@ -0,0 +1,182 @@
+package services
+
+import (
+	"fmt"
+	"server/internal/models"
+	"time"
+)
+
+// seedingWorker applies the seeding policy to completed downloads: it records
+// upload statistics and drops torrents once they have seeded enough or the
+// downloads outgrow their budget. Their files are kept; disk space is the
+// storage service's to reclaim.
+func (ts *TorrentService) seedingWorker() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// The download directory is measured once per pass, when a torrent
+		// is seeding.
+		var overBudget *bool
+		downloadsOverBudget := func() bool {
+			if overBudget == nil {
+				over := ts.downloadsOverBudget()
+				overBudget = &over
+			}
+			return *overBudget
+		}
+
+		ts.Downloads.Range(func(_, value interface{}) bool {
+			dl, ok := value.(*models.TorrentDownload)
+			if !ok || dl.Torrent == nil {
+				return true
+			}
+
+			dl.Mu.Lock()
+			if dl.Status != "completed" && dl.Status != "seeding" {
+				dl.Mu.Unlock()
+				return true
+			}
+			if dl.SeedingSince == nil {
+				now := time.Now()
+				dl.SeedingSince = &now
+				dl.Status = "seeding"
+			}
+			seedingSince := *dl.SeedingSince
+			dl.Mu.Unlock()
+
+			ratio := ts.recordSeedingStats(dl, seedingSince, nil)
+
+			// A transcode reads from the torrent until it is done with it.
+			if ts.transcoding(dl.MovieID) {
+				return true
+			}
+
+			if reason := seedingStopReason(time.Since(seedingSince), ratio, downloadsOverBudget()); reason != "" {
+				ts.stopSeeding(dl, reason)
+			}
+
+			return true
+		})
+	}
+}
+
+// seedingStopReason returns why a torrent must stop seeding, or an empty
+// string when it should keep going.
+func seedingStopReason(elapsed time.Duration, ratio float64, overBudget bool) string {
+	policy := Conf.TORRENT.Seeding
+
+	switch {
+	case !policy.Enabled:
+		return "seeding disabled"
+	case overBudget:
+		return "download budget exceeded"
+	case policy.MaxSeedTime > 0 && elapsed >= policy.MaxSeedTime:
+		return "maximum seed time reached"
+	case elapsed >= policy.MinSeedTime && ratio >= policy.RatioTarget:
+		return "ratio target reached"
+	}
+
+	return ""
+}
+
+// downloadsOverBudget reports whether the download directory is larger than
+// STORAGE.DOWNLOAD_BUDGET.
+func (ts *TorrentService) downloadsOverBudget() bool {
+	budget := Conf.STORAGE.DownloadBudget
+	if budget <= 0 {
+		return false
+	}
+
+	used, err := DirSize(ts.downloadDir)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("Failed to measure download directory: %v", err))
+		return false
+	}
+	return used > budget
+}
+
+// SetTranscodingCheck sets how the seeding policy learns whether a movie is
+// being transcoded, which keeps its torrent from being dropped meanwhile.
+func (ts *TorrentService) SetTranscodingCheck(check func(movieID int) bool) {
+	ts.transcodingMu.Lock()
+	defer ts.transcodingMu.Unlock()
+
+	ts.transcodingCheck = check
+}
+
+func (ts *TorrentService) transcoding(movieID int) bool {
+	ts.transcodingMu.RLock()
+	defer ts.transcodingMu.RUnlock()
+
+	return ts.transcodingCheck != nil && ts.transcodingCheck(movieID)
+}
+
+// recordSeedingStats stores the upload statistics of a torrent on its
+// DownloadedMovie row and returns the current ratio.
+func (ts *TorrentService) recordSeedingStats(dl *models.TorrentDownload, seedingSince time.Time, stoppedAt *time.Time) float64 {
+	stats := dl.Torrent.Stats()
+	uploaded := stats.BytesWrittenData.Int64()
+	downloaded := stats.BytesReadUsefulData.Int64()
+
+	ratio := 0.0
+	if length := dl.Torrent.Length(); length > 0 {
+		ratio = float64(uploaded) / float64(length)
+	}
+
+	updates := map[string]interface{}{
+		"bytes_uploaded":     uploaded,
+		"bytes_downloaded":   downloaded,
+		"seed_ratio":         ratio,
+		"seeding_started_at": seedingSince,
+	}
+	if stoppedAt != nil {
+		updates["seeding_stopped_at"] = *stoppedAt
+	}
+
+	if err := ts.db.Model(&models.DownloadedMovie{}).
+		Where("movie_id = ? AND file_path = ?", dl.MovieID, dl.FilePath).
+		Updates(updates).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to record seeding stats for movie %d: %v", dl.MovieID, err))
+	}
+
+	return ratio
+}
+
+func (ts *TorrentService) stopSeeding(dl *models.TorrentDownload, reason string) {
+	dl.Mu.RLock()
+	seedingSince := time.Now()
+	if dl.SeedingSince != nil {
+		seedingSince = *dl.SeedingSince
+	}
+	dl.Mu.RUnlock()
+
+	now := time.Now()
+	ratio := ts.recordSeedingStats(dl, seedingSince, &now)
+	Logger.Info(fmt.Sprintf("Stopped seeding movie %d (ratio %.2f): %s", dl.MovieID, ratio, reason))
+
+	ts.dropDownload(dl)
+}
+
+// MarkTranscoded records that the HLS output of a movie file is complete. The
+// active torrent of the movie is only marked when it is the one that file
+// came from, as another source may have been transcoded meanwhile.
+func (ts *TorrentService) MarkTranscoded(movieID int, filePath string) {
+	dl, ok := ts.ActiveDownload(movieID)
+	if !ok {
+		return
+	}
+
+	dl.Mu.Lock()
+	if filePath == "" || dl.FilePath != filePath {
+		dl.Mu.Unlock()
+		return
+	}
+	dl.Transcoded = true
+	completed := dl.Status == "completed" || dl.Status == "seeding"
+	dl.Mu.Unlock()
+
+	if completed && !Conf.TORRENT.Seeding.Enabled {
+		ts.stopSeeding(dl, "seeding disabled")
+	}
+}
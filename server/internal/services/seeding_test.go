@@ -0,0 +1,79 @@
+package services
+
+import (
+	"server/internal/models"
+	"testing"
+	"time"
+)
+
+func TestSeedingStopReason(t *testing.T) {
+	saved := Conf.TORRENT.Seeding
+	defer func() { Conf.TORRENT.Seeding = saved }()
+
+	Conf.TORRENT.Seeding.RatioTarget = 1.0
+	Conf.TORRENT.Seeding.MinSeedTime = time.Hour
+	Conf.TORRENT.Seeding.MaxSeedTime = 48 * time.Hour
+
+	tests := []struct {
+		name     string
+		disabled bool
+		noMax    bool
+		over     bool
+		elapsed  time.Duration
+		ratio    float64
+		want     string
+	}{
+		{name: "disabled", disabled: true, elapsed: time.Minute, want: "seeding disabled"},
+		{name: "fresh", elapsed: time.Minute, ratio: 0.1},
+		{name: "ratio met before minimum time", elapsed: 30 * time.Minute, ratio: 2},
+		{name: "ratio met after minimum time", elapsed: 2 * time.Hour, ratio: 1, want: "ratio target reached"},
+		{name: "ratio not met", elapsed: 2 * time.Hour, ratio: 0.5},
+		{name: "maximum time reached", elapsed: 48 * time.Hour, ratio: 0.2, want: "maximum seed time reached"},
+		{name: "no maximum time", noMax: true, elapsed: 480 * time.Hour, ratio: 0.2},
+		{name: "over budget", over: true, elapsed: time.Minute, ratio: 0.1, want: "download budget exceeded"},
+		{name: "disabled over budget", disabled: true, over: true, elapsed: time.Minute, want: "seeding disabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Conf.TORRENT.Seeding.Enabled = !tt.disabled
+			Conf.TORRENT.Seeding.MaxSeedTime = 48 * time.Hour
+			if tt.noMax {
+				Conf.TORRENT.Seeding.MaxSeedTime = 0
+			}
+
+			if got := seedingStopReason(tt.elapsed, tt.ratio, tt.over); got != tt.want {
+				t.Errorf("seedingStopReason(%v, %v, %v) = %q, want %q", tt.elapsed, tt.ratio, tt.over, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkTranscoded(t *testing.T) {
+	saved := Conf.TORRENT.Seeding.Enabled
+	defer func() { Conf.TORRENT.Seeding.Enabled = saved }()
+	Conf.TORRENT.Seeding.Enabled = true
+
+	tests := []struct {
+		name     string
+		filePath string
+		want     bool
+	}{
+		{name: "active torrent", filePath: "/downloads/603/aaa/movie.mp4", want: true},
+		{name: "other source", filePath: "/downloads/603/bbb/movie.mkv"},
+		{name: "unknown file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &TorrentService{}
+			dl := &models.TorrentDownload{MovieID: 603, FilePath: "/downloads/603/aaa/movie.mp4", Status: "downloading"}
+			ts.Downloads.Store(downloadKey(603), dl)
+
+			ts.MarkTranscoded(603, tt.filePath)
+			if dl.Transcoded != tt.want {
+				t.Errorf("MarkTranscoded(%q) marked the active torrent %v, want %v", tt.filePath, dl.Transcoded, tt.want)
+			}
+		})
+	}
+}
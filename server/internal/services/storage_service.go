@@ -0,0 +1,505 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"server/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// streamPinWindow is how long after its last served file a stream counts
+	// as being watched, which pins its files.
+	streamPinWindow = 15 * time.Minute
+
+	defaultStorageCheckInterval = 10 * time.Minute
+)
+
+// StorageService keeps the download and HLS output directories within their
+// disk budgets by evicting the least recently watched movies first. Movies
+// being streamed are pinned, and database rows are removed along with the
+// files they point to.
+type StorageService struct {
+	db             *gorm.DB
+	torrentService *TorrentService
+	movieService   *MovieService
+
+	mu           sync.Mutex
+	lastEnforced time.Time
+	lastEvicted  []StorageItem
+}
+
+// StorageReport describes the disk usage of the downloads and the HLS output.
+type StorageReport struct {
+	Downloads    StorageUsage  `json:"downloads"`
+	HLS          StorageUsage  `json:"hls"`
+	MaxIdle      string        `json:"max_idle" example:"720h0m0s"`
+	LastEnforced *time.Time    `json:"last_enforced,omitempty"`
+	LastEvicted  []StorageItem `json:"last_evicted"`
+}
+
+// StorageUsage is the usage of one storage directory.
+type StorageUsage struct {
+	Directory string        `json:"directory" example:"/app/downloads"`
+	Budget    int64         `json:"budget" example:"214748364800"` // bytes, 0 means unlimited
+	Used      int64         `json:"used" example:"53687091200"`
+	Items     []StorageItem `json:"items"`
+}
+
+// StorageItem is a set of files that is evicted as a whole: the download of
+// a source, or the HLS output of a stream.
+type StorageItem struct {
+	MovieID  int       `json:"movie_id" example:"603"`
+	SourceID uint      `json:"source_id,omitempty" example:"12"`
+	Path     string    `json:"path" example:"/app/downloads/603/7b1b0a2c"`
+	Size     int64     `json:"size" example:"2147483648"`
+	LastUsed time.Time `json:"last_used"`
+	Pinned   bool      `json:"pinned"`
+	Library  bool      `json:"library,omitempty"`
+}
+
+func NewStorageService(db *gorm.DB, torrentService *TorrentService, movieService *MovieService) *StorageService {
+	ss := &StorageService{
+		db:             db,
+		torrentService: torrentService,
+		movieService:   movieService,
+	}
+
+	go ss.enforceWorker()
+
+	return ss
+}
+
+func (ss *StorageService) enforceWorker() {
+	interval := Conf.STORAGE.CheckInterval
+	if interval <= 0 {
+		interval = defaultStorageCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ss.Enforce()
+	}
+}
+
+// Report returns the current disk usage. It only records when streams were
+// last served: nothing is deleted, the eviction worker and the reconciler
+// clean up.
+func (ss *StorageService) Report() StorageReport {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.recordStreamAccess()
+
+	report := StorageReport{
+		Downloads:   ss.downloadUsage(false),
+		HLS:         ss.hlsUsage(false),
+		MaxIdle:     Conf.STORAGE.MaxIdle.String(),
+		LastEvicted: ss.lastEvicted,
+	}
+	if !ss.lastEnforced.IsZero() {
+		lastEnforced := ss.lastEnforced
+		report.LastEnforced = &lastEnforced
+	}
+	if report.LastEvicted == nil {
+		report.LastEvicted = []StorageItem{}
+	}
+
+	return report
+}
+
+// Enforce evicts idle items and then the least recently watched ones until
+// both directories fit their budget. It returns the evicted items.
+func (ss *StorageService) Enforce() []StorageItem {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.recordStreamAccess()
+
+	evicted := []StorageItem{}
+	evicted = append(evicted, ss.evict(ss.downloadUsage(true), ss.evictDownload)...)
+	// Evicting downloads also removes the output of their sources.
+	evicted = append(evicted, ss.evict(ss.hlsUsage(true), ss.evictHLS)...)
+
+	ss.lastEnforced = time.Now()
+	ss.lastEvicted = evicted
+
+	return evicted
+}
+
+func (ss *StorageService) evict(usage StorageUsage, remove func(StorageItem) error) []StorageItem {
+	evicted := []StorageItem{}
+	used := usage.Used
+
+	// Items are sorted least recently used first.
+	for _, item := range usage.Items {
+		if item.Pinned {
+			continue
+		}
+
+		overBudget := usage.Budget > 0 && used > usage.Budget
+		idle := Conf.STORAGE.MaxIdle > 0 && time.Since(item.LastUsed) > Conf.STORAGE.MaxIdle
+		if !overBudget && !idle {
+			continue
+		}
+
+		if err := remove(item); err != nil {
+			Logger.Error(fmt.Sprintf("Failed to evict %s: %v", item.Path, err))
+			continue
+		}
+
+		reason := "not watched since " + item.LastUsed.Format(time.DateOnly)
+		if overBudget {
+			reason = "over budget"
+		}
+		Logger.Info(fmt.Sprintf("Evicted %s of movie %d (%d bytes): %s", item.Path, item.MovieID, item.Size, reason))
+
+		used -= item.Size
+		evicted = append(evicted, item)
+	}
+
+	return evicted
+}
+
+// recordStreamAccess stores when streams were last served on the sources
+// they play, so the eviction order survives restarts.
+func (ss *StorageService) recordStreamAccess() {
+	ss.movieService.StreamAccess.Range(func(k, value interface{}) bool {
+		key := k.(StreamKey)
+		accessedAt := value.(time.Time)
+
+		sourceID := key.SourceID
+		if sourceID == 0 {
+			source, ok := ss.torrentService.PreferredSource(key.MovieID)
+			if !ok {
+				return true
+			}
+			sourceID = source.ID
+		}
+
+		ss.db.Model(&models.DownloadedMovie{}).
+			Where("id = ? AND last_watched < ?", sourceID, accessedAt).
+			Update("last_watched", accessedAt)
+
+		return true
+	})
+}
+
+// downloadUsage lists the downloaded sources of every movie. When pruning,
+// rows whose file is gone are deleted.
+func (ss *StorageService) downloadUsage(prune bool) StorageUsage {
+	downloadDir := ss.torrentService.downloadDir
+	usage := StorageUsage{
+		Directory: downloadDir,
+		Budget:    Conf.STORAGE.DownloadBudget,
+		Items:     []StorageItem{},
+	}
+
+	used, err := DirSize(downloadDir)
+	if err != nil {
+		Logger.Error(fmt.Sprintf("Failed to measure download directory: %v", err))
+	}
+	usage.Used = used
+
+	var rows []models.DownloadedMovie
+	if err := ss.db.Where("source = ?", "torrent").Find(&rows).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to list downloaded movies: %v", err))
+		return usage
+	}
+
+	for _, row := range rows {
+		path := downloadRoot(downloadDir, row)
+
+		size, err := DirSize(path)
+		if errors.Is(err, fs.ErrNotExist) && row.StorageKey != "" {
+			// Already evicted, the file is restored from its archive.
+			continue
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			if prune {
+				Logger.Info(fmt.Sprintf("Forgetting source %d of movie %d: %s no longer exists", row.ID, row.MovieID, path))
+				ss.db.Delete(&row)
+			}
+			continue
+		}
+		if err != nil {
+			Logger.Error(fmt.Sprintf("Failed to measure %s: %v", path, err))
+			continue
+		}
+
+		usage.Items = append(usage.Items, StorageItem{
+			MovieID:  row.MovieID,
+			SourceID: row.ID,
+			Path:     path,
+			Size:     size,
+			LastUsed: row.LastWatched,
+			Pinned:   ss.downloadPinned(row),
+		})
+	}
+
+	sortStorageItems(usage.Items)
+
+	return usage
+}
+
+// downloadRoot returns the directory a torrent was downloaded into, or the
+// file itself for downloads older than the per-torrent layout.
+func downloadRoot(downloadDir string, row models.DownloadedMovie) string {
+	if row.InfoHash != "" {
+		root := filepath.Join(downloadDir, strconv.Itoa(row.MovieID), row.InfoHash)
+		if withinDir(root, row.FilePath) {
+			return root
+		}
+	}
+	return row.FilePath
+}
+
+func (ss *StorageService) downloadPinned(row models.DownloadedMovie) bool {
+	if ss.movieService.StreamInUse(row.MovieID) {
+		return true
+	}
+
+	dl, ok := ss.torrentService.ActiveDownload(row.MovieID)
+	if !ok {
+		return false
+	}
+
+	dl.Mu.RLock()
+	defer dl.Mu.RUnlock()
+
+	// A torrent that is seeding a transcoded movie may be dropped.
+	if dl.FilePath == row.FilePath {
+		return !dl.Transcoded || (dl.Status != "completed" && dl.Status != "seeding")
+	}
+
+	return false
+}
+
+func (ss *StorageService) evictDownload(item StorageItem) error {
+	var row models.DownloadedMovie
+	if err := ss.db.First(&row, item.SourceID).Error; err != nil {
+		return err
+	}
+
+	if dl, ok := ss.torrentService.ActiveDownload(row.MovieID); ok && dl.FilePath == row.FilePath {
+		ss.torrentService.stopSeeding(dl, "storage budget exceeded")
+	}
+
+	if !withinDir(ss.torrentService.downloadDir, item.Path) {
+		return fmt.Errorf("%s is outside of the download directory", item.Path)
+	}
+	if err := os.RemoveAll(item.Path); err != nil {
+		return err
+	}
+	// Remove the movie directory once its last torrent is gone.
+	os.Remove(filepath.Join(ss.torrentService.downloadDir, strconv.Itoa(row.MovieID)))
+
+	// An archived source stays, its file is restored when it is streamed.
+	if row.StorageKey != "" {
+		return nil
+	}
+
+	if err := ss.db.Delete(&row).Error; err != nil {
+		return err
+	}
+
+	key := StreamKey{MovieID: row.MovieID, SourceID: row.ID}
+	ss.movieService.ForgetStream(key)
+	if err := os.RemoveAll(filepath.Join(hlsBaseDir(), key.Dir())); err != nil {
+		Logger.Error(fmt.Sprintf("Failed to remove HLS output of %s: %v", key, err))
+	}
+	if err := ss.movieService.UnpublishStream(key); err != nil {
+		Logger.Error(fmt.Sprintf("Failed to unpublish HLS output of %s: %v", key, err))
+	}
+
+	return nil
+}
+
+// hlsUsage lists the HLS output of every stream: the default stream of each
+// movie and the streams of its sources. The output of sources that no longer
+// exist is removed when pruning.
+func (ss *StorageService) hlsUsage(prune bool) StorageUsage {
+	hlsDir := hlsBaseDir()
+	usage := StorageUsage{
+		Directory: hlsDir,
+		Budget:    Conf.STORAGE.HLSBudget,
+		Items:     []StorageItem{},
+	}
+
+	entries, err := os.ReadDir(hlsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Logger.Error(fmt.Sprintf("Failed to read HLS output directory: %v", err))
+		}
+		return usage
+	}
+
+	for _, entry := range entries {
+		movieID, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		var rows []models.DownloadedMovie
+		ss.db.Where("movie_id = ?", movieID).Find(&rows)
+		sources := make(map[uint]models.DownloadedMovie, len(rows))
+		for _, row := range rows {
+			sources[row.ID] = row
+		}
+		pinned := ss.movieService.StreamInUse(movieID)
+
+		movieDir := filepath.Join(hlsDir, entry.Name())
+		sourceItems := ss.sourceHLSItems(movieID, movieDir, sources, pinned, prune)
+
+		if item, ok := defaultHLSItem(movieID, movieDir, rows, pinned); ok {
+			usage.Items = append(usage.Items, item)
+		}
+		usage.Items = append(usage.Items, sourceItems...)
+	}
+
+	for _, item := range usage.Items {
+		usage.Used += item.Size
+	}
+	sortStorageItems(usage.Items)
+
+	return usage
+}
+
+func (ss *StorageService) sourceHLSItems(movieID int, movieDir string, sources map[uint]models.DownloadedMovie, pinned, prune bool) []StorageItem {
+	entries, err := os.ReadDir(filepath.Join(movieDir, sourcesDir))
+	if err != nil {
+		return nil
+	}
+
+	items := []StorageItem{}
+	for _, entry := range entries {
+		path := filepath.Join(movieDir, sourcesDir, entry.Name())
+
+		sourceID, err := strconv.ParseUint(entry.Name(), 10, 64)
+		source, ok := sources[uint(sourceID)]
+		if err != nil || !ok {
+			if prune {
+				Logger.Info(fmt.Sprintf("Removing HLS output of a source movie %d no longer has: %s", movieID, path))
+				os.RemoveAll(path)
+				if err == nil {
+					ss.movieService.UnpublishStream(StreamKey{MovieID: movieID, SourceID: uint(sourceID)})
+				}
+			}
+			continue
+		}
+
+		size, err := DirSize(path)
+		if err != nil {
+			continue
+		}
+
+		items = append(items, StorageItem{
+			MovieID:  movieID,
+			SourceID: source.ID,
+			Path:     path,
+			Size:     size,
+			LastUsed: source.LastWatched,
+			Pinned:   pinned,
+			Library:  source.Source == "library",
+		})
+	}
+
+	return items
+}
+
+// defaultHLSItem returns the output of the default stream of a movie, which
+// is its HLS directory without the outputs of its sources.
+func defaultHLSItem(movieID int, movieDir string, rows []models.DownloadedMovie, pinned bool) (StorageItem, bool) {
+	entries, err := os.ReadDir(movieDir)
+	if err != nil {
+		return StorageItem{}, false
+	}
+
+	item := StorageItem{MovieID: movieID, Path: movieDir, Pinned: pinned}
+	found := false
+	for _, entry := range entries {
+		if entry.Name() == sourcesDir {
+			continue
+		}
+		found = true
+
+		path := filepath.Join(movieDir, entry.Name())
+		size, err := DirSize(path)
+		if err != nil {
+			continue
+		}
+		item.Size += size
+
+		if info, err := entry.Info(); err == nil && info.ModTime().After(item.LastUsed) {
+			item.LastUsed = info.ModTime()
+		}
+	}
+	if !found {
+		return StorageItem{}, false
+	}
+
+	// The stream plays one of the movie's sources, which records when it was
+	// watched. Output without any source left only has its files' age.
+	if len(rows) > 0 {
+		item.LastUsed = time.Time{}
+		for _, row := range rows {
+			if row.LastWatched.After(item.LastUsed) {
+				item.LastUsed = row.LastWatched
+			}
+			item.Library = item.Library || row.Source == "library"
+		}
+	}
+
+	return item, true
+}
+
+func (ss *StorageService) evictHLS(item StorageItem) error {
+	key := StreamKey{MovieID: item.MovieID, SourceID: item.SourceID}
+
+	if key.SourceID != 0 {
+		ss.movieService.ForgetStream(key)
+		if err := os.RemoveAll(item.Path); err != nil {
+			return err
+		}
+	} else {
+		if err := ss.movieService.ResetMovieStream(key.MovieID); err != nil {
+			return err
+		}
+		// Only removed when no source output is left in it.
+		os.Remove(filepath.Join(item.Path, sourcesDir))
+		os.Remove(item.Path)
+	}
+
+	query := ss.db.Model(&models.DownloadedMovie{}).Where("movie_id = ?", key.MovieID)
+	if key.SourceID != 0 {
+		query = query.Where("id = ?", key.SourceID)
+	}
+	return query.Update("transcoded", false).Error
+}
+
+// sortStorageItems orders items least recently used first.
+func sortStorageItems(items []StorageItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].LastUsed.Before(items[j].LastUsed)
+	})
+}
+
+// withinDir reports whether path is inside dir.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStorageEviction(t *testing.T) {
+	saved := Conf.STORAGE.MaxIdle
+	defer func() { Conf.STORAGE.MaxIdle = saved }()
+
+	now := time.Now()
+	items := []StorageItem{
+		{Path: "recent", Size: 30, LastUsed: now.Add(-time.Hour)},
+		{Path: "pinned", Size: 30, LastUsed: now.Add(-72 * time.Hour), Pinned: true},
+		{Path: "old", Size: 30, LastUsed: now.Add(-48 * time.Hour)},
+		{Path: "failing", Size: 30, LastUsed: now.Add(-60 * time.Hour)},
+		{Path: "newest", Size: 30, LastUsed: now},
+	}
+	sortStorageItems(items)
+
+	var order []string
+	for _, item := range items {
+		order = append(order, item.Path)
+	}
+	if want := []string{"pinned", "failing", "old", "recent", "newest"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("sortStorageItems = %v, want %v", order, want)
+	}
+
+	tests := []struct {
+		name    string
+		budget  int64
+		maxIdle time.Duration
+		want    []string
+	}{
+		{name: "within budget", budget: 200},
+		{name: "unlimited", budget: 0},
+		{name: "over budget", budget: 130, want: []string{"old"}},
+		{name: "far over budget", budget: 100, want: []string{"old", "recent"}},
+		{name: "nothing left to evict", budget: 10, want: []string{"old", "recent", "newest"}},
+		{name: "idle", budget: 200, maxIdle: 24 * time.Hour, want: []string{"old"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Conf.STORAGE.MaxIdle = tt.maxIdle
+			usage := StorageUsage{Budget: tt.budget, Used: 150, Items: items}
+
+			evicted := (&StorageService{}).evict(usage, func(item StorageItem) error {
+				if item.Path == "failing" {
+					return errors.New("busy")
+				}
+				return nil
+			})
+
+			var got []string
+			for _, item := range evicted {
+				got = append(got, item.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evicted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
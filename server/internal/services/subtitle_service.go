@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"server/internal/models"
+	"server/internal/release"
 	"strings"
 	"time"
 
@@ -74,7 +75,9 @@ func (s *SubtitleService) CreateSubtitlesDirectory(movieID int, baseDir string)
 	return dirPath, nil
 }
 
-func (s *SubtitleService) DownloadSubtitles(tmdbID int, outputDir string) int {
+// DownloadSubtitles downloads a subtitle per language. When the release name
+// of the video is known, the subtitle made for the closest release is used.
+func (s *SubtitleService) DownloadSubtitles(tmdbID int, outputDir string, releaseName string) int {
 	languages := []string{"en", "fr", "es"}
 
 	downloadedCount := 0
@@ -123,7 +126,7 @@ func (s *SubtitleService) DownloadSubtitles(tmdbID int, outputDir string) int {
 			continue
 		}
 
-		subtitle := searchResult.Subtitles[0]
+		subtitle := bestSubtitle(searchResult.Subtitles, releaseName)
 
 		downloadURL := fmt.Sprintf("https://dl.subdl.com%s.zip", subtitle.URL)
 
@@ -158,6 +161,29 @@ func (s *SubtitleService) DownloadSubtitles(tmdbID int, outputDir string) int {
 	return downloadedCount
 }
 
+// bestSubtitle returns the subtitle whose release is the most similar to the
+// given one, the first subtitle when there is no release name.
+func bestSubtitle(subtitles []SubdlSubtitleInfo, releaseName string) SubdlSubtitleInfo {
+	best := subtitles[0]
+	if releaseName == "" {
+		return best
+	}
+
+	target := release.Parse(releaseName)
+	bestScore := -1
+	for _, subtitle := range subtitles {
+		name := subtitle.ReleaseName
+		if name == "" {
+			name = subtitle.Name
+		}
+		if score := release.Similarity(target, release.Parse(name)); score > bestScore {
+			best, bestScore = subtitle, score
+		}
+	}
+
+	return best
+}
+
 func ConvertSRTtoVTT(srtPath string, w io.Writer) {
 	file, err := os.Open(srtPath)
 	if err != nil {
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"server/internal/models"
+	"strings"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/types/infohash"
+)
+
+// TorrentImport is what an administrator attaches to a movie: either a magnet
+// link or the content of a .torrent file, and optionally the file to stream.
+type TorrentImport struct {
+	MovieID     int
+	MagnetLink  string
+	TorrentFile []byte
+	FilePath    string
+	CreatedBy   uint
+}
+
+// TorrentOverrideInfo is an override together with the files of its torrent,
+// when they are known.
+type TorrentOverrideInfo struct {
+	Override models.TorrentOverride `json:"override"`
+	Files    []TorrentFileInfo      `json:"files"`
+}
+
+type TorrentFileInfo struct {
+	Path   string `json:"path" example:"The.Matrix.1999.1080p.BluRay.x264.mkv"`
+	Length int64  `json:"length" example:"2147483648"`
+}
+
+// TorrentOverride returns the torrent chosen by an administrator for a movie.
+func (ts *TorrentService) TorrentOverride(movieID int) (*models.TorrentOverride, bool) {
+	var override models.TorrentOverride
+	if err := ts.db.Where("movie_id = ?", movieID).First(&override).Error; err != nil {
+		return nil, false
+	}
+	return &override, true
+}
+
+// ImportTorrent attaches a magnet link or .torrent file to a movie and starts
+// downloading it in place of any torrent the movie was using.
+func (ts *TorrentService) ImportTorrent(imp TorrentImport) (*TorrentOverrideInfo, error) {
+	override := models.TorrentOverride{
+		MovieID:   imp.MovieID,
+		FilePath:  imp.FilePath,
+		CreatedBy: imp.CreatedBy,
+	}
+
+	var files []TorrentFileInfo
+
+	switch {
+	case len(imp.TorrentFile) > 0:
+		mi, err := metainfo.Load(bytes.NewReader(imp.TorrentFile))
+		if err != nil {
+			return nil, fmt.Errorf("invalid torrent file: %w", err)
+		}
+		info, err := mi.UnmarshalInfo()
+		if err != nil {
+			return nil, fmt.Errorf("invalid torrent info: %w", err)
+		}
+		if !info.HasV1() {
+			return nil, fmt.Errorf("only v1 and hybrid torrents are supported")
+		}
+
+		files = torrentFilesFromInfo(&info)
+		if imp.FilePath != "" && !containsTorrentFile(files, imp.FilePath) {
+			return nil, fmt.Errorf("file %q is not part of the torrent", imp.FilePath)
+		}
+
+		override.InfoHash = mi.HashInfoBytes().HexString()
+		override.Name = info.BestName()
+		override.TorrentFile = imp.TorrentFile
+	case imp.MagnetLink != "":
+		spec, err := torrent.TorrentSpecFromMagnetUri(imp.MagnetLink)
+		if err != nil {
+			return nil, fmt.Errorf("invalid magnet link: %w", err)
+		}
+		if spec.InfoHash == (infohash.T{}) {
+			return nil, fmt.Errorf("magnet link has no v1 infohash")
+		}
+
+		override.InfoHash = spec.InfoHash.HexString()
+		override.Name = spec.DisplayName
+		override.MagnetLink = imp.MagnetLink
+	default:
+		return nil, fmt.Errorf("a magnet link or a torrent file is required")
+	}
+
+	var existing models.TorrentOverride
+	if err := ts.db.Where("movie_id = ?", imp.MovieID).First(&existing).Error; err == nil {
+		override.ID = existing.ID
+		override.CreatedAt = existing.CreatedAt
+	}
+	if err := ts.db.Save(&override).Error; err != nil {
+		return nil, fmt.Errorf("failed to save torrent override: %w", err)
+	}
+
+	// Another torrent of the movie makes way for the imported one, which
+	// becomes the default. A download still running is dropped with its
+	// partial files; a completed one stops seeding and its source stays
+	// available.
+	if dl, ok := ts.ActiveDownload(imp.MovieID); ok && (dl.Torrent == nil || dl.Torrent.InfoHash().HexString() != override.InfoHash) {
+		dl.Mu.RLock()
+		completed := dl.Status == "completed" || dl.Status == "seeding"
+		dl.Mu.RUnlock()
+
+		if completed {
+			ts.stopSeeding(dl, "replaced by an imported torrent")
+		} else if err := ts.removeDownload(dl); err != nil {
+			Logger.Error(fmt.Sprintf("Failed to remove the replaced download of movie %d: %v", imp.MovieID, err))
+		}
+	}
+
+	Logger.Info(fmt.Sprintf("Torrent %s attached to movie %d by user %d", override.InfoHash, imp.MovieID, imp.CreatedBy))
+
+	go func() {
+		if _, err := ts.GetOrStartDownload(imp.MovieID, override.InfoHash); err != nil {
+			Logger.Error(fmt.Sprintf("Failed to start imported torrent for movie %d: %v", imp.MovieID, err))
+		}
+	}()
+
+	return &TorrentOverrideInfo{Override: override, Files: files}, nil
+}
+
+// TorrentOverrideInfo returns the override of a movie with the files of its
+// torrent, read from the .torrent file or from the running download.
+func (ts *TorrentService) TorrentOverrideInfo(movieID int) (*TorrentOverrideInfo, bool) {
+	override, ok := ts.TorrentOverride(movieID)
+	if !ok {
+		return nil, false
+	}
+
+	result := &TorrentOverrideInfo{Override: *override, Files: []TorrentFileInfo{}}
+
+	if len(override.TorrentFile) > 0 {
+		if mi, err := metainfo.Load(bytes.NewReader(override.TorrentFile)); err == nil {
+			if info, err := mi.UnmarshalInfo(); err == nil {
+				result.Files = torrentFilesFromInfo(&info)
+			}
+		}
+		return result, true
+	}
+
+	if dl, ok := ts.ActiveDownload(movieID); ok && dl.Torrent != nil && dl.Torrent.Info() != nil {
+		for _, f := range dl.Torrent.Files() {
+			result.Files = append(result.Files, TorrentFileInfo{Path: f.DisplayPath(), Length: f.Length()})
+		}
+	}
+
+	return result, true
+}
+
+// DeleteTorrentOverride brings a movie back to automatic torrent selection.
+func (ts *TorrentService) DeleteTorrentOverride(movieID int) error {
+	return ts.db.Where("movie_id = ?", movieID).Delete(&models.TorrentOverride{}).Error
+}
+
+// torrentSpec builds what is added to the client for a movie, preferring the
+// torrent an administrator attached to it over the given infohash.
+func (ts *TorrentService) torrentSpec(movieID int, infoHash string) (*torrent.TorrentSpec, error) {
+	if override, ok := ts.TorrentOverride(movieID); ok {
+		if len(override.TorrentFile) > 0 {
+			mi, err := metainfo.Load(bytes.NewReader(override.TorrentFile))
+			if err != nil {
+				return nil, fmt.Errorf("invalid torrent file: %w", err)
+			}
+			return torrent.TorrentSpecFromMetaInfoErr(mi)
+		}
+		if override.MagnetLink != "" {
+			return torrent.TorrentSpecFromMagnetUri(override.MagnetLink)
+		}
+		infoHash = override.InfoHash
+	}
+
+	hashBytes, err := hex.DecodeString(infoHash)
+	if err != nil || len(hashBytes) != len(infohash.T{}) {
+		return nil, fmt.Errorf("invalid infohash format: %s", infoHash)
+	}
+
+	var ih infohash.T
+	copy(ih[:], hashBytes)
+
+	return &torrent.TorrentSpec{AddTorrentOpts: torrent.AddTorrentOpts{InfoHash: ih}}, nil
+}
+
+func torrentFilesFromInfo(info *metainfo.Info) []TorrentFileInfo {
+	files := []TorrentFileInfo{}
+	for _, fi := range info.UpvertedFiles() {
+		files = append(files, TorrentFileInfo{Path: fi.DisplayPath(info), Length: fi.Length})
+	}
+	return files
+}
+
+func containsTorrentFile(files []TorrentFileInfo, path string) bool {
+	for _, f := range files {
+		if strings.EqualFold(f.Path, path) {
+			return true
+		}
+	}
+	return false
+}
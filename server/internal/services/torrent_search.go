@@ -0,0 +1,60 @@
+package services
+
+import (
+	"regexp"
+	"server/internal/release"
+	"sort"
+	"strconv"
+)
+
+// minTorrentSeeders is the number of seeders under which a torrent would
+// stall the stream. Such torrents are only picked when no other is found.
+const minTorrentSeeders = 5
+
+// torrentioSeeders matches the seeder count torrentio appends to its titles,
+// as in "Name\n👤 123 💾 2.1 GB ⚙️ ThePirateBay".
+var torrentioSeeders = regexp.MustCompile(`👤\s*(\d+)`)
+
+// parseSeeders returns the seeder count of a torrentio title, 0 when it has
+// none.
+func parseSeeders(title string) int {
+	match := torrentioSeeders.FindStringSubmatch(title)
+	if match == nil {
+		return 0
+	}
+	seeders, _ := strconv.Atoi(match[1])
+	return seeders
+}
+
+// rankTorrents orders torrents from the best to stream to the worst: those
+// with enough seeders first, then by release quality, then by seeders.
+func rankTorrents(torrents []TorrentSearchResult) {
+	type rankedTorrent struct {
+		TorrentSearchResult
+		seeded bool
+		score  int
+	}
+
+	ranked := make([]rankedTorrent, len(torrents))
+	for i, t := range torrents {
+		ranked[i] = rankedTorrent{
+			TorrentSearchResult: t,
+			seeded:              t.Seeders >= minTorrentSeeders,
+			score:               release.Parse(t.Name).Score(),
+		}
+	}
+
+	sort.SliceStable(ranked, func(a, b int) bool {
+		if ranked[a].seeded != ranked[b].seeded {
+			return ranked[a].seeded
+		}
+		if ranked[a].score != ranked[b].score {
+			return ranked[a].score > ranked[b].score
+		}
+		return ranked[a].Seeders > ranked[b].Seeders
+	})
+
+	for i, t := range ranked {
+		torrents[i] = t.TorrentSearchResult
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSeeders(t *testing.T) {
+	tests := []struct {
+		title string
+		want  int
+	}{
+		{title: "Some.Movie.2012.1080p.BluRay.x264-GRP\n👤 123 💾 2.1 GB ⚙️ ThePirateBay", want: 123},
+		{title: "Some.Movie.2012.720p.WEBRip.x264-GRP\n👤 0 💾 900 MB ⚙️ 1337x", want: 0},
+		{title: "Some.Movie.2012.720p.WEBRip.x264-GRP", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseSeeders(tt.title); got != tt.want {
+			t.Errorf("parseSeeders(%q) = %d, want %d", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestRankTorrents(t *testing.T) {
+	torrents := []TorrentSearchResult{
+		{InfoHash: "a", Name: "Some.Movie.2012.720p.WEBRip.x264-A", Seeders: 250},
+		{InfoHash: "b", Name: "Some.Movie.2012.1080p.BluRay.x264-B", Seeders: 1},
+		{InfoHash: "c", Name: "Some.Movie.2012.1080p.BluRay.x264-C", Seeders: 40},
+		{InfoHash: "d", Name: "Some.Movie.2012.1080p.BluRay.x264-D", Seeders: 90},
+		{InfoHash: "e", Name: "Some.Movie.2012.HDCAM.x264-E", Seeders: 500},
+		{InfoHash: "f", Name: "Some.Movie.2012.720p.WEBRip.x264-F", Seeders: 3},
+	}
+
+	rankTorrents(torrents)
+
+	var got []string
+	for _, t := range torrents {
+		got = append(got, t.InfoHash)
+	}
+	// Well seeded releases first, by quality then seeders; torrents under
+	// the floor last.
+	if want := []string{"d", "c", "a", "e", "b", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rankTorrents = %v, want %v", got, want)
+	}
+}
@@ -1,12 +1,13 @@
 package services
 
 import (
-	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"server/internal/models"
+	"server/internal/release"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,15 +15,35 @@ import (
 	anacrolixlog "github.com/anacrolix/log"
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/storage"
-	"github.com/anacrolix/torrent/types/infohash"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 	"gorm.io/gorm"
 )
 
+// minRateLimiterBurst must fit a whole chunk for uploads and the largest
+// single read for downloads.
+const minRateLimiterBurst = 1 << 20
+
 type TorrentService struct {
-	client      *torrent.Client
-	Downloads   sync.Map // map[string]*models.TorrentDownload
-	downloadDir string
-	db          *gorm.DB
+	client              *torrent.Client
+	Downloads           sync.Map // map[string]*models.TorrentDownload - downloadKey(movieID) -> download
+	starts              singleflight.Group
+	downloadDir         string
+	trackers            []string
+	uploadRateLimiter   *rate.Limiter
+	downloadRateLimiter *rate.Limiter
+	pieceCompletion     storage.PieceCompletion
+	db                  *gorm.DB
+
+	transcodingMu    sync.RWMutex
+	transcodingCheck func(movieID int) bool
+}
+
+// RateLimits are the global transfer limits in bytes per second, 0 meaning
+// unlimited.
+type RateLimits struct {
+	UploadRateLimit   int64 `json:"upload_rate_limit" example:"0"`
+	DownloadRateLimit int64 `json:"download_rate_limit" example:"5242880"`
 }
 
 func NewTorrentService(downloadDir string, db *gorm.DB) *TorrentService {
@@ -30,140 +51,374 @@ func NewTorrentService(downloadDir string, db *gorm.DB) *TorrentService {
 		log.Fatalf("Failed to create download directory: %v", err)
 	}
 
+	uploadRateLimiter := newRateLimiter(Conf.TORRENT.UploadRateLimit)
+	downloadRateLimiter := newRateLimiter(Conf.TORRENT.DownloadRateLimit)
+
 	cfg := torrent.NewDefaultClientConfig()
 	cfg.DataDir = downloadDir
-	cfg.Seed = true
+	cfg.Seed = Conf.TORRENT.Seeding.Enabled
 	cfg.Debug = false
-	cfg.ListenPort = 0
+	cfg.ListenPort = Conf.TORRENT.ListenPort
 
-	// Disable IPv6 to prevent "network unreachable" errors
-	cfg.DisableIPv6 = true
-	cfg.DisableUTP = false
-	cfg.NoDHT = false
-	cfg.DisablePEX = false
+	cfg.DisableIPv6 = Conf.TORRENT.DisableIPv6
+	cfg.DisableUTP = Conf.TORRENT.DisableUTP
+	cfg.NoDHT = Conf.TORRENT.NoDHT
+	cfg.DisablePEX = Conf.TORRENT.DisablePEX
 	cfg.NoDefaultPortForwarding = true // Disable UPnP/NAT-PMP port forwarding
 
+	cfg.UploadRateLimiter = uploadRateLimiter
+	cfg.DownloadRateLimiter = downloadRateLimiter
+	if Conf.TORRENT.MaxConnsPerTorrent > 0 {
+		cfg.EstablishedConnsPerTorrent = Conf.TORRENT.MaxConnsPerTorrent
+	}
+	if Conf.TORRENT.HalfOpenConnsPerTorrent > 0 {
+		cfg.HalfOpenConnsPerTorrent = Conf.TORRENT.HalfOpenConnsPerTorrent
+	}
+
 	// Configure torrent library to only log Critical level (effectively disabling most logs)
 	cfg.Logger = anacrolixlog.Default.FilterLevel(anacrolixlog.Disabled)
 
+	// Verified pieces are shared by every movie directory so a restart does
+	// not need to re-hash or re-download what was already completed. The
+	// client storage uses the same database, which can only be opened once.
+	pieceCompletion, err := storage.NewDefaultPieceCompletionForDir(downloadDir)
+	if err != nil {
+		Logger.Warn(fmt.Sprintf("Failed to open piece completion database, falling back to memory: %v", err))
+		pieceCompletion = storage.NewMapPieceCompletion()
+	}
+	cfg.DefaultStorage = storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir:   downloadDir,
+		PieceCompletion: pieceCompletion,
+	})
+
 	client, err := torrent.NewClient(cfg)
 	if err != nil {
 		log.Fatalf("Failed to start torrent client: %v", err)
 	}
 
 	ts := &TorrentService{
-		client:      client,
-		downloadDir: downloadDir,
-		db:          db,
+		client:              client,
+		downloadDir:         downloadDir,
+		trackers:            Conf.TORRENT.Trackers,
+		uploadRateLimiter:   uploadRateLimiter,
+		downloadRateLimiter: downloadRateLimiter,
+		pieceCompletion:     pieceCompletion,
+		db:                  db,
 	}
 
+	go ts.resumeActiveTorrents()
+	go ts.seedingWorker()
+	go ts.transferRateWorker()
+	go ts.directPlayProbeWorker()
+
 	return ts
 }
 
+func newRateLimiter(bytesPerSecond int64) *rate.Limiter {
+	l := rate.NewLimiter(rate.Inf, minRateLimiterBurst)
+	setRateLimit(l, bytesPerSecond)
+	return l
+}
+
+func setRateLimit(l *rate.Limiter, bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		l.SetLimit(rate.Inf)
+		l.SetBurst(minRateLimiterBurst)
+		return
+	}
+
+	l.SetLimit(rate.Limit(bytesPerSecond))
+	l.SetBurst(int(max(bytesPerSecond, minRateLimiterBurst)))
+}
+
+func limitToBytesPerSecond(l *rate.Limiter) int64 {
+	if l.Limit() == rate.Inf {
+		return 0
+	}
+	return int64(l.Limit())
+}
+
+// RateLimits returns the global transfer limits currently applied.
+func (ts *TorrentService) RateLimits() RateLimits {
+	return RateLimits{
+		UploadRateLimit:   limitToBytesPerSecond(ts.uploadRateLimiter),
+		DownloadRateLimit: limitToBytesPerSecond(ts.downloadRateLimiter),
+	}
+}
+
+// SetRateLimits changes the global transfer limits of the running client.
+func (ts *TorrentService) SetRateLimits(limits RateLimits) {
+	setRateLimit(ts.uploadRateLimiter, limits.UploadRateLimit)
+	setRateLimit(ts.downloadRateLimiter, limits.DownloadRateLimit)
+	Logger.Info(fmt.Sprintf("Torrent rate limits set to %d B/s up, %d B/s down", limits.UploadRateLimit, limits.DownloadRateLimit))
+}
+
+// resumeActiveTorrents re-adds every torrent that was still downloading when
+// the server stopped.
+func (ts *TorrentService) resumeActiveTorrents() {
+	var active []models.ActiveTorrent
+	if err := ts.db.Find(&active).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to load active torrents: %v", err))
+		return
+	}
+
+	for _, at := range active {
+		Logger.Info(fmt.Sprintf("Resuming download for movie %d (%s)", at.MovieID, at.InfoHash))
+		go func(at models.ActiveTorrent) {
+			if _, err := ts.GetOrStartDownload(at.MovieID, at.InfoHash); err != nil {
+				Logger.Error(fmt.Sprintf("Failed to resume download for movie %d: %v", at.MovieID, err))
+			}
+		}(at)
+	}
+}
+
+// trackActiveTorrent persists the torrent a movie is being downloaded from.
+// The selected file is kept when the same torrent is added again, which
+// reports it as resumed.
+func (ts *TorrentService) trackActiveTorrent(movieID int, infoHash string) bool {
+	var active models.ActiveTorrent
+	err := ts.db.Where("movie_id = ?", movieID).First(&active).Error
+	if err == nil && active.InfoHash == infoHash {
+		return true
+	}
+
+	active.MovieID = movieID
+	active.InfoHash = infoHash
+	active.FilePath = ""
+	if err := ts.db.Save(&active).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to persist active torrent for movie %d: %v", movieID, err))
+	}
+	return false
+}
+
+func (ts *TorrentService) untrackActiveTorrent(movieID int) {
+	if err := ts.db.Where("movie_id = ?", movieID).Delete(&models.ActiveTorrent{}).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to remove active torrent for movie %d: %v", movieID, err))
+	}
+}
+
+// downloadKey is the key of a movie in the download registry.
+func downloadKey(movieID int) string {
+	return strconv.Itoa(movieID)
+}
+
+// ActiveDownload returns the registered download of a movie, if any.
+func (ts *TorrentService) ActiveDownload(movieID int) (*models.TorrentDownload, bool) {
+	value, ok := ts.Downloads.Load(downloadKey(movieID))
+	if !ok {
+		return nil, false
+	}
+	dl, ok := value.(*models.TorrentDownload)
+	return dl, ok
+}
+
+// GetOrStartDownload returns the download of a movie, starting it when there
+// is none. Concurrent callers for the same movie share a single start.
+// The download is returned once its video file is known.
 func (ts *TorrentService) GetOrStartDownload(movieID int, infoHash string) (*models.TorrentDownload, error) {
-	downloadKey := fmt.Sprintf("%d", movieID)
-
-	var downloadedMovie models.DownloadedMovie
-	err := ts.db.Where("movie_id = ?", movieID).First(&downloadedMovie).Error
-	if err == nil && downloadedMovie.FilePath != "" {
-		if _, err := os.Stat(downloadedMovie.FilePath); err == nil {
-			ts.db.Model(&downloadedMovie).Update("last_watched", time.Now())
-
-			return &models.TorrentDownload{
-				MovieID:        movieID,
-				Progress:       100,
-				Status:         "completed",
-				StreamReady:    true,
-				StreamingReady: true,
-				FilePath:       downloadedMovie.FilePath,
-				CompletedAt:    &[]time.Time{time.Now()}[0],
-			}, nil
-		} else {
-			// log.Printf("Movie %d marked as downloaded but file missing, deleting database record", movieID)
-			ts.db.Delete(&downloadedMovie)
+	if dl, ok := ts.ActiveDownload(movieID); ok {
+		return awaitStart(dl)
+	}
+
+	v, err, _ := ts.starts.Do(downloadKey(movieID), func() (interface{}, error) {
+		if dl, ok := ts.ActiveDownload(movieID); ok {
+			return awaitStart(dl)
 		}
+		return ts.startDownload(movieID, infoHash)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	movieDownloadDir := filepath.Join(ts.downloadDir, fmt.Sprintf("%d", movieID))
-	if err := os.MkdirAll(movieDownloadDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create directory: %w", err)
+	return v.(*models.TorrentDownload), nil
+}
+
+// awaitStart waits until the monitor of a registered download selected its
+// video file.
+func awaitStart(dl *models.TorrentDownload) (*models.TorrentDownload, error) {
+	if dl.Started != nil {
+		<-dl.Started
 	}
 
-	hashBytes, err := hex.DecodeString(infoHash)
-	if err != nil {
-		return nil, fmt.Errorf("invalid infohash format: %w", err)
+	dl.Mu.RLock()
+	defer dl.Mu.RUnlock()
+	if dl.Status == "error" {
+		return nil, fmt.Errorf("failed to start the download of movie %d", dl.MovieID)
 	}
+	return dl, nil
+}
 
-	var ih infohash.T
-	copy(ih[:], hashBytes)
+// CompletedDownload returns a finished download for a movie whose file is
+// already on disk, whether it came from a torrent or from the library. When
+// an administrator attached a torrent to the movie, only that torrent counts.
+func (ts *TorrentService) CompletedDownload(movieID int) (*models.TorrentDownload, bool) {
+	if override, ok := ts.TorrentOverride(movieID); ok {
+		var source models.DownloadedMovie
+		if err := ts.db.Where("movie_id = ? AND info_hash = ?", movieID, override.InfoHash).First(&source).Error; err != nil {
+			return nil, false
+		}
+		return ts.SourceDownload(movieID, source.ID)
+	}
 
-	// log.Printf("Starting download for movie %d with infohash: %s", movieID, infoHash)
+	source, ok := ts.PreferredSource(movieID)
+	if !ok {
+		return nil, false
+	}
 
-	ops := torrent.AddTorrentOpts{
-		InfoHash: ih,
-		Storage:  storage.NewFile(movieDownloadDir),
+	return ts.SourceDownload(movieID, source.ID)
+}
+
+func (ts *TorrentService) startDownload(movieID int, infoHash string) (*models.TorrentDownload, error) {
+	if dl, ok := ts.CompletedDownload(movieID); ok {
+		return dl, nil
 	}
 
-	t, _ := ts.client.AddTorrentOpt(ops)
+	spec, err := ts.torrentSpec(movieID, infoHash)
+	if err != nil {
+		return nil, err
+	}
 
-	ts.addTrackersToTorrent(t)
+	// Each torrent gets its own directory so that the sources of a movie
+	// can be removed independently.
+	movieDownloadDir := filepath.Join(ts.downloadDir, fmt.Sprintf("%d", movieID), spec.InfoHash.HexString())
+	if err := os.MkdirAll(movieDownloadDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
 
-	<-t.GotInfo()
+	// log.Printf("Starting download for movie %d with infohash: %s", movieID, infoHash)
 
-	mi := t.Metainfo()
+	spec.Storage = storage.NewFileOpts(storage.NewFileClientOpts{
+		ClientBaseDir:   movieDownloadDir,
+		PieceCompletion: ts.pieceCompletion,
+	})
 
-	magnet, err := mi.MagnetV2()
+	t, isNew, err := ts.client.AddTorrentSpec(spec)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get magnet link: %w", err)
+		return nil, fmt.Errorf("failed to add torrent: %w", err)
+	}
+	if !isNew {
+		// The torrent is owned by the download of another movie, which
+		// already runs its monitor.
+		return nil, fmt.Errorf("torrent %s is already in use by another download", t.InfoHash().HexString())
 	}
+	resumed := ts.trackActiveTorrent(movieID, t.InfoHash().HexString())
+
+	ts.addTrackersToTorrent(t)
 
 	dl := &models.TorrentDownload{
 		Torrent:   t,
 		MovieID:   movieID,
 		Status:    "initializing",
 		StartedAt: time.Now(),
+		Started:   make(chan struct{}),
+		Resumed:   resumed,
 	}
 
-	ts.Downloads.Store(downloadKey, dl)
+	// The download is listed while its metadata is fetched, for its status
+	// to be reported; callers wait for it to start.
+	ts.Downloads.Store(downloadKey(movieID), dl)
 
-	go ts.monitorDownload(dl, downloadKey, magnet.String(), movieDownloadDir)
+	go ts.monitorDownload(dl, movieDownloadDir)
 
-	return dl, nil
+	return awaitStart(dl)
+}
+
+// monitorDownload is the only goroutine following a registered download.
+func (ts *TorrentService) monitorDownload(dl *models.TorrentDownload, movieDownloadDir string) {
+	magnet, ok := ts.prepareDownload(dl, movieDownloadDir)
+	close(dl.Started)
+	if !ok {
+		return
+	}
+
+	ts.prioritizeVideoFile(dl.VideoFile)
+
+	dl.Torrent.DownloadAll()
+
+	ts.monitorProgress(dl, magnet)
 }
 
-func (ts *TorrentService) monitorDownload(dl *models.TorrentDownload, downloadKey, magnet, movieDownloadDir string) {
-	// log.Printf("Starting monitor for %s", downloadKey)
+// prepareDownload waits for the metadata of a download and selects its video
+// file. It returns the magnet link of the torrent, or false once the download
+// was dropped.
+func (ts *TorrentService) prepareDownload(dl *models.TorrentDownload, movieDownloadDir string) (string, bool) {
+	// log.Printf("Starting monitor for %d", dl.MovieID)
 
 	select {
 	case <-dl.Torrent.GotInfo():
-		// log.Printf("Got torrent info for %s: %s", downloadKey, dl.Torrent.Name())
+		// log.Printf("Got torrent info for %d: %s", dl.MovieID, dl.Torrent.Name())
+	case <-dl.Torrent.Closed():
+		// Dropped while its metadata was fetched, by a removal or an import.
+		ts.handleDownloadError(dl, "torrent closed")
+		return "", false
 	case <-time.After(3 * time.Minute):
-		// log.Printf("Timeout waiting for torrent info for %s", downloadKey)
-		ts.handleDownloadError(dl, downloadKey, "metadata timeout")
-		return
+		// log.Printf("Timeout waiting for torrent info for %d", dl.MovieID)
+		ts.handleDownloadError(dl, "metadata timeout")
+		return "", false
 	}
 
-	videoFile := ts.findLargestVideoFile(dl.Torrent)
+	mi := dl.Torrent.Metainfo()
+	magnet, err := mi.MagnetV2()
+	if err != nil {
+		ts.handleDownloadError(dl, "failed to get magnet link")
+		return "", false
+	}
+
+	videoFile := ts.selectVideoFile(dl)
 	if videoFile == nil {
-		// log.Printf("No video file found in torrent for %s", downloadKey)
-		ts.handleDownloadError(dl, downloadKey, "no video file found")
-		return
+		// log.Printf("No video file found in torrent for %d", dl.MovieID)
+		ts.handleDownloadError(dl, "no video file found")
+		return "", false
 	}
 
 	dl.Mu.Lock()
 	dl.VideoFile = videoFile
+	dl.Quality = releaseQuality(videoFile.DisplayPath(), dl.Torrent.Name())
 	dl.FilePath = filepath.Join(movieDownloadDir, videoFile.Path())
 	dl.RootDir = movieDownloadDir
 	dl.Status = "downloading"
 	dl.Mu.Unlock()
 
+	ts.db.Model(&models.ActiveTorrent{}).Where("movie_id = ?", dl.MovieID).Update("file_path", videoFile.Path())
+
 	// log.Printf("Video file found: %s (%.2f MB)", videoFile.Path(), float64(videoFile.Length())/1024/1024)
 
-	ts.prioritizeVideoFile(videoFile)
+	return magnet.String(), true
+}
 
-	dl.Torrent.DownloadAll()
+// selectVideoFile returns the file chosen by an administrator or picked
+// before a restart when there is one, otherwise the largest video file of the
+// torrent.
+func (ts *TorrentService) selectVideoFile(dl *models.TorrentDownload) *torrent.File {
+	if override, ok := ts.TorrentOverride(dl.MovieID); ok && override.FilePath != "" {
+		for _, f := range dl.Torrent.Files() {
+			if strings.EqualFold(f.DisplayPath(), override.FilePath) || strings.EqualFold(f.Path(), override.FilePath) {
+				return f
+			}
+		}
+		Logger.Error(fmt.Sprintf("File %q chosen for movie %d is not in its torrent", override.FilePath, dl.MovieID))
+	}
+
+	var active models.ActiveTorrent
+	if err := ts.db.Where("movie_id = ?", dl.MovieID).First(&active).Error; err == nil && active.FilePath != "" {
+		for _, f := range dl.Torrent.Files() {
+			if f.Path() == active.FilePath {
+				return f
+			}
+		}
+	}
 
-	ts.monitorProgress(dl, downloadKey, magnet)
+	return ts.findLargestVideoFile(dl.Torrent)
+}
+
+// releaseQuality returns the quality of the first name it can be parsed from.
+func releaseQuality(names ...string) string {
+	for _, name := range names {
+		if quality := release.Parse(filepath.Base(name)).Quality(); quality != "" {
+			return quality
+		}
+	}
+	return ""
 }
 
 func (ts *TorrentService) findLargestVideoFile(t *torrent.Torrent) *torrent.File {
@@ -202,7 +457,7 @@ func (ts *TorrentService) prioritizeVideoFile(videoFile *torrent.File) {
 	videoFile.SetPriority(torrent.PiecePriorityNormal)
 }
 
-func (ts *TorrentService) monitorProgress(dl *models.TorrentDownload, downloadKey, magnet string) {
+func (ts *TorrentService) monitorProgress(dl *models.TorrentDownload, magnet string) {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
@@ -214,8 +469,8 @@ func (ts *TorrentService) monitorProgress(dl *models.TorrentDownload, downloadKe
 	for {
 		select {
 		case <-dl.Torrent.Closed():
-			// log.Printf("Torrent closed for %s", downloadKey)
-			ts.handleDownloadError(dl, downloadKey, "torrent closed")
+			// log.Printf("Torrent closed for %d", dl.MovieID)
+			ts.handleDownloadError(dl, "torrent closed")
 			return
 		case <-ticker.C:
 			completed := dl.Torrent.BytesCompleted()
@@ -231,7 +486,7 @@ func (ts *TorrentService) monitorProgress(dl *models.TorrentDownload, downloadKe
 				dl.StreamReady = true
 				dl.Status = "streaming"
 				dl.Mu.Unlock()
-				// log.Printf("Stream ready for %s (%.2f MB downloaded)", downloadKey, float64(completed)/1024/1024)
+				// log.Printf("Stream ready for %d (%.2f MB downloaded)", dl.MovieID, float64(completed)/1024/1024)
 				if dl.VideoFile != nil {
 					dl.VideoFile.SetPriority(torrent.PiecePriorityNormal)
 				}
@@ -243,7 +498,7 @@ func (ts *TorrentService) monitorProgress(dl *models.TorrentDownload, downloadKe
 				dl.CompletedAt = &now
 				dl.Mu.Unlock()
 
-				// log.Printf("Download completed for %s", downloadKey)
+				// log.Printf("Download completed for %d", dl.MovieID)
 				ts.saveDownloadedMovie(dl, magnet)
 				return
 			}
@@ -255,14 +510,14 @@ func (ts *TorrentService) monitorProgress(dl *models.TorrentDownload, downloadKe
 			}
 			lastProgress = currentProgress
 			if noProgressCount > 150 && currentProgress < 5 { // 150 * 2 seconds = 5 minutes
-				// log.Printf("Download appears stalled for %s", downloadKey)
-				ts.handleDownloadError(dl, downloadKey, "download stalled")
+				// log.Printf("Download appears stalled for %d", dl.MovieID)
+				ts.handleDownloadError(dl, "download stalled")
 				return
 			}
 
 			if currentProgress-lastLoggedPercent >= 5.0 || completed >= total {
-				// log.Printf("Download progress for %s: %.2f%% (%.2f/%.2f MB)",
-				// 	downloadKey, currentProgress,
+				// log.Printf("Download progress for %d: %.2f%% (%.2f/%.2f MB)",
+				// 	dl.MovieID, currentProgress,
 				// 	float64(completed)/1024/1024, float64(total)/1024/1024)
 				lastLoggedPercent = currentProgress
 			}
@@ -270,31 +525,28 @@ func (ts *TorrentService) monitorProgress(dl *models.TorrentDownload, downloadKe
 	}
 }
 
-func (ts *TorrentService) handleDownloadError(dl *models.TorrentDownload, downloadKey, reason string) {
+func (ts *TorrentService) handleDownloadError(dl *models.TorrentDownload, reason string) {
 	dl.Mu.Lock()
 	dl.Status = "error"
 	dl.Mu.Unlock()
 
-	ts.Downloads.Delete(downloadKey)
+	// A newer download of the same movie may already have replaced this one.
+	// A resumed torrent may only lack peers for now and is kept tracked.
+	if ts.Downloads.CompareAndDelete(downloadKey(dl.MovieID), dl) && !dl.Resumed {
+		ts.untrackActiveTorrent(dl.MovieID)
+	}
 
 	if dl.Torrent != nil {
 		dl.Torrent.Drop()
 	}
 
-	// log.Printf("Download error for %s: %s", downloadKey, reason)
+	// log.Printf("Download error for %d: %s", dl.MovieID, reason)
 }
 
 func (ts *TorrentService) addTrackersToTorrent(t *torrent.Torrent) {
-	// Use reliable UDP trackers only to avoid decode errors and IPv6 issues
-	trackers := [][]string{
-		{"udp://tracker.opentrackr.org:1337/announce"},
-		{"udp://open.stealth.si:80/announce"},
-		{"udp://tracker.openbittorrent.com:6969/announce"},
-		{"udp://opentracker.i2p.rocks:6969/announce"},
-		{"udp://tracker.internetwarriors.net:1337/announce"},
-		{"udp://tracker.leechers-paradise.org:6969/announce"},
-		{"udp://coppersurfer.tk:6969/announce"},
-		{"udp://tracker.zer0day.to:1337/announce"},
+	trackers := make([][]string, 0, len(ts.trackers))
+	for _, tracker := range ts.trackers {
+		trackers = append(trackers, []string{tracker})
 	}
 
 	t.AddTrackers(trackers)
@@ -307,34 +559,73 @@ func (ts *TorrentService) saveDownloadedMovie(dl *models.TorrentDownload, magnet
 		fileSize = fileInfo.Size()
 	}
 
+	stats := dl.Torrent.Stats()
+
 	downloadedMovie := models.DownloadedMovie{
-		MovieID:      dl.MovieID,
-		Quality:      dl.Quality,
-		FilePath:     dl.FilePath,
-		MagnetLink:   magnet,
-		DownloadedAt: time.Now(),
-		LastWatched:  time.Now(),
-		FileSize:     fileSize,
+		MovieID:         dl.MovieID,
+		Quality:         dl.Quality,
+		FilePath:        dl.FilePath,
+		ReleaseName:     dl.Torrent.Name(),
+		InfoHash:        dl.Torrent.InfoHash().HexString(),
+		MagnetLink:      magnet,
+		DownloadedAt:    time.Now(),
+		LastWatched:     time.Now(),
+		FileSize:        fileSize,
+		Source:          "torrent",
+		BytesUploaded:   stats.BytesWrittenData.Int64(),
+		BytesDownloaded: stats.BytesReadUsefulData.Int64(),
 	}
 
-	if err := ts.db.Where("movie_id = ? AND quality = ?", dl.MovieID, dl.Quality).
+	if err := ts.db.Where("movie_id = ? AND file_path = ?", dl.MovieID, dl.FilePath).
 		Assign(&downloadedMovie).
 		FirstOrCreate(&downloadedMovie).Error; err != nil {
 		// log.Printf("Error saving downloaded movie: %v", err)
+		return
+	}
+
+	ts.untrackActiveTorrent(dl.MovieID)
+	go ts.probeSource(downloadedMovie)
+
+	if Conf.MEDIA_STORAGE.ArchiveOriginals && downloadedMovie.StorageKey == "" {
+		go ts.archiveOriginal(downloadedMovie)
 	}
 }
 
-func (ts *TorrentService) RemoveTorrentFiles(movieID int, quality string, hlsOutputDir string) error {
-	downloadKey := fmt.Sprintf("%d-%s", movieID, quality)
+// dropDownload stops a torrent, downloading or seeding, and removes it from
+// the registry. Its files are kept.
+func (ts *TorrentService) dropDownload(dl *models.TorrentDownload) {
+	if dl.Torrent != nil {
+		dl.Torrent.Drop()
+	}
+	if ts.Downloads.CompareAndDelete(downloadKey(dl.MovieID), dl) {
+		ts.untrackActiveTorrent(dl.MovieID)
+	}
+}
 
-	dlInterface, ok := ts.Downloads.Load(downloadKey)
-	if ok {
-		dl, ok := dlInterface.(*models.TorrentDownload)
-		if ok && dl.Torrent != nil {
+// removeDownload drops a torrent and deletes its directory, partial files
+// included. The directory is known from the info hash, before the metadata
+// arrives.
+func (ts *TorrentService) removeDownload(dl *models.TorrentDownload) error {
+	ts.dropDownload(dl)
+	if dl.Torrent == nil {
+		return nil
+	}
+
+	dir := filepath.Join(ts.downloadDir, strconv.Itoa(dl.MovieID), dl.Torrent.InfoHash().HexString())
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove download directory: %w", err)
+	}
+	return nil
+}
+
+func (ts *TorrentService) RemoveTorrentFiles(movieID int, quality string, hlsOutputDir string) error {
+	if dl, ok := ts.ActiveDownload(movieID); ok {
+		if dl.Torrent != nil {
 			dl.Torrent.Drop()
 		}
-		ts.Downloads.Delete(downloadKey)
+		ts.Downloads.CompareAndDelete(downloadKey(movieID), dl)
 	}
+	ts.untrackActiveTorrent(movieID)
 
 	if hlsOutputDir != "" {
 		if _, err := os.Stat(hlsOutputDir); err == nil {
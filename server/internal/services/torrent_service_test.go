@@ -0,0 +1,157 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"server/internal/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestGetOrStartDownloadRegistered(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		wantErr bool
+	}{
+		{name: "started", status: "downloading"},
+		{name: "failed", status: "error", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &TorrentService{}
+			dl := &models.TorrentDownload{MovieID: 603, Status: "initializing", Started: make(chan struct{})}
+			ts.Downloads.Store(downloadKey(603), dl)
+
+			const callers = 4
+			results := make(chan *models.TorrentDownload, callers)
+			errs := make(chan error, callers)
+			var wg sync.WaitGroup
+			for range callers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got, err := ts.GetOrStartDownload(603, "")
+					results <- got
+					errs <- err
+				}()
+			}
+
+			// Callers wait until the video file of the download is selected.
+			time.Sleep(50 * time.Millisecond)
+			if len(results) > 0 {
+				t.Fatal("GetOrStartDownload returned before the download started")
+			}
+
+			dl.Mu.Lock()
+			dl.Status = tt.status
+			dl.Mu.Unlock()
+			close(dl.Started)
+			wg.Wait()
+
+			for range callers {
+				got, err := <-results, <-errs
+				if tt.wantErr {
+					if err == nil {
+						t.Error("GetOrStartDownload of a failed download returned no error")
+					}
+					continue
+				}
+				if err != nil || got != dl {
+					t.Errorf("GetOrStartDownload = %p, %v, want the registered download %p", got, err, dl)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareDownloadDropped(t *testing.T) {
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisablePEX = true
+	client, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tor, _ := client.AddTorrentInfoHash(metainfo.NewHashFromHex("0123456789abcdef0123456789abcdef01234567"))
+	dl := &models.TorrentDownload{Torrent: tor, MovieID: 603, Status: "initializing"}
+
+	// The torrent has no peers, so its metadata never arrives.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		tor.Drop()
+	}()
+
+	done := make(chan bool)
+	go func() {
+		_, ok := (&TorrentService{}).prepareDownload(dl, t.TempDir())
+		done <- ok
+	}()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("prepareDownload of a dropped torrent succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("prepareDownload kept waiting for the metadata of a dropped torrent")
+	}
+	if dl.Status != "error" {
+		t.Errorf("status = %q, want error", dl.Status)
+	}
+}
+
+func TestRemoveDownload(t *testing.T) {
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.ListenPort = 0
+	cfg.NoDHT = true
+	cfg.DisablePEX = true
+	client, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	infoHash := "0123456789abcdef0123456789abcdef01234567"
+	tor, _ := client.AddTorrentInfoHash(metainfo.NewHashFromHex(infoHash))
+
+	ts := &TorrentService{downloadDir: t.TempDir()}
+	replaced := filepath.Join(ts.downloadDir, "603", infoHash)
+	other := filepath.Join(ts.downloadDir, "603", "fedcba9876543210fedcba9876543210fedcba98")
+	for _, dir := range []string{replaced, other} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "movie.mkv.part"), []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The metadata never arrived: the download has no root directory yet.
+	dl := &models.TorrentDownload{Torrent: tor, MovieID: 603, Status: "initializing"}
+	if err := ts.removeDownload(dl); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(replaced); !os.IsNotExist(err) {
+		t.Errorf("directory of the removed download still exists: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("directory of another source was removed: %v", err)
+	}
+	select {
+	case <-tor.Closed():
+	default:
+		t.Error("torrent was not dropped")
+	}
+}
@@ -3,6 +3,7 @@ package services
 type TorrentSearchResult struct {
 	InfoHash string
 	Name     string
+	Seeders  int
 }
 
 type TorrentioSource struct {
@@ -31,4 +32,7 @@ type DiscoverMoviesResp struct {
 	VoteAverage float64 `json:"vote_average"`
 	GenreIDs    []int   `json:"genre_ids,omitempty"`
 	IsWatched   bool    `json:"isWatched"`
+	InWatchlist bool    `json:"in_watchlist"`
+	Community   float64 `json:"community_rating,omitempty"`
+	RatingCount int64   `json:"community_rating_count,omitempty"`
 }
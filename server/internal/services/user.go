@@ -1,12 +1,8 @@
 package services
 
 import (
-	"context"
-	"mime/multipart"
 	"server/internal/models"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -67,29 +63,3 @@ func UpdateUser(user models.User) error {
 	res := db.Save(&user)
 	return res.Error
 }
-
-func UploadPicture(pic *multipart.FileHeader, username string) (string, error) {
-	var url string
-
-	file, err := pic.Open()
-	if err != nil {
-		return url, err
-	}
-
-	key := username + ".png"
-
-	input := &s3.PutObjectInput{
-		Bucket: aws.String(AWSBucketName),
-		Key:    aws.String(key),
-		Body:   file,
-	}
-
-	scv := AWS_Client()
-
-	_, err = scv.PutObject(context.TODO(), input)
-	if err != nil {
-		return url, err
-	}
-
-	return "https://" + AWSBucketName + ".s3." + AWSBucketRegion + ".amazonaws.com/" + key, nil
-}
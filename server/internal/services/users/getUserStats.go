@@ -23,7 +23,7 @@ func GetUserStats(userID uint) (UserStats, error) {
 	var stats UserStats
 
 	// Count total watched movies
-	err := db.Model(&models.WatchHistory{}).Where("user_id = ?", userID).Count(&stats.TotalWatched).Error
+	err := db.Model(&models.WatchHistory{}).Where("user_id = ? AND watch_count > 0", userID).Count(&stats.TotalWatched).Error
 	if err != nil {
 		return stats, err
 	}
@@ -2,13 +2,16 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"html/template"
 	"io/fs"
 	"log"
 	"path/filepath"
 	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/spf13/viper"
 	gomail "gopkg.in/mail.v2"
@@ -44,27 +47,67 @@ func MailDialer() *gomail.Dialer {
 	return dialer
 }
 
+// LoadAWSBucket creates the client of the S3 avatar bucket. Without AWS keys
+// there is none and avatars are stored locally.
 func LoadAWSBucket() {
-	if viper.GetString("AWS_ACCESS_KEY_ID") == "" {
-		log.Fatal("AWS_ACCESS_KEY_ID was not read")
+	accessKeyID := viper.GetString("AWS_ACCESS_KEY_ID")
+	secretAccessKey := viper.GetString("AWS_SECRET_ACCESS_KEY")
+	if accessKeyID == "" || secretAccessKey == "" {
+		Logger.Warn("AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY was not read, S3 avatar storage is disabled")
+		return
 	}
 
-	if viper.GetString("AWS_SECRET_ACCESS_KEY") == "" {
-		log.Fatal("AWS_SECRET_ACCESS_KEY was not read")
+	region := Conf.AVATARS.S3.Region
+	if region == "" {
+		region = AWSBucketRegion
 	}
 
-	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion("eu-north-1"))
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
+		awsconfig.WithRegion(region),
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")),
+	)
 	if err != nil {
-		log.Fatal(err)
+		Logger.Error(fmt.Sprintf("Failed to load AWS config, S3 avatar storage is disabled: %v", err))
+		return
 	}
 
-	aws_client = s3.NewFromConfig(cfg)
+	aws_client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if Conf.AVATARS.S3.Endpoint != "" {
+			o.BaseEndpoint = aws.String(Conf.AVATARS.S3.Endpoint)
+		}
+		o.UsePathStyle = Conf.AVATARS.S3.UsePathStyle
+	})
 }
 
 func AWS_Client() *s3.Client {
 	return aws_client
 }
 
+// DirSize returns the total size in bytes of the regular files under path.
+func DirSize(path string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
+
 func FindFilesWithExtension(path string, ext string) ([]string, error) {
 	var srtFiles []string
 
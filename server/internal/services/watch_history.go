@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"server/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const defaultContinueWatchingLimit = 20
+
+// ContinueWatching returns the movies a user started without finishing them,
+// most recently watched first.
+func (ms *MovieService) ContinueWatching(userID uint, limit int) ([]models.WatchHistory, error) {
+	if limit <= 0 {
+		limit = defaultContinueWatchingLimit
+	}
+
+	history := []models.WatchHistory{}
+	err := ms.db.Where("user_id = ? AND last_position > 0 AND watch_progress < ?", userID, watchCompleteThreshold).
+		Order("watched_at DESC").
+		Limit(limit).
+		Find(&history).Error
+
+	return history, err
+}
+
+// MarkWatched marks a movie as watched by a user, as if they had watched it
+// to the end.
+func (ms *MovieService) MarkWatched(userID uint, movieID int) (models.WatchHistory, error) {
+	ms.historyMu.Lock()
+	defer ms.historyMu.Unlock()
+
+	ms.dropPendingProgress(userID, movieID)
+
+	var wh models.WatchHistory
+	err := ms.db.Where("user_id = ? AND movie_id = ?", userID, movieID).First(&wh).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		wh = models.WatchHistory{UserID: userID, MovieID: movieID}
+		if details := ms.watchedMovieDetails(movieID); details != nil {
+			wh.MovieTitle = details.Title
+			wh.PosterPath = details.PosterPath
+			wh.Duration = details.Runtime * 60
+		}
+	} else if err != nil {
+		return models.WatchHistory{}, err
+	}
+
+	wh.WatchedAt = time.Now()
+	wh.LastPosition = wh.Duration
+	wh.WatchProgress = 100
+	if wh.WatchCount == 0 {
+		wh.WatchCount = 1
+	}
+
+	if err := ms.db.Save(&wh).Error; err != nil {
+		return models.WatchHistory{}, err
+	}
+	return wh, nil
+}
+
+// MarkUnwatched marks a movie as not watched by a user, forgetting their
+// position but keeping it in their history.
+func (ms *MovieService) MarkUnwatched(userID uint, movieID int) error {
+	ms.historyMu.Lock()
+	defer ms.historyMu.Unlock()
+
+	ms.dropPendingProgress(userID, movieID)
+
+	return ms.db.Model(&models.WatchHistory{}).
+		Where("user_id = ? AND movie_id = ?", userID, movieID).
+		Updates(map[string]interface{}{
+			"watch_count":    0,
+			"watch_progress": 0,
+			"last_position":  0,
+			"last_segment":   "",
+		}).Error
+}
+
+// RemoveFromHistory removes a movie from the history of a user.
+func (ms *MovieService) RemoveFromHistory(userID uint, movieID int) error {
+	ms.historyMu.Lock()
+	defer ms.historyMu.Unlock()
+
+	ms.dropPendingProgress(userID, movieID)
+
+	return ms.db.Unscoped().Where("user_id = ? AND movie_id = ?", userID, movieID).Delete(&models.WatchHistory{}).Error
+}
+
+// ClearHistory removes every movie from the history of a user.
+func (ms *MovieService) ClearHistory(userID uint) error {
+	ms.historyMu.Lock()
+	defer ms.historyMu.Unlock()
+
+	ms.pendingProgress.Range(func(k, _ interface{}) bool {
+		if wk := k.(watchKey); wk.UserID == userID {
+			ms.dropPendingProgress(wk.UserID, wk.MovieID)
+		}
+		return true
+	})
+
+	return ms.db.Unscoped().Where("user_id = ?", userID).Delete(&models.WatchHistory{}).Error
+}
+
+// dropPendingProgress forgets progress that is not persisted yet, so that it
+// does not undo a change made to the history. Callers hold historyMu.
+func (ms *MovieService) dropPendingProgress(userID uint, movieID int) {
+	wk := watchKey{UserID: userID, MovieID: movieID}
+	ms.pendingProgress.Delete(wk)
+	ms.playerProgress.Delete(wk)
+}
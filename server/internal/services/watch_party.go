@@ -0,0 +1,519 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"server/internal/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	partyCodeLength   = 8
+	partyCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O or 1/I
+
+	// partyEmptyTTL is how long a room nobody is in is kept.
+	partyEmptyTTL = 5 * time.Minute
+	// partySyncInterval is how often the playback state of a playing room is
+	// broadcast, for members to correct their drift.
+	partySyncInterval = 5 * time.Second
+
+	partyChatHistory = 50
+	// partySendBuffer holds the state, presence and chat history a member
+	// gets on joining, with room to spare for the messages that follow.
+	partySendBuffer   = partyChatHistory + 32
+	maxPartyChatText  = 500
+	maxPartyPosition  = 24 * 60 * 60
+	maxPartyPerUser   = 3
+	partyCodeAttempts = 5
+)
+
+var (
+	ErrPartyNotFound   = errors.New("watch party not found")
+	ErrTooManyParties  = errors.New("too many watch parties, close one first")
+	ErrInvalidParty    = errors.New("invalid watch party")
+	errPartyNotHost    = errors.New("only the host controls playback")
+	errPartyBadMessage = errors.New("invalid message")
+)
+
+// PartyMessage is a message a member sends to a room:
+//   - "play", "pause" and "seek" at a position, in seconds, from the host;
+//   - "chat" with a text;
+//   - "sync" to get the playback state;
+//   - "time" with the client time, in milliseconds, to measure the clock
+//     offset.
+type PartyMessage struct {
+	Type       string   `json:"type" example:"seek"`
+	Position   *float64 `json:"position,omitempty" example:"1260.5"`
+	Text       string   `json:"text,omitempty"`
+	ClientTime int64    `json:"client_time,omitempty"`
+}
+
+// PartyState is the playback state of a room. Position is the position at
+// ServerTime, in milliseconds since the epoch; while playing, members add the
+// time elapsed since then to stay in sync.
+type PartyState struct {
+	Type       string  `json:"type" example:"state"`
+	Playing    bool    `json:"playing"`
+	Position   float64 `json:"position" example:"1260.5"`
+	ServerTime int64   `json:"server_time" example:"1760000000000"`
+	UpdatedBy  string  `json:"updated_by,omitempty" example:"fturing"`
+}
+
+// PartyMemberInfo is a member of a room in presence lists.
+type PartyMemberInfo struct {
+	Username string `json:"username" example:"fturing"`
+	Avatar   string `json:"avatar"`
+	Host     bool   `json:"host"`
+}
+
+// PartyPresence lists the members of a room.
+type PartyPresence struct {
+	Type    string            `json:"type" example:"presence"`
+	Host    string            `json:"host" example:"fturing"`
+	Members []PartyMemberInfo `json:"members"`
+}
+
+// PartyChat is a chat message of a room.
+type PartyChat struct {
+	Type     string `json:"type" example:"chat"`
+	Username string `json:"username" example:"fturing"`
+	Avatar   string `json:"avatar"`
+	Text     string `json:"text"`
+	SentAt   int64  `json:"sent_at" example:"1760000000000"`
+}
+
+type partyTime struct {
+	Type       string `json:"type"`
+	ClientTime int64  `json:"client_time"`
+	ServerTime int64  `json:"server_time"`
+}
+
+type partyError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// PartyInfo describes a room.
+type PartyInfo struct {
+	Code      string            `json:"code" example:"K7QM4XPA"`
+	MovieID   int               `json:"movie_id" example:"603"`
+	SourceID  uint              `json:"source_id,omitempty" example:"12"`
+	Host      string            `json:"host" example:"fturing"`
+	Members   []PartyMemberInfo `json:"members"`
+	State     PartyState        `json:"state"`
+	SocketURL string            `json:"socket_url" example:"/api/ws/party/K7QM4XPA"`
+}
+
+// PartyMember is a connection of a user to a room. A user may be connected
+// from several devices.
+type PartyMember struct {
+	UserID   uint
+	Username string
+	Avatar   string
+	joinedAt time.Time
+
+	// send is closed once the member left; both are guarded by the room
+	// lock.
+	send   chan []byte
+	closed bool
+}
+
+// Outgoing returns the messages to write to the connection. It is closed
+// once the member left, or was evicted for not reading fast enough.
+func (m *PartyMember) Outgoing() <-chan []byte {
+	return m.send
+}
+
+// close is called with the room locked.
+func (m *PartyMember) close() {
+	if m.closed {
+		return
+	}
+	m.closed = true
+	close(m.send)
+}
+
+// PartyRoom is a watch party: members watching a movie together, its
+// playback controlled by the host.
+type PartyRoom struct {
+	Code      string
+	MovieID   int
+	SourceID  uint
+	CreatedAt time.Time
+
+	mu         sync.Mutex
+	closed     bool
+	hostID     uint
+	host       string
+	members    []*PartyMember // in the order they joined
+	playing    bool
+	position   float64
+	updatedAt  time.Time
+	updatedBy  string
+	chat       []PartyChat
+	emptySince time.Time
+}
+
+// WatchPartyService keeps the watch party rooms, in memory.
+type WatchPartyService struct {
+	mu    sync.Mutex
+	rooms map[string]*PartyRoom
+}
+
+func NewWatchPartyService() *WatchPartyService {
+	ps := &WatchPartyService{
+		rooms: make(map[string]*PartyRoom),
+	}
+	go ps.worker()
+	return ps
+}
+
+// Create opens a room for a movie, hosted by a user. It expires unless
+// someone joins it.
+func (ps *WatchPartyService) Create(user models.User, movieID int, sourceID uint) (PartyInfo, error) {
+	if movieID <= 0 {
+		return PartyInfo{}, ErrInvalidParty
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	hosted := 0
+	for _, room := range ps.rooms {
+		room.mu.Lock()
+		if room.hostID == user.ID {
+			hosted++
+		}
+		room.mu.Unlock()
+	}
+	if hosted >= maxPartyPerUser {
+		return PartyInfo{}, ErrTooManyParties
+	}
+
+	var code string
+	for attempt := 0; ; attempt++ {
+		var err error
+		if code, err = partyCode(); err != nil {
+			return PartyInfo{}, err
+		}
+		if _, taken := ps.rooms[code]; !taken {
+			break
+		}
+		if attempt == partyCodeAttempts {
+			return PartyInfo{}, fmt.Errorf("failed to generate a party code")
+		}
+	}
+
+	now := time.Now()
+	room := &PartyRoom{
+		Code:       code,
+		MovieID:    movieID,
+		SourceID:   sourceID,
+		CreatedAt:  now,
+		hostID:     user.ID,
+		host:       user.Username,
+		updatedAt:  now,
+		emptySince: now,
+	}
+	ps.rooms[code] = room
+
+	Logger.Info(fmt.Sprintf("Watch party %s created by %s for movie %d", code, user.Username, movieID))
+	return room.info(), nil
+}
+
+// Room returns a room from its invite code.
+func (ps *WatchPartyService) Room(code string) (*PartyRoom, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	room, ok := ps.rooms[strings.ToUpper(code)]
+	return room, ok
+}
+
+// Info describes a room.
+func (ps *WatchPartyService) Info(code string) (PartyInfo, error) {
+	room, ok := ps.Room(code)
+	if !ok {
+		return PartyInfo{}, ErrPartyNotFound
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.info(), nil
+}
+
+// Join adds a connection of a user to a room. The member gets the playback
+// state, the members and the recent chat, and the others its presence.
+func (ps *WatchPartyService) Join(code string, user models.User) (*PartyRoom, *PartyMember, error) {
+	room, ok := ps.Room(code)
+	if !ok {
+		return nil, nil, ErrPartyNotFound
+	}
+
+	member := &PartyMember{
+		UserID:   user.ID,
+		Username: user.Username,
+		Avatar:   user.Avatar,
+		joinedAt: time.Now(),
+		send:     make(chan []byte, partySendBuffer),
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	// The room may have expired meanwhile.
+	if room.closed {
+		return nil, nil, ErrPartyNotFound
+	}
+
+	room.members = append(room.members, member)
+	room.emptySince = time.Time{}
+
+	room.sendTo(member, room.state())
+	for _, chat := range room.chat {
+		room.sendTo(member, chat)
+	}
+	room.broadcast(room.presence())
+
+	return room, member, nil
+}
+
+// Leave removes a connection from a room. When the host is gone, the member
+// who joined first becomes the host.
+func (ps *WatchPartyService) Leave(room *PartyRoom, member *PartyMember) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	room.remove(member)
+}
+
+// Handle applies a message a member sent to a room.
+func (room *PartyRoom) Handle(member *PartyMember, raw []byte) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	// The member may have been evicted before its reader stopped.
+	if member.closed {
+		return
+	}
+
+	var msg PartyMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		room.sendTo(member, partyError{Type: "error", Message: errPartyBadMessage.Error()})
+		return
+	}
+
+	switch msg.Type {
+	case "play", "pause", "seek":
+		if member.UserID != room.hostID {
+			room.sendTo(member, partyError{Type: "error", Message: errPartyNotHost.Error()})
+			return
+		}
+		position := room.currentPosition()
+		if msg.Position != nil {
+			position = *msg.Position
+		}
+		if math.IsNaN(position) || position < 0 || position > maxPartyPosition {
+			room.sendTo(member, partyError{Type: "error", Message: errPartyBadMessage.Error()})
+			return
+		}
+
+		switch msg.Type {
+		case "play":
+			room.playing = true
+		case "pause":
+			room.playing = false
+		}
+		room.position = position
+		room.updatedAt = time.Now()
+		room.updatedBy = member.Username
+		room.broadcast(room.state())
+
+	case "chat":
+		text := strings.TrimSpace(msg.Text)
+		if text == "" || len([]rune(text)) > maxPartyChatText {
+			room.sendTo(member, partyError{Type: "error", Message: errPartyBadMessage.Error()})
+			return
+		}
+		chat := PartyChat{
+			Type:     "chat",
+			Username: member.Username,
+			Avatar:   member.Avatar,
+			Text:     text,
+			SentAt:   time.Now().UnixMilli(),
+		}
+		room.chat = append(room.chat, chat)
+		if len(room.chat) > partyChatHistory {
+			room.chat = room.chat[len(room.chat)-partyChatHistory:]
+		}
+		room.broadcast(chat)
+
+	case "sync":
+		room.sendTo(member, room.state())
+
+	case "time":
+		room.sendTo(member, partyTime{Type: "time", ClientTime: msg.ClientTime, ServerTime: time.Now().UnixMilli()})
+
+	default:
+		room.sendTo(member, partyError{Type: "error", Message: errPartyBadMessage.Error()})
+	}
+}
+
+// worker broadcasts the playback state of the playing rooms, and closes the
+// rooms that stayed empty.
+func (ps *WatchPartyService) worker() {
+	ticker := time.NewTicker(partySyncInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ps.mu.Lock()
+		for code, room := range ps.rooms {
+			room.mu.Lock()
+			if len(room.members) == 0 && time.Since(room.emptySince) > partyEmptyTTL {
+				room.closed = true
+				delete(ps.rooms, code)
+				Logger.Info(fmt.Sprintf("Watch party %s expired", code))
+			} else if room.playing {
+				room.broadcast(room.state())
+			}
+			room.mu.Unlock()
+		}
+		ps.mu.Unlock()
+	}
+}
+
+// The methods below are called with the room locked.
+
+func (room *PartyRoom) remove(member *PartyMember) {
+	index := -1
+	for i, m := range room.members {
+		if m == member {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return
+	}
+	room.members = append(room.members[:index], room.members[index+1:]...)
+	member.close()
+
+	if len(room.members) == 0 {
+		room.emptySince = time.Now()
+		room.playing = false
+		room.position = room.currentPosition()
+		room.updatedAt = time.Now()
+		return
+	}
+
+	hostConnected := false
+	for _, m := range room.members {
+		if m.UserID == room.hostID {
+			hostConnected = true
+			break
+		}
+	}
+	if !hostConnected {
+		room.hostID = room.members[0].UserID
+		room.host = room.members[0].Username
+	}
+	room.broadcast(room.presence())
+}
+
+// currentPosition is the playback position now.
+func (room *PartyRoom) currentPosition() float64 {
+	if !room.playing {
+		return room.position
+	}
+	return room.position + time.Since(room.updatedAt).Seconds()
+}
+
+func (room *PartyRoom) state() PartyState {
+	return PartyState{
+		Type:       "state",
+		Playing:    room.playing,
+		Position:   math.Round(room.currentPosition()*1000) / 1000,
+		ServerTime: time.Now().UnixMilli(),
+		UpdatedBy:  room.updatedBy,
+	}
+}
+
+func (room *PartyRoom) presence() PartyPresence {
+	return PartyPresence{Type: "presence", Host: room.host, Members: room.memberInfos()}
+}
+
+// memberInfos lists the members once per user.
+func (room *PartyRoom) memberInfos() []PartyMemberInfo {
+	infos := []PartyMemberInfo{}
+	seen := make(map[uint]bool, len(room.members))
+	for _, m := range room.members {
+		if seen[m.UserID] {
+			continue
+		}
+		seen[m.UserID] = true
+		infos = append(infos, PartyMemberInfo{Username: m.Username, Avatar: m.Avatar, Host: m.UserID == room.hostID})
+	}
+	return infos
+}
+
+func (room *PartyRoom) info() PartyInfo {
+	return PartyInfo{
+		Code:      room.Code,
+		MovieID:   room.MovieID,
+		SourceID:  room.SourceID,
+		Host:      room.host,
+		Members:   room.memberInfos(),
+		State:     room.state(),
+		SocketURL: fmt.Sprintf("/api/ws/party/%s", room.Code),
+	}
+}
+
+func (room *PartyRoom) broadcast(message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	for _, m := range append([]*PartyMember(nil), room.members...) {
+		room.deliver(m, data)
+	}
+}
+
+func (room *PartyRoom) sendTo(member *PartyMember, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	room.deliver(member, data)
+}
+
+// deliver queues a message for a member. Members that do not read fast
+// enough are evicted rather than slowing the room down.
+func (room *PartyRoom) deliver(member *PartyMember, data []byte) {
+	if member.closed {
+		return
+	}
+	select {
+	case member.send <- data:
+	default:
+		Logger.Warn(fmt.Sprintf("Evicting %s from watch party %s: too slow", member.Username, room.Code))
+		room.remove(member)
+	}
+}
+
+// partyCode generates a random invite code.
+func partyCode() (string, error) {
+	raw := make([]byte, partyCodeLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := make([]byte, partyCodeLength)
+	for i, b := range raw {
+		code[i] = partyCodeAlphabet[int(b)%len(partyCodeAlphabet)]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"server/internal/models"
+	"testing"
+)
+
+func partyUser(id uint, username string) models.User {
+	var user models.User
+	user.ID = id
+	user.Username = username
+	return user
+}
+
+func newTestParty(t *testing.T) (*WatchPartyService, PartyInfo) {
+	t.Helper()
+	ps := &WatchPartyService{rooms: make(map[string]*PartyRoom)}
+	info, err := ps.Create(partyUser(1, "host"), 603, 0)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return ps, info
+}
+
+// drain returns the types of the messages queued for a member.
+func drain(member *PartyMember) []string {
+	var types []string
+	for {
+		select {
+		case data, ok := <-member.send:
+			if !ok {
+				return types
+			}
+			var msg struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal(data, &msg)
+			types = append(types, msg.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestPartyJoinWithFullChatHistory(t *testing.T) {
+	ps, info := newTestParty(t)
+
+	room, host, err := ps.Join(info.Code, partyUser(1, "host"))
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	for i := 0; i < partyChatHistory+10; i++ {
+		room.Handle(host, []byte(fmt.Sprintf(`{"type":"chat","text":"message %d"}`, i)))
+		drain(host)
+	}
+
+	_, guest, err := ps.Join(info.Code, partyUser(2, "guest"))
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if guest.closed {
+		t.Fatal("guest was evicted while joining")
+	}
+
+	types := drain(guest)
+	if want := 1 + partyChatHistory + 1; len(types) != want {
+		t.Fatalf("guest got %d messages, want %d", len(types), want)
+	}
+	if types[0] != "state" || types[len(types)-1] != "presence" {
+		t.Errorf("guest got %s first and %s last, want state and presence", types[0], types[len(types)-1])
+	}
+}
+
+func TestPartyEvictsSlowMember(t *testing.T) {
+	ps, info := newTestParty(t)
+
+	room, host, _ := ps.Join(info.Code, partyUser(1, "host"))
+	_, slow, _ := ps.Join(info.Code, partyUser(2, "slow"))
+
+	for i := 0; i <= partySendBuffer; i++ {
+		room.Handle(host, []byte(`{"type":"chat","text":"hello"}`))
+		drain(host)
+	}
+
+	if !slow.closed {
+		t.Fatal("slow member was not evicted")
+	}
+	if got := len(room.members); got != 1 {
+		t.Errorf("room has %d members, want 1", got)
+	}
+
+	// Messages from, and for, an evicted member are dropped.
+	room.Handle(slow, []byte(`{"type":"sync"}`))
+	room.Handle(host, []byte(`{"type":"chat","text":"still there?"}`))
+	ps.Leave(room, slow)
+}
+
+func TestPartyPlaybackAndHost(t *testing.T) {
+	ps, info := newTestParty(t)
+
+	room, host, _ := ps.Join(info.Code, partyUser(1, "host"))
+	_, guest, _ := ps.Join(info.Code, partyUser(2, "guest"))
+	drain(host)
+	drain(guest)
+
+	room.Handle(guest, []byte(`{"type":"pause"}`))
+	if types := drain(guest); len(types) != 1 || types[0] != "error" {
+		t.Errorf("guest controlling playback got %v, want an error", types)
+	}
+
+	room.Handle(host, []byte(`{"type":"seek","position":120}`))
+	if types := drain(guest); len(types) != 1 || types[0] != "state" {
+		t.Errorf("guest got %v after a seek, want the state", types)
+	}
+	if room.position != 120 {
+		t.Errorf("position = %v, want 120", room.position)
+	}
+
+	ps.Leave(room, host)
+	if room.hostID != 2 || room.host != "guest" {
+		t.Errorf("host = %d %q after the host left, want the guest", room.hostID, room.host)
+	}
+
+	ps.Leave(room, guest)
+	if room.emptySince.IsZero() {
+		t.Error("empty room has no emptySince")
+	}
+}
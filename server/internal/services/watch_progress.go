@@ -0,0 +1,309 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"server/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// watchCompleteThreshold is the progress, in percent, from which a movie
+	// counts as watched, so that the credits can be skipped.
+	watchCompleteThreshold = 90.0
+
+	watchHistoryFlushInterval = 5 * time.Second
+
+	// playerProgressWindow is how long progress reported by the player takes
+	// precedence over the segments it requests, which run ahead of playback.
+	playerProgressWindow = time.Minute
+)
+
+var ErrInvalidProgress = errors.New("invalid playback progress")
+
+type watchKey struct {
+	UserID  uint
+	MovieID int
+}
+
+// watchProgress is playback progress waiting to be persisted. A negative
+// position only records that the movie is being watched.
+type watchProgress struct {
+	position int // seconds
+	duration int // seconds, 0 when unknown
+	segment  string
+	at       time.Time
+}
+
+// WatchPosition is where a user stands in a movie.
+type WatchPosition struct {
+	Position   int     `json:"position" example:"1260"` // seconds
+	Duration   int     `json:"duration" example:"8160"` // seconds
+	Progress   float64 `json:"progress" example:"15.44"`
+	WatchCount int     `json:"watch_count" example:"1"`
+	Completed  bool    `json:"completed" example:"false"`
+}
+
+// ResumePosition is the position playback resumes from, the start once the
+// movie was watched to the end.
+func (p WatchPosition) ResumePosition() int {
+	if p.Completed {
+		return 0
+	}
+	return p.Position
+}
+
+// TrackUserSegment records the position of a user from a stream segment they
+// requested. Requests that are not for a segment, like direct play, only
+// record that the movie is being watched.
+func (ms *MovieService) TrackUserSegment(userID uint, key StreamKey, segment string) {
+	wk := watchKey{UserID: userID, MovieID: key.MovieID}
+	progress := watchProgress{position: -1, segment: segment, at: time.Now()}
+
+	if index, ok := ms.segmentIndex(segment); ok {
+		if reported, ok := ms.playerProgress.Load(wk); ok && time.Since(reported.(time.Time)) < playerProgressWindow {
+			return
+		}
+		progress.position = index * VideoTranscoderConf.Output.SegmentTime
+		progress.duration = ms.streamDuration(key)
+	} else if _, pending := ms.pendingProgress.Load(wk); pending {
+		return
+	}
+
+	ms.pendingProgress.Store(wk, progress)
+}
+
+// ReportProgress records the position reported by the player of a user, in
+// seconds. The duration may be 0 when the player does not know it.
+func (ms *MovieService) ReportProgress(userID uint, movieID int, position, duration int) error {
+	if position < 0 || duration < 0 {
+		return ErrInvalidProgress
+	}
+	if duration > 0 {
+		position = min(position, duration)
+	}
+
+	wk := watchKey{UserID: userID, MovieID: movieID}
+	now := time.Now()
+	ms.playerProgress.Store(wk, now)
+	ms.pendingProgress.Store(wk, watchProgress{position: position, duration: duration, at: now})
+
+	return nil
+}
+
+// WatchPosition returns where a user stands in a movie, including progress
+// that is not persisted yet.
+func (ms *MovieService) WatchPosition(userID uint, movieID int) (WatchPosition, bool) {
+	var wh models.WatchHistory
+	err := ms.db.Where("user_id = ? AND movie_id = ?", userID, movieID).First(&wh).Error
+	found := err == nil
+
+	if value, ok := ms.pendingProgress.Load(watchKey{UserID: userID, MovieID: movieID}); ok {
+		applyWatchProgress(&wh, value.(watchProgress))
+		found = true
+	}
+	if !found {
+		return WatchPosition{}, false
+	}
+
+	return WatchPosition{
+		Position:   wh.LastPosition,
+		Duration:   wh.Duration,
+		Progress:   wh.WatchProgress,
+		WatchCount: wh.WatchCount,
+		Completed:  wh.WatchProgress >= watchCompleteThreshold,
+	}, true
+}
+
+// segmentIndex parses the index of a segment file name.
+func (ms *MovieService) segmentIndex(segment string) (int, bool) {
+	if segment == "" || ms.SegmentFormatParse == "" {
+		return 0, false
+	}
+	var index int
+	if _, err := fmt.Sscanf(filepath.Base(segment), ms.SegmentFormatParse, &index); err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
+
+// streamDuration returns the duration of a finished stream in seconds, 0
+// while it is transcoded.
+func (ms *MovieService) streamDuration(key StreamKey) int {
+	if value, ok := ms.streamDurations.Load(key); ok {
+		return value.(int)
+	}
+
+	dir := filepath.Join(hlsBaseDir(), key.Dir())
+	for _, playlist := range variantPlaylists(dir) {
+		duration, complete := playlistDuration(playlist)
+		if !complete {
+			continue
+		}
+		ms.streamDurations.Store(key, duration)
+		return duration
+	}
+
+	return 0
+}
+
+// playlistDuration sums the segment durations of a media playlist and tells
+// whether the playlist is complete.
+func playlistDuration(playlist string) (int, bool) {
+	f, err := os.Open(playlist)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	var total float64
+	complete := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				total += seconds
+			}
+		case line == hlsEndList:
+			complete = true
+		}
+	}
+
+	return int(math.Round(total)), complete && scanner.Err() == nil
+}
+
+// applyWatchProgress updates a watch history row with playback progress and
+// counts a viewing when the progress crosses watchCompleteThreshold.
+func applyWatchProgress(wh *models.WatchHistory, progress watchProgress) {
+	wh.WatchedAt = progress.at
+	if progress.position < 0 {
+		return
+	}
+
+	if progress.duration > 0 {
+		wh.Duration = progress.duration
+	}
+	wh.LastPosition = progress.position
+	if progress.segment != "" {
+		wh.LastSegment = filepath.Base(progress.segment)
+	}
+
+	previous := wh.WatchProgress
+	if wh.Duration > 0 {
+		wh.WatchProgress = math.Min(100, math.Round(float64(progress.position)/float64(wh.Duration)*10000)/100)
+	}
+	if wh.WatchProgress >= watchCompleteThreshold && previous < watchCompleteThreshold {
+		wh.WatchCount++
+	}
+}
+
+func (ms *MovieService) persistWatchHistoryWorker() {
+	ticker := time.NewTicker(watchHistoryFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ms.persistWatchHistory()
+	}
+}
+
+// persistWatchHistory writes the pending playback progress in one
+// transaction.
+func (ms *MovieService) persistWatchHistory() {
+	ms.historyMu.Lock()
+	defer ms.historyMu.Unlock()
+
+	pending := make(map[watchKey]watchProgress)
+	ms.pendingProgress.Range(func(k, value interface{}) bool {
+		// Progress stored meanwhile is kept for the next batch.
+		if ms.pendingProgress.CompareAndDelete(k, value) {
+			pending[k.(watchKey)] = value.(watchProgress)
+		}
+		return true
+	})
+	if len(pending) == 0 {
+		return
+	}
+
+	pairs := make([][]interface{}, 0, len(pending))
+	for wk := range pending {
+		pairs = append(pairs, []interface{}{wk.UserID, wk.MovieID})
+	}
+
+	var rows []models.WatchHistory
+	if err := ms.db.Where("(user_id, movie_id) IN ?", pairs).Find(&rows).Error; err != nil {
+		Logger.Error(fmt.Sprintf("Failed to load watch history: %v", err))
+		ms.requeueProgress(pending)
+		return
+	}
+
+	existing := make(map[watchKey]models.WatchHistory, len(rows))
+	for _, row := range rows {
+		existing[watchKey{UserID: row.UserID, MovieID: row.MovieID}] = row
+	}
+
+	// Movies seen for the first time get their title, poster and runtime,
+	// the runtime standing in for the duration until the stream is finished.
+	movies := make(map[int]*models.MovieDetails)
+	for wk := range pending {
+		if _, ok := existing[wk]; ok {
+			continue
+		}
+		if _, ok := movies[wk.MovieID]; !ok {
+			movies[wk.MovieID] = ms.watchedMovieDetails(wk.MovieID)
+		}
+	}
+
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		for wk, progress := range pending {
+			wh, ok := existing[wk]
+			if !ok {
+				wh = models.WatchHistory{UserID: wk.UserID, MovieID: wk.MovieID}
+				if details := movies[wk.MovieID]; details != nil {
+					wh.MovieTitle = details.Title
+					wh.PosterPath = details.PosterPath
+					wh.Duration = details.Runtime * 60
+				}
+			}
+			applyWatchProgress(&wh, progress)
+			if err := tx.Save(&wh).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		Logger.Error(fmt.Sprintf("Failed to persist watch history: %v", err))
+		ms.requeueProgress(pending)
+	}
+}
+
+// requeueProgress puts back progress that could not be persisted, unless
+// newer progress was recorded meanwhile.
+func (ms *MovieService) requeueProgress(pending map[watchKey]watchProgress) {
+	for wk, progress := range pending {
+		ms.pendingProgress.LoadOrStore(wk, progress)
+	}
+}
+
+func (ms *MovieService) watchedMovieDetails(movieID int) *models.MovieDetails {
+	source, ok := ms.SearchSources["tmdb"]
+	if !ok {
+		return nil
+	}
+	details, err := source.GetMovieDetails(strconv.Itoa(movieID))
+	if err != nil {
+		return nil
+	}
+	return details
+}
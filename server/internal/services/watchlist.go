@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"server/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMovieNotFound         = errors.New("movie not found")
+	ErrInvalidWatchlistOrder = errors.New("the order must list every movie of the watchlist once")
+)
+
+// Watchlist returns the movies a user saved to watch later, in their order.
+func (ms *MovieService) Watchlist(userID uint) ([]models.WatchlistItem, error) {
+	items := []models.WatchlistItem{}
+	err := ms.db.Where("user_id = ?", userID).
+		Order("position ASC, created_at ASC").
+		Find(&items).Error
+
+	return items, err
+}
+
+// AddToWatchlist saves a movie at the end of the watchlist of a user. A movie
+// already in the watchlist keeps its place.
+func (ms *MovieService) AddToWatchlist(userID uint, movieID int) (models.WatchlistItem, error) {
+	var item models.WatchlistItem
+	err := ms.db.Where("user_id = ? AND movie_id = ?", userID, movieID).First(&item).Error
+	if err == nil {
+		return item, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.WatchlistItem{}, err
+	}
+
+	details := ms.watchedMovieDetails(movieID)
+	if details == nil {
+		return models.WatchlistItem{}, ErrMovieNotFound
+	}
+
+	err = ms.db.Transaction(func(tx *gorm.DB) error {
+		var last struct{ Position *int }
+		if err := tx.Model(&models.WatchlistItem{}).
+			Select("MAX(position) AS position").
+			Where("user_id = ?", userID).
+			Scan(&last).Error; err != nil {
+			return err
+		}
+
+		item = models.WatchlistItem{
+			UserID:     userID,
+			MovieID:    movieID,
+			MovieTitle: details.Title,
+			PosterPath: details.PosterPath,
+		}
+		if last.Position != nil {
+			item.Position = *last.Position + 1
+		}
+
+		// A concurrent request may have added the movie meanwhile.
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&item).Error
+	})
+	if err != nil {
+		return models.WatchlistItem{}, err
+	}
+
+	return item, nil
+}
+
+// RemoveFromWatchlist removes a movie from the watchlist of a user.
+func (ms *MovieService) RemoveFromWatchlist(userID uint, movieID int) error {
+	return ms.db.Where("user_id = ? AND movie_id = ?", userID, movieID).Delete(&models.WatchlistItem{}).Error
+}
+
+// ReorderWatchlist orders the watchlist of a user as the given movies, which
+// must be every movie of the watchlist.
+func (ms *MovieService) ReorderWatchlist(userID uint, movieIDs []int) ([]models.WatchlistItem, error) {
+	err := ms.db.Transaction(func(tx *gorm.DB) error {
+		var items []models.WatchlistItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			Find(&items).Error; err != nil {
+			return err
+		}
+		if len(items) != len(movieIDs) {
+			return ErrInvalidWatchlistOrder
+		}
+
+		current := make(map[int]bool, len(items))
+		for _, item := range items {
+			current[item.MovieID] = true
+		}
+		for _, movieID := range movieIDs {
+			if !current[movieID] {
+				return ErrInvalidWatchlistOrder
+			}
+			delete(current, movieID)
+		}
+
+		for position, movieID := range movieIDs {
+			if err := tx.Model(&models.WatchlistItem{}).
+				Where("user_id = ? AND movie_id = ?", userID, movieID).
+				Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ms.Watchlist(userID)
+}
+
+// InWatchlist reports whether a movie is in the watchlist of a user.
+func (ms *MovieService) InWatchlist(userID uint, movieID int) bool {
+	var count int64
+	ms.db.Model(&models.WatchlistItem{}).
+		Where("user_id = ? AND movie_id = ?", userID, movieID).
+		Count(&count)
+
+	return count > 0
+}
+
+// watchlistSet returns which of the given movies are in the watchlist of a
+// user, none without a user.
+func (ms *MovieService) watchlistSet(userID *uint, movieIDs []int) map[int]bool {
+	set := make(map[int]bool)
+	if userID == nil || len(movieIDs) == 0 {
+		return set
+	}
+
+	var ids []int
+	ms.db.Model(&models.WatchlistItem{}).
+		Where("user_id = ? AND movie_id IN ?", *userID, movieIDs).
+		Pluck("movie_id", &ids)
+
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
@@ -2,65 +2,332 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	// TopicUser is the topic of the events of the connected user.
+	TopicUser = "user"
+
+	socketSendBuffer = 64
+	maxSocketTopics  = 50
+)
 
-	"github.com/gorilla/websocket"
+var (
+	ErrInvalidTopic   = errors.New("invalid topic")
+	ErrTooManyTopics  = errors.New("too many subscriptions")
+	errUnknownMessage = errors.New("unknown message type")
+	errInvalidMessage = errors.New("invalid message")
 )
 
+// SocketMessage is a message a client sends on the WebSocket:
+//   - "subscribe" and "unsubscribe" to a topic, "movie:<id>" for the stream
+//     state and timed comments of a movie or "user" for the events of the
+//     connected user;
+//   - "ping", answered by a "pong".
+//
+// The ID, when set, is echoed in the answer.
+type SocketMessage struct {
+	Type  string `json:"type" example:"subscribe"`
+	Topic string `json:"topic,omitempty" example:"movie:603"`
+	ID    string `json:"id,omitempty" example:"1"`
+}
+
+// SocketFrame is a message the server sends on the WebSocket: "subscribed",
+// "unsubscribed", "pong", "error", or an "event" of a topic.
+type SocketFrame struct {
+	Type       string      `json:"type" example:"event"`
+	Topic      string      `json:"topic,omitempty" example:"movie:603"`
+	ID         string      `json:"id,omitempty" example:"1"`
+	Data       interface{} `json:"data,omitempty"`
+	Message    string      `json:"message,omitempty"`
+	ServerTime int64       `json:"server_time,omitempty" example:"1760000000000"`
+}
+
+// TimedCommentEvent is pushed to the viewers of a movie when a comment
+// anchored to a moment of it is posted.
+type TimedCommentEvent struct {
+	Type    string      `json:"type" example:"timed_comment"`
+	Comment interface{} `json:"comment"`
+}
+
+// SocketClient is a WebSocket connection of a user. Its messages are queued
+// until its writer sends them; a client that does not keep up is evicted.
+type SocketClient struct {
+	UserID uint
+
+	// legacy clients follow one movie and get its events as they are,
+	// without frames.
+	legacy bool
+
+	send   chan []byte
+	mu     sync.Mutex
+	topics map[string]bool
+	closed bool
+}
+
+// Outgoing returns the messages to write to the connection. It is closed
+// once the client disconnected or was evicted.
+func (c *SocketClient) Outgoing() <-chan []byte {
+	return c.send
+}
+
 type WebSocketService struct {
-	subscribers  sync.Map // map[int][]*websocket.Conn - movieID -> list of websocket connections
+	mu     sync.RWMutex
+	topics map[string]map[*SocketClient]struct{} // topic -> subscribed clients
+
 	StreamStates sync.Map // map[int]map[string]interface{} - movieID -> last stream state
 }
 
 func NewWebSocketService() *WebSocketService {
-	return &WebSocketService{}
+	return &WebSocketService{
+		topics: make(map[string]map[*SocketClient]struct{}),
+	}
+}
+
+// MovieTopic is the topic of the events of a movie.
+func MovieTopic(movieID int) string {
+	return fmt.Sprintf("movie:%d", movieID)
+}
+
+// userTopic is the key of the events of a user, which clients subscribe to
+// as TopicUser.
+func userTopic(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
 }
 
-func (wc *WebSocketService) AddSubscriber(movieID int, ws *websocket.Conn) {
-	var subscribers []*websocket.Conn
-	if val, exists := wc.subscribers.Load(movieID); exists {
-		subscribers = val.([]*websocket.Conn)
-	} else {
-		subscribers = make([]*websocket.Conn, 0)
+// Connect registers a connection of a user.
+func (wc *WebSocketService) Connect(userID uint) *SocketClient {
+	return &SocketClient{
+		UserID: userID,
+		send:   make(chan []byte, socketSendBuffer),
+		topics: make(map[string]bool),
 	}
-	subscribers = append(subscribers, ws)
-	wc.subscribers.Store(movieID, subscribers)
 }
 
-func (wc *WebSocketService) RemoveSubscriber(movieID int, ws *websocket.Conn) {
-	if val, exists := wc.subscribers.Load(movieID); exists {
-		subscribers := val.([]*websocket.Conn)
-		for i, conn := range subscribers {
-			if conn == ws {
-				subscribers = append(subscribers[:i], subscribers[i+1:]...)
-				break
+// ConnectMovie registers a legacy connection following one movie, which gets
+// the events of the movie without frames, starting with its stream state.
+func (wc *WebSocketService) ConnectMovie(userID uint, movieID int) *SocketClient {
+	client := wc.Connect(userID)
+	client.legacy = true
+	wc.subscribe(client, MovieTopic(movieID))
+	if state, exists := wc.StreamStates.Load(movieID); exists {
+		wc.deliver(client, state, MovieTopic(movieID))
+	}
+	return client
+}
+
+// Disconnect unsubscribes a client from every topic and closes its queue.
+func (wc *WebSocketService) Disconnect(client *SocketClient) {
+	client.mu.Lock()
+	if client.closed {
+		client.mu.Unlock()
+		return
+	}
+	client.closed = true
+	close(client.send)
+	topics := client.topics
+	client.topics = nil
+	client.mu.Unlock()
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	for topic := range topics {
+		wc.removeSubscriber(topic, client)
+	}
+}
+
+// Handle answers a message a client sent.
+func (wc *WebSocketService) Handle(client *SocketClient, raw []byte) {
+	var msg SocketMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		wc.reply(client, SocketFrame{Type: "error", Message: errInvalidMessage.Error()})
+		return
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		topic, movieID, err := wc.resolveTopic(client, msg.Topic)
+		if err != nil {
+			wc.reply(client, SocketFrame{Type: "error", ID: msg.ID, Topic: msg.Topic, Message: err.Error()})
+			return
+		}
+		if err := wc.subscribe(client, topic); err != nil {
+			wc.reply(client, SocketFrame{Type: "error", ID: msg.ID, Topic: msg.Topic, Message: err.Error()})
+			return
+		}
+		wc.reply(client, SocketFrame{Type: "subscribed", ID: msg.ID, Topic: msg.Topic})
+		if movieID != 0 {
+			if state, exists := wc.StreamStates.Load(movieID); exists {
+				wc.deliver(client, state, msg.Topic)
 			}
 		}
 
-		if len(subscribers) == 0 {
-			wc.subscribers.Delete(movieID)
-		} else {
-			wc.subscribers.Store(movieID, subscribers)
+	case "unsubscribe":
+		topic, _, err := wc.resolveTopic(client, msg.Topic)
+		if err != nil {
+			wc.reply(client, SocketFrame{Type: "error", ID: msg.ID, Topic: msg.Topic, Message: err.Error()})
+			return
 		}
+		wc.unsubscribe(client, topic)
+		wc.reply(client, SocketFrame{Type: "unsubscribed", ID: msg.ID, Topic: msg.Topic})
+
+	case "ping":
+		wc.reply(client, SocketFrame{Type: "pong", ID: msg.ID, ServerTime: time.Now().UnixMilli()})
+
+	default:
+		wc.reply(client, SocketFrame{Type: "error", ID: msg.ID, Message: errUnknownMessage.Error()})
 	}
 }
 
 func (wc *WebSocketService) UpdateStreamState(movieID int, state map[string]interface{}) {
 	wc.StreamStates.Store(movieID, state)
+	wc.Broadcast(movieID, state)
+}
 
-	var subscribers []*websocket.Conn
-	if val, exists := wc.subscribers.Load(movieID); exists {
-		subscribers = val.([]*websocket.Conn)
+// Broadcast sends a message to every viewer of a movie.
+func (wc *WebSocketService) Broadcast(movieID int, message interface{}) {
+	wc.publish(MovieTopic(movieID), MovieTopic(movieID), message)
+}
+
+// PublishUser sends an event to every connection of a user subscribed to
+// their events.
+func (wc *WebSocketService) PublishUser(userID uint, message interface{}) {
+	wc.publish(userTopic(userID), TopicUser, message)
+}
+
+// publish sends a message to the subscribers of a topic, named as clients
+// know it.
+func (wc *WebSocketService) publish(topic, name string, message interface{}) {
+	wc.mu.RLock()
+	clients := make([]*SocketClient, 0, len(wc.topics[topic]))
+	for client := range wc.topics[topic] {
+		clients = append(clients, client)
 	}
+	wc.mu.RUnlock()
 
-	if len(subscribers) > 0 {
-		stateJSON, err := json.Marshal(state)
-		if err != nil {
-			return
-		}
+	if len(clients) == 0 {
+		return
+	}
 
-		for _, ws := range subscribers {
-			ws.WriteMessage(websocket.TextMessage, stateJSON)
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	framed, err := json.Marshal(SocketFrame{Type: "event", Topic: name, Data: json.RawMessage(raw)})
+	if err != nil {
+		return
+	}
+
+	for _, client := range clients {
+		if client.legacy {
+			wc.queue(client, raw)
+		} else {
+			wc.queue(client, framed)
 		}
 	}
 }
+
+// resolveTopic checks a topic a client names and returns its key, and the
+// movie it is about.
+func (wc *WebSocketService) resolveTopic(client *SocketClient, topic string) (string, int, error) {
+	if topic == TopicUser {
+		return userTopic(client.UserID), 0, nil
+	}
+
+	id, ok := strings.CutPrefix(topic, "movie:")
+	if !ok {
+		return "", 0, ErrInvalidTopic
+	}
+	movieID, err := strconv.Atoi(id)
+	if err != nil || movieID <= 0 {
+		return "", 0, ErrInvalidTopic
+	}
+	return MovieTopic(movieID), movieID, nil
+}
+
+func (wc *WebSocketService) subscribe(client *SocketClient, topic string) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.closed || client.topics[topic] {
+		return nil
+	}
+	if len(client.topics) >= maxSocketTopics {
+		return ErrTooManyTopics
+	}
+	client.topics[topic] = true
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if wc.topics[topic] == nil {
+		wc.topics[topic] = make(map[*SocketClient]struct{})
+	}
+	wc.topics[topic][client] = struct{}{}
+	return nil
+}
+
+func (wc *WebSocketService) unsubscribe(client *SocketClient, topic string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if !client.topics[topic] {
+		return
+	}
+	delete(client.topics, topic)
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	wc.removeSubscriber(topic, client)
+}
+
+// removeSubscriber is called with wc.mu locked.
+func (wc *WebSocketService) removeSubscriber(topic string, client *SocketClient) {
+	delete(wc.topics[topic], client)
+	if len(wc.topics[topic]) == 0 {
+		delete(wc.topics, topic)
+	}
+}
+
+// deliver sends an event of a topic to one client.
+func (wc *WebSocketService) deliver(client *SocketClient, message interface{}, name string) {
+	if client.legacy {
+		wc.reply(client, message)
+		return
+	}
+	wc.reply(client, SocketFrame{Type: "event", Topic: name, Data: message})
+}
+
+func (wc *WebSocketService) reply(client *SocketClient, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	wc.queue(client, data)
+}
+
+// queue hands a message to the writer of a client. A client whose queue is
+// full is not reading fast enough: it is evicted rather than holding up the
+// publishers.
+func (wc *WebSocketService) queue(client *SocketClient, data []byte) {
+	client.mu.Lock()
+	if client.closed {
+		client.mu.Unlock()
+		return
+	}
+	select {
+	case client.send <- data:
+		client.mu.Unlock()
+		return
+	default:
+	}
+	client.mu.Unlock()
+
+	Logger.Warn(fmt.Sprintf("Evicting WebSocket client of user %d: too slow", client.UserID))
+	wc.Disconnect(client)
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// nextFrame reads the next queued message of a client.
+func nextFrame(t *testing.T, client *SocketClient) SocketFrame {
+	t.Helper()
+	select {
+	case data, ok := <-client.Outgoing():
+		if !ok {
+			t.Fatal("client was disconnected")
+		}
+		var frame SocketFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			t.Fatalf("invalid frame %s: %v", data, err)
+		}
+		return frame
+	default:
+		t.Fatal("no message queued")
+		return SocketFrame{}
+	}
+}
+
+func TestSocketHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    SocketFrame
+	}{
+		{name: "subscribe movie", message: `{"type":"subscribe","topic":"movie:603","id":"1"}`, want: SocketFrame{Type: "subscribed", Topic: "movie:603", ID: "1"}},
+		{name: "subscribe user", message: `{"type":"subscribe","topic":"user"}`, want: SocketFrame{Type: "subscribed", Topic: "user"}},
+		{name: "unsubscribe", message: `{"type":"unsubscribe","topic":"movie:603","id":"2"}`, want: SocketFrame{Type: "unsubscribed", Topic: "movie:603", ID: "2"}},
+		{name: "other user", message: `{"type":"subscribe","topic":"user:2"}`, want: SocketFrame{Type: "error", Topic: "user:2", Message: ErrInvalidTopic.Error()}},
+		{name: "invalid movie", message: `{"type":"subscribe","topic":"movie:-1"}`, want: SocketFrame{Type: "error", Topic: "movie:-1", Message: ErrInvalidTopic.Error()}},
+		{name: "unknown type", message: `{"type":"dance","id":"3"}`, want: SocketFrame{Type: "error", ID: "3", Message: errUnknownMessage.Error()}},
+		{name: "invalid json", message: `{"type":`, want: SocketFrame{Type: "error", Message: errInvalidMessage.Error()}},
+	}
+
+	ws := NewWebSocketService()
+	client := ws.Connect(1)
+	defer ws.Disconnect(client)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws.Handle(client, []byte(tt.message))
+			if got := nextFrame(t, client); got != tt.want {
+				t.Errorf("Handle(%s) = %+v, want %+v", tt.message, got, tt.want)
+			}
+		})
+	}
+
+	ws.Handle(client, []byte(`{"type":"ping","id":"4"}`))
+	if pong := nextFrame(t, client); pong.Type != "pong" || pong.ID != "4" || pong.ServerTime == 0 {
+		t.Errorf("ping answered with %+v", pong)
+	}
+}
+
+func TestSocketPublish(t *testing.T) {
+	ws := NewWebSocketService()
+	client := ws.Connect(1)
+	other := ws.Connect(2)
+	legacy := ws.ConnectMovie(3, 603)
+	defer ws.Disconnect(client)
+	defer ws.Disconnect(other)
+	defer ws.Disconnect(legacy)
+
+	ws.Handle(client, []byte(`{"type":"subscribe","topic":"user"}`))
+	ws.Handle(client, []byte(`{"type":"subscribe","topic":"movie:603"}`))
+	nextFrame(t, client)
+	nextFrame(t, client)
+
+	ws.PublishUser(1, map[string]string{"status": "ready"})
+	ws.PublishUser(2, map[string]string{"status": "ready"})
+	if frame := nextFrame(t, client); frame.Type != "event" || frame.Topic != TopicUser {
+		t.Errorf("user event framed as %+v", frame)
+	}
+	if len(other.Outgoing()) != 0 {
+		t.Error("user event delivered to an unsubscribed client")
+	}
+
+	ws.Broadcast(603, map[string]string{"type": "stream_state"})
+	if frame := nextFrame(t, client); frame.Type != "event" || frame.Topic != "movie:603" {
+		t.Errorf("movie event framed as %+v", frame)
+	}
+	// Legacy clients get the event as it is.
+	if frame := nextFrame(t, legacy); frame.Type != "stream_state" {
+		t.Errorf("legacy client got %+v", frame)
+	}
+}
+
+func TestSocketEviction(t *testing.T) {
+	ws := NewWebSocketService()
+	slow := ws.Connect(1)
+	fast := ws.Connect(2)
+	defer ws.Disconnect(fast)
+
+	for _, client := range []*SocketClient{slow, fast} {
+		ws.Handle(client, []byte(`{"type":"subscribe","topic":"movie:603"}`))
+	}
+	nextFrame(t, fast)
+
+	// The slow client never reads, and is evicted once its queue is full.
+	for i := 0; i < socketSendBuffer; i++ {
+		ws.Broadcast(603, map[string]int{"n": i})
+		nextFrame(t, fast)
+	}
+
+	slow.mu.Lock()
+	closed := slow.closed
+	slow.mu.Unlock()
+	if !closed {
+		t.Fatal("slow client was not evicted")
+	}
+	for range slow.Outgoing() {
+	}
+
+	ws.mu.RLock()
+	_, subscribed := ws.topics[MovieTopic(603)][slow]
+	subscribers := len(ws.topics[MovieTopic(603)])
+	ws.mu.RUnlock()
+	if subscribed || subscribers != 1 {
+		t.Errorf("after eviction the topic has %d subscribers, slow client subscribed %v", subscribers, subscribed)
+	}
+
+	// Publishing to an evicted client is a no-op.
+	ws.Broadcast(603, map[string]int{"n": socketSendBuffer})
+	nextFrame(t, fast)
+}